@@ -17,6 +17,7 @@ package vas
 
 import (
 	"testing"
+	"github.com/blocktree/openwallet/openwallet"
 	"github.com/pborman/uuid"
 )
 
@@ -168,4 +169,143 @@ func TestFullAddress (t *testing.T) {
 	for i := 0;i<20000000;i++ {
 		dic[uuid.NewUUID().String()] = uuid.NewUUID().String()
 	}
-}
\ No newline at end of file
+}
+
+func TestExtractMemo(t *testing.T) {
+	trx := &Transaction{
+		Vouts: []*Vout{
+			{N: 0, Addr: "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", Value: "1", ScriptPubKey: "76a9148c0bceb59d452b3e077f73a420b8bfe09e0550a788ac", Type: "pubkeyhash"},
+			{N: 1, Value: "0", ScriptPubKey: "6a0775736572313233", Type: "nulldata"},
+		},
+	}
+	memo, memoHex := extractMemo(trx)
+	if memo != "user123" || memoHex != "75736572313233" {
+		t.Errorf("extractMemo unexpected result: %s, %s", memo, memoHex)
+	}
+
+	trx.Vouts[1].ScriptPubKey = "6a02ff00"
+	memo, memoHex = extractMemo(trx)
+	if memo != "" || memoHex != "ff00" {
+		t.Errorf("extractMemo unexpected result: %s, %s", memo, memoHex)
+	}
+}
+
+func TestExtractTxOutputWithMemo(t *testing.T) {
+	wm := NewWalletManager()
+	bs := NewVASBlockScanner(wm)
+	trx := &Transaction{
+		TxID: "memo",
+		Vouts: []*Vout{
+			{N: 0, Addr: "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", Value: "1", ScriptPubKey: "76a9148c0bceb59d452b3e077f73a420b8bfe09e0550a788ac", Type: "pubkeyhash"},
+			{N: 1, Value: "0", ScriptPubKey: "6a0775736572313233", Type: "nulldata"},
+		},
+	}
+	scanAddressFunc := func(address string) (string, bool) {
+		return "shared", address == "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV"
+	}
+
+	//共享充值地址按备注归属到用户账户
+	bs.SetBlockScanMemoFunc(func(address, memo string) (string, bool) {
+		if address == "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV" && memo == "user123" {
+			return "user123", true
+		}
+		return "", false
+	})
+	result := &ExtractResult{extractData: make(map[string]*openwallet.TxExtractData)}
+	bs.extractTxOutput(trx, result, scanAddressFunc)
+	data := result.extractData["user123"]
+	if len(result.extractData) != 1 || data == nil || len(data.TxOutputs) != 1 {
+		t.Errorf("memo deposit is not routed: %+v", result.extractData)
+		return
+	}
+	output := data.TxOutputs[0]
+	if output.Address != "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV" || output.Amount != "1" || output.GetExtParam().Get("memo").String() != "user123" {
+		t.Errorf("unexpected memo deposit output: %+v", output)
+		return
+	}
+
+	//备注未匹配时按地址归属
+	bs.SetBlockScanMemoFunc(func(address, memo string) (string, bool) {
+		return "", false
+	})
+	result = &ExtractResult{extractData: make(map[string]*openwallet.TxExtractData)}
+	bs.extractTxOutput(trx, result, scanAddressFunc)
+	if data := result.extractData["shared"]; data == nil || len(data.TxOutputs) != 1 {
+		t.Errorf("unmatched memo deposit should belong to the address: %+v", result.extractData)
+	}
+}
+
+func TestOmniPayload(t *testing.T) {
+	trx := &Transaction{
+		Vins: []*Vin{
+			{Addr: "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", Value: "0.0001"},
+			{Addr: "VY1kyYTC4HS3VJtGyUnHnDhmgkHCDRRf6m", Value: "0.0005"},
+		},
+		Vouts: []*Vout{
+			{N: 0, Addr: "VY1kyYTC4HS3VJtGyUnHnDhmgkHCDRRf6m", Value: "0.0003", Type: "pubkeyhash"},
+			{N: 1, Value: "0", ScriptPubKey: "6a146f6d6e69000000000000001f0000000005f5e100", Type: "nulldata"},
+			{N: 2, Addr: "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", Value: "0.00000546", Type: "pubkeyhash"},
+		},
+	}
+	payload, ok := trx.OmniPayload()
+	if !ok || payload.TxType != OmniSimpleSend || payload.PropertyID != 31 || payload.Amount != 100000000 {
+		t.Errorf("OmniPayload unexpected result: %+v", payload)
+		return
+	}
+	sender := trx.OmniSender()
+	if sender != "VY1kyYTC4HS3VJtGyUnHnDhmgkHCDRRf6m" {
+		t.Errorf("OmniSender unexpected result: %s", sender)
+	}
+	if reference := trx.OmniReference(sender); reference != "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV" {
+		t.Errorf("OmniReference unexpected result: %s", reference)
+	}
+	if memo, _ := extractMemo(trx); memo != "" {
+		t.Errorf("extractMemo should skip omni payload: %s", memo)
+	}
+}
+
+func TestExtractOmniTransactionWithoutOmniLayer(t *testing.T) {
+	wm := NewWalletManager()
+	wm.Config.OmniSupport = true
+	bs := NewVASBlockScanner(wm)
+	trx := &Transaction{
+		TxID: "omni",
+		Vins: []*Vin{
+			{Addr: "VY1kyYTC4HS3VJtGyUnHnDhmgkHCDRRf6m", Value: "0.0005"},
+		},
+		Vouts: []*Vout{
+			{N: 0, Addr: "VY1kyYTC4HS3VJtGyUnHnDhmgkHCDRRf6m", Value: "0.0003", Type: "pubkeyhash"},
+			{N: 1, Value: "0", ScriptPubKey: "6a146f6d6e69000000000000001f0000000005f5e100", Type: "nulldata"},
+			{N: 2, Addr: "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", Value: "0.00000546", Type: "pubkeyhash"},
+		},
+	}
+	scanAddressFunc := func(address string) (string, bool) {
+		return "A", address == "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV"
+	}
+	newResult := func() *ExtractResult {
+		return &ExtractResult{extractContractData: make(map[string]*openwallet.TxExtractData)}
+	}
+
+	//未配置代币精度，等待omni层可用后再提取
+	result := newResult()
+	if bs.extractOmniTransaction(trx, result, scanAddressFunc) || len(result.extractContractData) != 0 {
+		t.Errorf("extractOmniTransaction should defer unknown decimals")
+	}
+
+	//与关注地址无关的交易不影响提取结果
+	if !bs.extractOmniTransaction(trx, newResult(), func(address string) (string, bool) { return "", false }) {
+		t.Errorf("extractOmniTransaction should ignore unrelated transaction")
+	}
+
+	//按配置的代币精度解析金额
+	wm.Config.OmniPropertyDecimals[31] = 0
+	result = newResult()
+	if !bs.extractOmniTransaction(trx, result, scanAddressFunc) {
+		t.Errorf("extractOmniTransaction failed")
+		return
+	}
+	data := result.extractContractData["A"]
+	if data == nil || len(data.TxOutputs) != 1 || data.TxOutputs[0].Amount != "100000000" || data.Transaction.Decimal != 0 {
+		t.Errorf("extractOmniTransaction unexpected result: %+v", data)
+	}
+}
@@ -16,12 +16,14 @@
 package vas
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/tidwall/gjson"
 	"path/filepath"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/asdine/storm"
 	"github.com/blocktree/openwallet/openwallet"
@@ -34,26 +36,35 @@ const (
 	maxExtractingSize = 6 //并发的扫描线程数
 )
 
+//BlockScanMemoFunc 通过接收地址和交易备注查找入账所属的sourceKey，用于共享充值地址按备注区分用户
+type BlockScanMemoFunc func(address, memo string) (string, bool)
+
 //VASBlockScanner bitcoin的区块链扫描器
 type VASBlockScanner struct {
 	*openwallet.BlockScannerBase
 
-	CurrentBlockHeight   uint64         //当前区块高度
-	extractingCH         chan struct{}  //扫描工作令牌
-	wm                   *WalletManager //钱包管理者
-	IsScanMemPool        bool           //是否扫描交易池
-	RescanLastBlockCount uint64         //重扫上N个区块数量
+	CurrentBlockHeight   uint64            //当前区块高度
+	extractingCH         chan struct{}     //扫描工作令牌
+	wm                   *WalletManager    //钱包管理者
+	IsScanMemPool        bool              //是否扫描交易池
+	RescanLastBlockCount uint64            //重扫上N个区块数量
+	scanMemoFunc         BlockScanMemoFunc //按备注归属入账的查找方法
+	depositIndex         *depositIndex     //未确认入账花费的输出索引
 
 }
 
 //ExtractResult 扫描完成的提取结果
 type ExtractResult struct {
 	extractData map[string]*openwallet.TxExtractData
-	TxID        string
-	BlockHash   string
-	BlockHeight uint64
-	BlockTime   int64
-	Success     bool
+	//代币交易的提取数据，与主链币交易分开通知
+	extractContractData map[string]*openwallet.TxExtractData
+	//交易单输入引用的输出，用于检查未确认入账是否被双花
+	spentOutpoints []string
+	TxID           string
+	BlockHash      string
+	BlockHeight    uint64
+	BlockTime      int64
+	Success        bool
 }
 
 //SaveResult 保存结果
@@ -73,6 +84,7 @@ func NewVASBlockScanner(wm *WalletManager) *VASBlockScanner {
 	bs.wm = wm
 	bs.IsScanMemPool = true
 	bs.RescanLastBlockCount = 0
+	bs.depositIndex = newDepositIndex()
 
 	//设置扫描任务
 	bs.SetTask(bs.ScanBlockTask)
@@ -363,7 +375,13 @@ func (bs *VASBlockScanner) BatchExtractTransaction(blockHeight uint64, blockHash
 
 			if gets.Success {
 
+				//检查未确认入账的冲突，记录新的未确认入账
+				bs.checkDepositConflicts(&gets)
+
 				notifyErr := bs.newExtractDataNotify(height, gets.extractData)
+				if notifyErr == nil && len(gets.extractContractData) > 0 {
+					notifyErr = bs.newExtractDataNotify(height, gets.extractContractData)
+				}
 				//saveErr := bs.SaveRechargeToWalletDB(height, gets.Recharges)
 				if notifyErr != nil {
 					failed++ //标记保存失败数
@@ -463,9 +481,11 @@ func (bs *VASBlockScanner) ExtractTransaction(blockHeight uint64, blockHash stri
 
 	var (
 		result = ExtractResult{
-			BlockHeight: blockHeight,
-			TxID:        txid,
-			extractData: make(map[string]*openwallet.TxExtractData),
+			BlockHeight:         blockHeight,
+			BlockHash:           blockHash,
+			TxID:                txid,
+			extractData:         make(map[string]*openwallet.TxExtractData),
+			extractContractData: make(map[string]*openwallet.TxExtractData),
 		}
 	)
 
@@ -495,8 +515,9 @@ func (bs *VASBlockScanner) ExtractTransaction(blockHeight uint64, blockHash stri
 func (bs *VASBlockScanner) extractTransaction(trx *Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanAddressFunc) {
 
 	var (
-		success = true
-		txType  = uint64(0)
+		success     = true
+		omniPending = false
+		txType      = uint64(0)
 	)
 
 	if trx == nil {
@@ -512,8 +533,7 @@ func (bs *VASBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 
 			if len(input.Coinbase) > 0 {
 				//coinbase skip
-				success = true
-				break
+				continue
 			}
 
 			//如果input中没有地址，需要查上一笔交易的output提取
@@ -546,6 +566,12 @@ func (bs *VASBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 
 		if success {
 
+			for _, input := range vin {
+				if len(input.Coinbase) == 0 {
+					result.spentOutpoints = append(result.spentOutpoints, outpointKey(input.TxID, input.Vout))
+				}
+			}
+
 			//提取出账部分记录
 			from, totalSpent := bs.extractTxInput(trx, result, scanAddressFunc)
 			//bs.wm.Log.Debug("from:", from, "totalSpent:", totalSpent)
@@ -554,11 +580,17 @@ func (bs *VASBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 			to, totalReceived := bs.extractTxOutput(trx, result, scanAddressFunc)
 			//bs.wm.Log.Debug("to:", to, "totalReceived:", totalReceived)
 
+			fees := totalSpent.Sub(totalReceived)
+			if trx.IsCoinBase {
+				//挖矿收入没有手续费
+				fees = decimal.Zero
+			}
+
 			for _, extractData := range result.extractData {
 				tx := &openwallet.Transaction{
 					From: from,
 					To:   to,
-					Fees: totalSpent.Sub(totalReceived).StringFixed(bs.wm.Decimal()),
+					Fees: fees.StringFixed(bs.wm.Decimal()),
 					Coin: openwallet.Coin{
 						Symbol:     bs.wm.Symbol(),
 						IsContract: false,
@@ -571,6 +603,10 @@ func (bs *VASBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 					Status:      openwallet.TxStatusSuccess,
 					TxType:      txType,
 				}
+				if memo, memoHex := extractMemo(trx); len(memoHex) > 0 {
+					tx.SetExtParam("memo", memo)
+					tx.SetExtParam("memoHex", memoHex)
+				}
 				wxID := openwallet.GenTransactionWxID(tx)
 				tx.WxID = wxID
 				extractData.Transaction = tx
@@ -578,9 +614,14 @@ func (bs *VASBlockScanner) extractTransaction(trx *Transaction, result *ExtractR
 				//bs.wm.Log.Debug("Transaction:", extractData.Transaction)
 			}
 
+			//提取omni代币交易，无法确定代币金额时记录未扫区块，等待重新提取
+			if bs.wm.Config.OmniSupport && !bs.extractOmniTransaction(trx, result, scanAddressFunc) {
+				omniPending = true
+			}
+
 		}
 
-		success = true
+		success = !omniPending
 
 	}
 	result.Success = success
@@ -602,6 +643,11 @@ func (bs *VASBlockScanner) extractTxInput(trx *Transaction, result *ExtractResul
 
 		//in := vin[i]
 
+		//coinbase输入没有来源地址
+		if len(output.Coinbase) > 0 {
+			continue
+		}
+
 		txid := output.TxID
 		vout := output.Vout
 		//
@@ -668,10 +714,11 @@ func (bs *VASBlockScanner) extractTxOutput(trx *Transaction, result *ExtractResu
 	txid := trx.TxID
 	//bs.wm.Log.Debug("vout:", vout.Array())
 	createAt := time.Now().Unix()
+	memo, memoHex := extractMemo(trx)
 	for _, output := range vout {
 
 		//OP_RETURN的脚本，不用处理地址输出
-		if output.Type == "OP_RETURN" {
+		if output.IsNullData() {
 			continue
 		}
 
@@ -679,6 +726,14 @@ func (bs *VASBlockScanner) extractTxOutput(trx *Transaction, result *ExtractResu
 		n := output.N
 		addr := output.Addr
 		sourceKey, ok := scanAddressFunc(addr)
+
+		//共享充值地址，通过备注归属入账
+		if bs.scanMemoFunc != nil && len(memoHex) > 0 {
+			if memoKey, found := bs.scanMemoFunc(addr, memo); found {
+				sourceKey, ok = memoKey, true
+			}
+		}
+
 		if ok {
 
 			//a := wallet.GetAddress(addr)
@@ -701,6 +756,15 @@ func (bs *VASBlockScanner) extractTxOutput(trx *Transaction, result *ExtractResu
 
 			//保存utxo到扩展字段
 			outPut.SetExtParam("scriptPubKey", output.ScriptPubKey)
+			if len(memoHex) > 0 {
+				outPut.SetExtParam("memo", memo)
+				outPut.SetExtParam("memoHex", memoHex)
+			}
+			//挖矿收入需要达到成熟高度才可花费
+			if trx.IsCoinBase {
+				outPut.SetExtParam("coinbase", true)
+				outPut.SetExtParam("maturityHeight", trx.BlockHeight+bs.wm.Config.CoinbaseMaturity)
+			}
 			outPut.CreateAt = createAt
 			outPut.BlockHeight = trx.BlockHeight
 			outPut.BlockHash = trx.BlockHash
@@ -728,6 +792,147 @@ func (bs *VASBlockScanner) extractTxOutput(trx *Transaction, result *ExtractResu
 	return to, totalAmount
 }
 
+//extractOmniTransaction 提取omni代币交易，优先使用omni层的解析结果，节点不支持时按配置的代币精度解析OP_RETURN载荷
+//涉及关注地址但无法确定代币精度时返回false
+func (bs *VASBlockScanner) extractOmniTransaction(trx *Transaction, result *ExtractResult, scanAddressFunc openwallet.BlockScanAddressFunc) bool {
+
+	payload, ok := trx.OmniPayload()
+	if !ok || payload.TxType != OmniSimpleSend {
+		return true
+	}
+
+	var (
+		sender     = trx.OmniSender()
+		receiver   = trx.OmniReference(sender)
+		decimals   = int32(OmniDivisibleDecimals)
+		amount     = decimal.New(int64(payload.Amount), -decimals)
+		status     = openwallet.TxStatusSuccess
+		reason     = ""
+		validated  = false
+		propertyID = payload.PropertyID
+		createAt   = time.Now().Unix()
+	)
+
+	omniTx, err := bs.wm.GetOmniTransaction(trx.TxID)
+	if err == nil {
+		sender = omniTx.SendingAddress
+		receiver = omniTx.ReferenceAddress
+		propertyID = omniTx.PropertyID
+		if !omniTx.Divisible {
+			decimals = 0
+		}
+		amount, _ = decimal.NewFromString(omniTx.Amount)
+		validated = omniTx.Validated
+		if omniTx.Validated && !omniTx.Valid {
+			status = openwallet.TxStatusFail
+			reason = omniTx.InvalidReason
+		}
+	} else {
+		bs.wm.Log.Std.Debug("omni transaction: %s can not get from omni layer, unexpected error: %v", trx.TxID, err)
+		_, senderFound := scanAddressFunc(sender)
+		_, receiverFound := scanAddressFunc(receiver)
+		if !senderFound && !receiverFound {
+			return true
+		}
+		configured, exist := bs.wm.Config.OmniPropertyDecimals[propertyID]
+		if !exist {
+			bs.wm.Log.Std.Warning("omni transaction: %s decimals of property: %d is unknown, wait for omni layer", trx.TxID, propertyID)
+			return false
+		}
+		decimals = configured
+		amount = decimal.New(int64(payload.Amount), -decimals)
+	}
+
+	contractAddress := fmt.Sprintf("%d", propertyID)
+	contractID := openwallet.GenContractID(bs.wm.Symbol(), contractAddress)
+	coin := openwallet.Coin{
+		Symbol:     bs.wm.Symbol(),
+		IsContract: true,
+		ContractID: contractID,
+		Contract: openwallet.SmartContract{
+			ContractID: contractID,
+			Symbol:     bs.wm.Symbol(),
+			Address:    contractAddress,
+			Protocol:   "omni",
+			Decimals:   uint64(decimals),
+		},
+	}
+	amountStr := amount.StringFixed(decimals)
+
+	getExtractData := func(sourceKey string) *openwallet.TxExtractData {
+		ed := result.extractContractData[sourceKey]
+		if ed == nil {
+			ed = openwallet.NewBlockExtractData()
+			result.extractContractData[sourceKey] = ed
+		}
+		return ed
+	}
+
+	if sourceKey, ok := scanAddressFunc(sender); ok {
+		input := openwallet.TxInput{}
+		input.TxID = trx.TxID
+		input.Address = sender
+		input.Amount = amountStr
+		input.Coin = coin
+		input.Index = 0
+		input.Sid = openwallet.GenTxInputSID(trx.TxID, bs.wm.Symbol(), contractID, 0)
+		input.CreateAt = createAt
+		input.BlockHeight = trx.BlockHeight
+		input.BlockHash = trx.BlockHash
+		ed := getExtractData(sourceKey)
+		ed.TxInputs = append(ed.TxInputs, &input)
+	}
+
+	if sourceKey, ok := scanAddressFunc(receiver); ok {
+		outPut := openwallet.TxOutPut{}
+		outPut.TxID = trx.TxID
+		outPut.Address = receiver
+		outPut.Amount = amountStr
+		outPut.Coin = coin
+		outPut.Index = 0
+		outPut.Sid = openwallet.GenTxOutPutSID(trx.TxID, bs.wm.Symbol(), contractID, 0)
+		outPut.CreateAt = createAt
+		outPut.BlockHeight = trx.BlockHeight
+		outPut.BlockHash = trx.BlockHash
+		outPut.Confirm = int64(trx.Confirmations)
+		ed := getExtractData(sourceKey)
+		ed.TxOutputs = append(ed.TxOutputs, &outPut)
+	}
+
+	for _, extractData := range result.extractContractData {
+		tx := &openwallet.Transaction{
+			From:        []string{sender + ":" + amountStr},
+			To:          []string{receiver + ":" + amountStr},
+			Fees:        "0",
+			Coin:        coin,
+			BlockHash:   trx.BlockHash,
+			BlockHeight: trx.BlockHeight,
+			TxID:        trx.TxID,
+			Decimal:     decimals,
+			ConfirmTime: trx.Blocktime,
+			Status:      status,
+			Reason:      reason,
+		}
+		tx.SetExtParam("omniValidated", validated)
+		tx.WxID = openwallet.GenTransactionWxID(tx)
+		extractData.Transaction = tx
+	}
+	return true
+}
+
+//extractMemo 提取交易单OP_RETURN备注，memo为UTF-8文本（非法UTF-8时为空），memoHex为原始数据
+func extractMemo(trx *Transaction) (string, string) {
+	data := trx.Memo()
+	if len(data) == 0 {
+		return "", ""
+	}
+	memo := ""
+	if utf8.Valid(data) {
+		memo = string(data)
+	}
+	return memo, hex.EncodeToString(data)
+}
+
 //newExtractDataNotify 发送通知
 func (bs *VASBlockScanner) newExtractDataNotify(height uint64, extractData map[string]*openwallet.TxExtractData) error {
 
@@ -874,6 +1079,11 @@ func (bs *VASBlockScanner) GetCurrentBlockHeader() (*openwallet.BlockHeader, err
 	return &openwallet.BlockHeader{Height: blockHeight, Hash: hash}, nil
 }
 
+//SetBlockScanMemoFunc 设置按交易备注归属入账的查找方法
+func (bs *VASBlockScanner) SetBlockScanMemoFunc(scanMemoFunc BlockScanMemoFunc) {
+	bs.scanMemoFunc = scanMemoFunc
+}
+
 //SetRescanBlockHeight 重置区块链扫描高度
 func (bs *VASBlockScanner) SetRescanBlockHeight(height uint64) error {
 	if height <= 0 {
@@ -949,6 +1159,9 @@ func (bs *VASBlockScanner) ExtractTransactionData(txid string, scanTargetFunc op
 		txs = append(txs, data)
 		extData[key] = txs
 	}
+	for key, data := range result.extractContractData {
+		extData[key] = append(extData[key], data)
+	}
 	return extData, nil
 }
 
@@ -1333,15 +1546,32 @@ func (wm *WalletManager) calculateUnspent(utxos []*Unspent) map[string]*openwall
 
 	addrBalanceMap := make(map[string]*openwallet.Balance)
 
+	for addr, detail := range wm.calculateUnspentDetail(utxos) {
+		addrBalanceMap[addr] = detail.Balance
+	}
+
+	return addrBalanceMap
+
+}
+
+//calculateUnspentDetail 通过未花计算余额明细，未成熟的挖矿收入不计入可用余额
+func (wm *WalletManager) calculateUnspentDetail(utxos []*Unspent) map[string]*BalanceDetail {
+
+	addrBalanceMap := make(map[string]*BalanceDetail)
+
 	for _, utxo := range utxos {
 
 		obj, exist := addrBalanceMap[utxo.Address]
 		if !exist {
-			obj = &openwallet.Balance{}
+			obj = &BalanceDetail{
+				Balance:         &openwallet.Balance{},
+				ImmatureBalance: "0",
+			}
 		}
 
 		tu, _ := decimal.NewFromString(obj.UnconfirmBalance)
 		tb, _ := decimal.NewFromString(obj.ConfirmBalance)
+		ti, _ := decimal.NewFromString(obj.ImmatureBalance)
 
 		if utxo.Spendable {
 			if utxo.Confirmations > 0 {
@@ -1351,13 +1581,17 @@ func (wm *WalletManager) calculateUnspent(utxos []*Unspent) map[string]*openwall
 				u, _ := decimal.NewFromString(utxo.Amount)
 				tu = tu.Add(u)
 			}
+		} else if utxo.IsImmature(wm.Config.CoinbaseMaturity) {
+			i, _ := decimal.NewFromString(utxo.Amount)
+			ti = ti.Add(i)
 		}
 
 		obj.Symbol = wm.Symbol()
 		obj.Address = utxo.Address
 		obj.ConfirmBalance = tb.String()
 		obj.UnconfirmBalance = tu.String()
-		obj.Balance = tb.Add(tu).String()
+		obj.Balance.Balance = tb.Add(tu).String()
+		obj.ImmatureBalance = ti.String()
 
 		addrBalanceMap[utxo.Address] = obj
 	}
@@ -1366,6 +1600,40 @@ func (wm *WalletManager) calculateUnspent(utxos []*Unspent) map[string]*openwall
 
 }
 
+//GetBalanceDetailByAddress 获取地址余额明细，包括未成熟的挖矿收入
+func (wm *WalletManager) GetBalanceDetailByAddress(address ...string) ([]*BalanceDetail, error) {
+
+	utxos, err := wm.ListUnspent(0, address...)
+	if err != nil {
+		return nil, err
+	}
+
+	addrBalanceMap := wm.calculateUnspentDetail(utxos)
+	addrBalanceArr := make([]*BalanceDetail, 0)
+	for _, a := range address {
+
+		var obj *BalanceDetail
+		if b, exist := addrBalanceMap[a]; exist {
+			obj = b
+		} else {
+			obj = &BalanceDetail{
+				Balance: &openwallet.Balance{
+					Symbol:           wm.Symbol(),
+					Address:          a,
+					Balance:          "0",
+					UnconfirmBalance: "0",
+					ConfirmBalance:   "0",
+				},
+				ImmatureBalance: "0",
+			}
+		}
+
+		addrBalanceArr = append(addrBalanceArr, obj)
+	}
+
+	return addrBalanceArr, nil
+}
+
 //SupportBlockchainDAI 支持外部设置区块链数据访问接口
 //@optional
 func (bs *VASBlockScanner) SupportBlockchainDAI() bool {
@@ -32,6 +32,15 @@ const (
 	Decimals  = int32(8)
 )
 
+const (
+	//交易单输入输出排序策略
+	TxOrderBIP69  = "bip69"  //按BIP69字典序排列
+	TxOrderRandom = "random" //随机排列
+
+	//指定输出顺序时，OP_RETURN数据输出的占位名称
+	TxOutputNullData = "nulldata"
+)
+
 var (
 	MainNetAddressPrefix = vasTransaction.AddressPrefix{P2PKHPrefix: []byte{0x46}, P2WPKHPrefix: []byte{0x05}, P2SHPrefix: nil, Bech32Prefix: "vas"}
 	TestNetAddressPrefix = vasTransaction.AddressPrefix{P2PKHPrefix: []byte{0x46}, P2WPKHPrefix: []byte{0x05}, P2SHPrefix: nil, Bech32Prefix: "vas"}
@@ -67,6 +76,8 @@ type WalletConfig struct {
 	CoreWalletWatchOnly bool
 	//最大的输入数量
 	MaxTxInputs int
+	//标准交易单最大重量
+	MaxTxWeight int64
 	//本地数据库文件路径
 	DBPath string
 	//备份路径
@@ -83,6 +94,12 @@ type WalletConfig struct {
 	SumAddress string
 	//汇总执行间隔时间
 	CycleSeconds time.Duration
+	//汇总utxo数量阀值
+	SumUTXOThreshold int
+	//汇总费率上限，当前费率高于上限时推迟汇总，0为不限制
+	MaxSumFeeRate decimal.Decimal
+	//汇总utxo最少确认数
+	SumConfirms uint64
 	//默认配置内容
 	DefaultConfig string
 	//曲线类型
@@ -103,6 +120,8 @@ type WalletConfig struct {
 	OmniRPCPassword string
 	//是否支持omni
 	OmniSupport bool
+	//omni代币精度，omni层不可用时用于解析载荷金额，未配置的代币等omni层可用后再提取
+	OmniPropertyDecimals map[uint64]int32
 	//主网地址前缀
 	MainNetAddressPrefix vasTransaction.AddressPrefix
 	//测试网地址前缀
@@ -113,6 +132,44 @@ type WalletConfig struct {
 	MinFees decimal.Decimal
 	//数据目录
 	DataDir string
+	//挖矿收入成熟所需确认数
+	CoinbaseMaturity uint64
+	//交易单输入输出默认排序策略
+	TxOrderPolicy string
+	//交易单锁定utxo的有效时间，超时未广播自动释放
+	UTXOReserveTTL time.Duration
+	//广播前检查的费率上限（每KB），超过视为异常手续费，0为不限制
+	MaxFeeRate decimal.Decimal
+	//替换交易单最少需要增加的费率（每KB），BIP125规则4
+	IncrementalRelayFee decimal.Decimal
+	//粉尘限额，低于限额的找零并入手续费
+	DustLimit decimal.Decimal
+	//重新广播交易单的其他节点API
+	BroadcastAPIs []string
+	//已广播交易单的跟踪间隔时间
+	TxTrackCycle time.Duration
+	//已广播交易单的跟踪期限，超过后仍未确认视为过期
+	TxTrackExpire time.Duration
+	//交易单重新广播的最小间隔，每次重新广播后间隔加倍
+	TxRebroadcastInterval time.Duration
+	//已广播交易单达到的确认数视为已确认
+	TxTrackConfirms uint64
+	//未确认入账的跟踪期限，超过后仍未确认（例如被交易池清除）不再跟踪冲突
+	PendingDepositExpire time.Duration
+	//签名策略
+	SignPolicy *SignPolicy
+	//签名器类型：hd, remote, core
+	SignerType string
+	//远程签名服务API
+	RemoteSignerAPI string
+	//远程签名服务访问令牌
+	RemoteSignerToken string
+	//远程签名服务请求超时时间
+	RemoteSignerTimeout time.Duration
+	//使用核心钱包密钥签名的资产账户
+	CoreWalletAccounts []string
+	//核心钱包签名时的解锁时长
+	WalletUnlockTime time.Duration
 }
 
 func NewConfig(symbol string, curveType uint32, decimals int32) *WalletConfig {
@@ -149,6 +206,8 @@ func NewConfig(symbol string, curveType uint32, decimals int32) *WalletConfig {
 	c.CoreWalletWatchOnly = true
 	//最大的输入数量
 	c.MaxTxInputs = 50
+	//标准交易单最大重量
+	c.MaxTxWeight = 400000
 	//本地数据库文件路径
 	c.DBPath = filepath.Join("data", strings.ToLower(c.Symbol), "db")
 	//备份路径
@@ -165,6 +224,12 @@ func NewConfig(symbol string, curveType uint32, decimals int32) *WalletConfig {
 	c.SumAddress = ""
 	//汇总执行间隔时间
 	c.CycleSeconds = time.Second * 10
+	//汇总utxo数量阀值
+	c.SumUTXOThreshold = 50
+	//汇总费率上限
+	c.MaxSumFeeRate = decimal.Zero
+	//汇总utxo最少确认数
+	c.SumConfirms = 1
 	//核心钱包密码，配置有值用于自动解锁钱包
 	c.WalletPassword = ""
 	//后台数据源类型
@@ -173,10 +238,46 @@ func NewConfig(symbol string, curveType uint32, decimals int32) *WalletConfig {
 	c.SupportSegWit = true
 	//是否支持omni
 	c.OmniSupport = false
+	//omni代币精度
+	c.OmniPropertyDecimals = make(map[uint64]int32)
+	//Omni代币转账最低成本，默认为粉尘限额
+	c.OmniTransferCost = "0.00000546"
 	//小数位精度
 	c.Decimals = decimals
 	//最低手续费
 	c.MinFees = decimal.Zero
+	//挖矿收入成熟所需确认数
+	c.CoinbaseMaturity = 100
+	//交易单输入输出默认排序策略
+	c.TxOrderPolicy = TxOrderBIP69
+	//交易单锁定utxo的有效时间
+	c.UTXOReserveTTL = 10 * time.Minute
+	//广播前检查的费率上限，默认每KB 0.1
+	c.MaxFeeRate = decimal.New(1, -1)
+	//替换交易单最少需要增加的费率
+	c.IncrementalRelayFee = decimal.NewFromFloat(0.00001)
+	//粉尘限额
+	c.DustLimit = decimal.New(546, -8)
+	//已广播交易单的跟踪间隔时间
+	c.TxTrackCycle = time.Minute
+	//已广播交易单的跟踪期限
+	c.TxTrackExpire = 72 * time.Hour
+	//交易单重新广播的最小间隔
+	c.TxRebroadcastInterval = 10 * time.Minute
+	//未确认入账的跟踪期限
+	c.PendingDepositExpire = 72 * time.Hour
+	//已广播交易单达到的确认数视为已确认
+	c.TxTrackConfirms = 1
+	//签名策略，默认不限制
+	c.SignPolicy = NewSignPolicy()
+	//签名器类型
+	c.SignerType = SignerTypeHD
+	//远程签名服务请求超时时间
+	c.RemoteSignerTimeout = 30 * time.Second
+	//使用核心钱包密钥签名的资产账户
+	c.CoreWalletAccounts = make([]string, 0)
+	//核心钱包签名时的解锁时长
+	c.WalletUnlockTime = 10 * time.Second
 	c.MainNetAddressPrefix = MainNetAddressPrefix
 	c.TestNetAddressPrefix = TestNetAddressPrefix
 
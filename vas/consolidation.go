@@ -0,0 +1,318 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/blocktree/openwallet/timer"
+	"github.com/shopspring/decimal"
+)
+
+//ConsolidationSignFunc 汇总交易单签名回调，应用完成签名和广播，广播后应填充rawTx.TxID
+type ConsolidationSignFunc func(account *openwallet.AssetsAccount, rawTxs []*openwallet.RawTransaction) error
+
+//ConsolidationRecord 汇总记录
+type ConsolidationRecord struct {
+	ID         string `storm:"id"`
+	AccountID  string `storm:"index"`
+	SumAddress string
+	FeeRate    string
+	Balance    string
+	UTXOCount  int
+	Amount     string
+	Fees       string
+	TxIDs      []string
+	RawHex     []string
+	Error      string
+	CreateTime int64 `storm:"index"`
+}
+
+//ConsolidationScheduler 定时汇总调度器，按CycleSeconds周期检查账户余额和utxo数量，超过阀值时创建汇总交易
+type ConsolidationScheduler struct {
+	wm       *WalletManager
+	wrapper  openwallet.WalletDAI
+	signFunc ConsolidationSignFunc
+	task     *timer.TaskTimer
+	mu       sync.Mutex
+	running  bool
+}
+
+//NewConsolidationScheduler 创建定时汇总调度器
+func NewConsolidationScheduler(wm *WalletManager, wrapper openwallet.WalletDAI, signFunc ConsolidationSignFunc) *ConsolidationScheduler {
+	cs := ConsolidationScheduler{
+		wm:       wm,
+		wrapper:  wrapper,
+		signFunc: signFunc,
+	}
+	return &cs
+}
+
+//Start 启动定时汇总
+func (cs *ConsolidationScheduler) Start() error {
+
+	if len(cs.wm.Config.SumAddress) == 0 {
+		return fmt.Errorf("summary address is not setup")
+	}
+
+	if cs.signFunc == nil {
+		return fmt.Errorf("consolidation sign func is not setup")
+	}
+
+	if cs.task == nil {
+		cs.task = timer.NewTask(cs.wm.Config.CycleSeconds, cs.RunOnce)
+	}
+	cs.task.Start()
+
+	cs.wm.Log.Infof("consolidation scheduler start, cycle: %v", cs.wm.Config.CycleSeconds)
+	return nil
+}
+
+//Stop 停止定时汇总
+func (cs *ConsolidationScheduler) Stop() {
+	if cs.task != nil {
+		cs.task.Stop()
+	}
+}
+
+//RunOnce 执行一次汇总检查，上一次未完成时跳过
+func (cs *ConsolidationScheduler) RunOnce() {
+
+	cs.mu.Lock()
+	if cs.running {
+		cs.mu.Unlock()
+		return
+	}
+	cs.running = true
+	cs.mu.Unlock()
+
+	defer func() {
+		cs.mu.Lock()
+		cs.running = false
+		cs.mu.Unlock()
+	}()
+
+	feesRate, err := cs.wm.EstimateFeeRate()
+	if err != nil {
+		cs.wm.Log.Errorf("consolidation estimate fee rate failed, err: %v", err)
+		return
+	}
+
+	//费率高于上限时推迟汇总
+	if cs.wm.Config.MaxSumFeeRate.GreaterThan(decimal.Zero) && feesRate.GreaterThan(cs.wm.Config.MaxSumFeeRate) {
+		cs.wm.Log.Infof("consolidation skipped, fee rate: %s over ceiling: %s", feesRate.String(), cs.wm.Config.MaxSumFeeRate.String())
+		return
+	}
+
+	accounts, err := cs.wrapper.GetAssetsAccountList(0, -1)
+	if err != nil {
+		cs.wm.Log.Errorf("consolidation get assets account list failed, err: %v", err)
+		return
+	}
+
+	for _, account := range accounts {
+		if account.Symbol != cs.wm.Symbol() {
+			continue
+		}
+		err = cs.consolidateAccount(account, feesRate)
+		if err != nil {
+			cs.wm.Log.Errorf("account[%s] consolidation failed, err: %v", account.AccountID, err)
+		}
+	}
+}
+
+//consolidateAccount 检查账户是否超过汇总阀值，超过则创建汇总交易并交由回调签名
+func (cs *ConsolidationScheduler) consolidateAccount(account *openwallet.AssetsAccount, feesRate decimal.Decimal) error {
+
+	address, err := cs.wrapper.GetAddressList(0, -1, "AccountID", account.AccountID)
+	if err != nil {
+		return err
+	}
+
+	if len(address) == 0 {
+		return nil
+	}
+
+	searchAddrs := make([]string, 0)
+	for _, address := range address {
+		if address.Address == cs.wm.Config.SumAddress {
+			continue
+		}
+		searchAddrs = append(searchAddrs, address.Address)
+	}
+
+	if len(searchAddrs) == 0 {
+		return nil
+	}
+
+	unspents, err := cs.wm.ListUnspent(cs.wm.Config.SumConfirms, searchAddrs...)
+	if err != nil {
+		return err
+	}
+	unspents = filterSpendableUnspents(unspents)
+	unspents = cs.wm.filterReservedUnspents(unspents)
+	balance := sumUnspents(unspents)
+
+	if !cs.isOverThreshold(balance, len(unspents)) {
+		return nil
+	}
+
+	cs.wm.Log.Infof("account[%s] balance: %s, utxo count: %d, over consolidation threshold", account.AccountID, balance.String(), len(unspents))
+
+	sumRawTx := &openwallet.SummaryRawTransaction{
+		Coin:           openwallet.Coin{Symbol: cs.wm.Symbol()},
+		FeeRate:        feesRate.StringFixed(cs.wm.Decimal()),
+		SummaryAddress: cs.wm.Config.SumAddress,
+		MinTransfer:    "0",
+		Account:        account,
+		AddressLimit:   -1,
+		Confirms:       cs.wm.Config.SumConfirms,
+	}
+
+	record := &ConsolidationRecord{
+		ID:         fmt.Sprintf("%s_%d", account.AccountID, time.Now().UnixNano()),
+		AccountID:  account.AccountID,
+		SumAddress: cs.wm.Config.SumAddress,
+		FeeRate:    sumRawTx.FeeRate,
+		Balance:    balance.StringFixed(cs.wm.Decimal()),
+		UTXOCount:  len(unspents),
+		CreateTime: time.Now().Unix(),
+	}
+
+	rawTxs, err := cs.createSummaryRawTransactions(sumRawTx)
+	if err == nil && len(rawTxs) > 0 {
+		err = cs.signFunc(account, rawTxs)
+	}
+
+	if err != nil {
+		record.Error = err.Error()
+		cs.cancelUnsentRawTransactions(rawTxs)
+	}
+
+	totalAmount := decimal.Zero
+	totalFees := decimal.Zero
+	for _, rawTx := range rawTxs {
+		for _, amount := range rawTx.To {
+			a, _ := decimal.NewFromString(amount)
+			totalAmount = totalAmount.Add(a)
+		}
+		fees, _ := decimal.NewFromString(rawTx.Fees)
+		totalFees = totalFees.Add(fees)
+		record.TxIDs = append(record.TxIDs, rawTx.TxID)
+		record.RawHex = append(record.RawHex, rawTx.RawHex)
+	}
+	record.Amount = totalAmount.StringFixed(cs.wm.Decimal())
+	record.Fees = totalFees.StringFixed(cs.wm.Decimal())
+
+	if len(rawTxs) > 0 || err != nil {
+		if saveErr := cs.wm.SaveConsolidationRecord(record); saveErr != nil {
+			cs.wm.Log.Errorf("save consolidation record failed, err: %v", saveErr)
+		}
+	}
+
+	return err
+}
+
+//createSummaryRawTransactions 创建汇总交易单，忽略构建失败的交易单
+func (cs *ConsolidationScheduler) createSummaryRawTransactions(sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransaction, error) {
+
+	decoder, ok := cs.wm.TxDecoder.(*TransactionDecoder)
+	if !ok {
+		return nil, fmt.Errorf("transaction decoder is not supported")
+	}
+
+	rawTxWithErrArray, err := decoder.CreateVASSummaryRawTransaction(cs.wrapper, sumRawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	rawTxs := make([]*openwallet.RawTransaction, 0)
+	for _, rawTxWithErr := range rawTxWithErrArray {
+		if rawTxWithErr.Error != nil {
+			cs.wm.Log.Warningf("create summary raw transaction failed, err: %v", rawTxWithErr.Error)
+			continue
+		}
+		rawTxs = append(rawTxs, rawTxWithErr.RawTx)
+	}
+	return rawTxs, nil
+}
+
+//cancelUnsentRawTransactions 签名或广播失败时，释放未广播的汇总交易单锁定的utxo
+func (cs *ConsolidationScheduler) cancelUnsentRawTransactions(rawTxs []*openwallet.RawTransaction) {
+
+	decoder, ok := cs.wm.TxDecoder.(*TransactionDecoder)
+	if !ok {
+		return
+	}
+
+	unsent := make([]*openwallet.RawTransaction, 0)
+	for _, rawTx := range rawTxs {
+		if !rawTx.IsSubmit {
+			unsent = append(unsent, rawTx)
+		}
+	}
+	decoder.cancelRawTransactions(unsent)
+}
+
+//isOverThreshold 余额超过汇总阀值或utxo数量超过汇总数量阀值
+func (cs *ConsolidationScheduler) isOverThreshold(balance decimal.Decimal, utxoCount int) bool {
+	if utxoCount == 0 {
+		return false
+	}
+	if cs.wm.Config.Threshold.GreaterThan(decimal.Zero) && balance.GreaterThanOrEqual(cs.wm.Config.Threshold) {
+		return true
+	}
+	if cs.wm.Config.SumUTXOThreshold > 0 && utxoCount >= cs.wm.Config.SumUTXOThreshold {
+		return true
+	}
+	return false
+}
+
+//SaveConsolidationRecord 保存汇总记录
+func (wm *WalletManager) SaveConsolidationRecord(record *ConsolidationRecord) error {
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(record)
+}
+
+//GetConsolidationRecords 获取账户的汇总记录
+func (wm *WalletManager) GetConsolidationRecords(accountID string) ([]*ConsolidationRecord, error) {
+
+	var records []*ConsolidationRecord
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.Find("AccountID", accountID, &records)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	return records, nil
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+func TestConsolidationIsOverThreshold(t *testing.T) {
+
+	wm := NewWalletManager()
+	wm.Config.Threshold = decimal.New(5, 0)
+	wm.Config.SumUTXOThreshold = 3
+	cs := NewConsolidationScheduler(wm, nil, nil)
+
+	if cs.isOverThreshold(decimal.New(1, 0), 1) {
+		t.Errorf("balance and utxo count under threshold")
+		return
+	}
+
+	if !cs.isOverThreshold(decimal.New(5, 0), 1) {
+		t.Errorf("balance over threshold")
+		return
+	}
+
+	if !cs.isOverThreshold(decimal.New(1, 0), 3) {
+		t.Errorf("utxo count over threshold")
+		return
+	}
+
+	if cs.isOverThreshold(decimal.New(10, 0), 0) {
+		t.Errorf("no utxo to consolidate")
+		return
+	}
+}
+
+//consolidationWalletDAI 实现账户和地址查询的钱包数据接口
+type consolidationWalletDAI struct {
+	*policyWalletDAI
+	accounts []*openwallet.AssetsAccount
+}
+
+func (w *consolidationWalletDAI) GetAssetsAccountList(offset, limit int, cols ...interface{}) ([]*openwallet.AssetsAccount, error) {
+	return w.accounts, nil
+}
+
+func TestConsolidationRunOnce(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "consolidation")
+	if err != nil {
+		t.Errorf("create temp dir failed, err: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	utxo := `{"txid":"d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401","vout":%d,"address":"VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf","scriptPubKey":"76a914d46043209073ad39879356295562d952cd9dae3a88ac","amount":1,"confirmations":200}`
+	node := newMockNode(map[string]string{
+		"listunspent": "[" + fmt.Sprintf(utxo, 0) + "," + fmt.Sprintf(utxo, 1) + "," + fmt.Sprintf(utxo, 2) + "]",
+	})
+	defer node.Close()
+
+	wm := NewWalletManager()
+	wm.Config.DBPath = dir
+	wm.Config.SumAddress = "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV"
+	wm.Config.SumUTXOThreshold = 2
+	wm.Config.MinFees = decimal.RequireFromString("0.001")
+	wm.WalletClient = NewClient(node.URL, "", false)
+
+	wrapper := &consolidationWalletDAI{
+		policyWalletDAI: &policyWalletDAI{addresses: map[string]bool{"VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf": true}},
+		accounts:        []*openwallet.AssetsAccount{{AccountID: "acc", Symbol: wm.Symbol()}},
+	}
+
+	signed := make([]*openwallet.RawTransaction, 0)
+	cs := NewConsolidationScheduler(wm, wrapper, func(account *openwallet.AssetsAccount, rawTxs []*openwallet.RawTransaction) error {
+		for _, rawTx := range rawTxs {
+			rawTx.TxID = "sum"
+		}
+		signed = append(signed, rawTxs...)
+		return nil
+	})
+
+	//费率高于上限，推迟汇总
+	wm.Config.MaxSumFeeRate = decimal.RequireFromString("0.0005")
+	cs.RunOnce()
+	if len(signed) != 0 {
+		t.Errorf("consolidation should be skipped when fee rate over ceiling")
+		return
+	}
+
+	//3个utxo超过数量阀值，汇总到汇总地址
+	wm.Config.MaxSumFeeRate = decimal.RequireFromString("0.01")
+	cs.RunOnce()
+	if len(signed) != 1 || len(signed[0].To) != 1 || len(signed[0].To[wm.Config.SumAddress]) == 0 {
+		t.Errorf("unexpected consolidation raw transactions: %d", len(signed))
+		return
+	}
+
+	records, err := wm.GetConsolidationRecords("acc")
+	if err != nil || len(records) != 1 {
+		t.Errorf("consolidation record should be saved, err: %v", err)
+		return
+	}
+	if records[0].UTXOCount != 3 || records[0].Balance != "3.00000000" || records[0].TxIDs[0] != "sum" || len(records[0].Error) > 0 {
+		t.Errorf("unexpected consolidation record: %+v", records[0])
+		return
+	}
+
+	//签名失败，释放未广播的汇总交易单锁定的utxo
+	wm.Config.DBPath = filepath.Join(dir, "failed")
+	os.MkdirAll(wm.Config.DBPath, 0755)
+	failed := NewConsolidationScheduler(wm, wrapper, func(account *openwallet.AssetsAccount, rawTxs []*openwallet.RawTransaction) error {
+		return fmt.Errorf("sign failed")
+	})
+	failed.RunOnce()
+	records, _ = wm.GetConsolidationRecords("acc")
+	if len(records) != 1 || records[0].Error != "sign failed" {
+		t.Errorf("failed consolidation should be recorded")
+		return
+	}
+	unspents, _ := wm.ListUnspent(0, "VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf")
+	if len(wm.filterReservedUnspents(unspents)) != 3 {
+		t.Errorf("unspents of unsent summary transactions should be released")
+	}
+}
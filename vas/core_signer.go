@@ -0,0 +1,227 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/assetsadapterstore/vas-adapter/vasTransaction"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//核心钱包的解锁和加锁是全局状态，同一时间只允许一个签名过程
+var coreWalletLock sync.Mutex
+
+//CoreWalletSigner 使用核心钱包(vasd)内的私钥签名，适用于私钥保存在节点中的旧热钱包
+type CoreWalletSigner struct {
+	wm *WalletManager
+}
+
+//NewCoreWalletSigner 创建核心钱包签名器
+func NewCoreWalletSigner(wm *WalletManager) *CoreWalletSigner {
+	return &CoreWalletSigner{wm: wm}
+}
+
+//Sign 解锁核心钱包签名交易单，完成后重新加锁，从节点返回的交易单中解析每个请求的签名
+func (signer *CoreWalletSigner) Sign(wrapper openwallet.WalletDAI, context *SignContext, requests []*SignRequest) ([][]byte, error) {
+
+	if signer.wm.Config.CoreWalletWatchOnly {
+		return nil, fmt.Errorf("core wallet is watch only, can not sign transaction")
+	}
+
+	coreWalletLock.Lock()
+	defer coreWalletLock.Unlock()
+
+	if len(signer.wm.Config.WalletPassword) > 0 {
+		err := signer.wm.UnlockWallet(signer.wm.Config.WalletPassword, int64(signer.wm.Config.WalletUnlockTime.Seconds()))
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err := signer.wm.LockWallet(); err != nil {
+				signer.wm.Log.Errorf("lock core wallet failed, err: %v", err)
+			}
+		}()
+	}
+
+	signedHex, err := signer.wm.SignRawTransactionWithWallet(context.RawHex)
+	if err != nil {
+		return nil, err
+	}
+
+	sigPubs, err := vasTransaction.ExtractSignatures(signedHex, signer.wm.Config.SupportSegWit)
+	if err != nil {
+		return nil, fmt.Errorf("decode core wallet signed transaction failed, err: %v", err)
+	}
+
+	//按公钥和签名哈希匹配输入，签名须能被请求的公钥验证
+	used := make(map[int]bool)
+	signatures := make([][]byte, 0, len(requests))
+	for _, r := range requests {
+		pubkey, _ := hex.DecodeString(r.PublicKey)
+		found := -1
+		for i, sigPub := range sigPubs {
+			if sigPub == nil || used[i] || !bytes.Equal(sigPub.Pubkey, pubkey) {
+				continue
+			}
+			if !vasTransaction.IsLowS(sigPub.Signature) || verifySignHash(r, sigPub.Signature) != nil {
+				continue
+			}
+			found = i
+			break
+		}
+		if found < 0 {
+			return nil, fmt.Errorf("core wallet returns no valid signature of input %d", r.InputIndex)
+		}
+		used[found] = true
+		signatures = append(signatures, sigPubs[found].Signature)
+	}
+
+	return signatures, nil
+}
+
+//UnlockWallet 解锁核心钱包，超过timeout秒后节点自动加锁，钱包未加密时忽略
+func (wm *WalletManager) UnlockWallet(password string, timeout int64) error {
+
+	request := []interface{}{
+		password,
+		timeout,
+	}
+
+	_, err := wm.WalletClient.Call("walletpassphrase", request)
+	if err != nil {
+		//-15: running with an unencrypted wallet
+		if strings.HasPrefix(err.Error(), "[-15]") {
+			return nil
+		}
+		return fmt.Errorf("unlock core wallet failed, err: %v", err)
+	}
+
+	return nil
+}
+
+//LockWallet 加锁核心钱包，钱包未加密时忽略
+func (wm *WalletManager) LockWallet() error {
+
+	_, err := wm.WalletClient.Call("walletlock", nil)
+	if err != nil && !strings.HasPrefix(err.Error(), "[-15]") {
+		return err
+	}
+
+	return nil
+}
+
+//SignRawTransactionWithWallet 使用核心钱包私钥签名交易单，节点不支持signrawtransactionwithwallet时使用signrawtransaction
+//交易单混合了其他账户的输入时节点只能部分签名，返回部分签名的交易单，由调用方检查请求的输入是否都已签名
+func (wm *WalletManager) SignRawTransactionWithWallet(txHex string) (string, error) {
+
+	request := []interface{}{
+		txHex,
+	}
+
+	result, err := wm.WalletClient.Call("signrawtransactionwithwallet", request)
+	if err != nil {
+		//-32601: Method not found
+		if !strings.HasPrefix(err.Error(), "[-32601]") {
+			return "", err
+		}
+		result, err = wm.WalletClient.Call("signrawtransaction", request)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	/*
+		{
+			"hex": "...",
+			"complete": false,
+			"errors": [
+				{
+					"txid": "...",
+					"vout": 0,
+					"error": "Unable to sign input, invalid stack size (possibly missing key)"
+				}
+			]
+		}
+	*/
+	if !result.Get("complete").Bool() {
+		reasons := make([]string, 0)
+		for _, e := range result.Get("errors").Array() {
+			reasons = append(reasons, fmt.Sprintf("%s:%d %s", e.Get("txid").String(), e.Get("vout").Uint(), e.Get("error").String()))
+		}
+		wm.Log.Debugf("core wallet sign transaction incomplete: %s", strings.Join(reasons, "; "))
+	}
+
+	signedHex := result.Get("hex").String()
+	if len(signedHex) == 0 {
+		return "", fmt.Errorf("core wallet returns empty transaction")
+	}
+
+	return signedHex, nil
+}
+
+//isCoreWalletAccount 资产账户是否配置为使用核心钱包签名
+func (wm *WalletManager) isCoreWalletAccount(accountID string) bool {
+	for _, a := range wm.Config.CoreWalletAccounts {
+		if a == accountID {
+			return true
+		}
+	}
+	return false
+}
+
+//signRequests 按账户选择签名器签名，配置在CoreWalletAccounts中的账户使用核心钱包，其余使用默认签名器
+//返回与请求顺序一致的签名
+func (wm *WalletManager) signRequests(wrapper openwallet.WalletDAI, context *SignContext, requests []*SignRequest) ([][]byte, error) {
+
+	var (
+		signers   = []Signer{wm.Signer, NewCoreWalletSigner(wm)}
+		groups    = make([][]*SignRequest, len(signers))
+		positions = make([][]int, len(signers))
+	)
+
+	for i, r := range requests {
+		g := 0
+		if wm.isCoreWalletAccount(r.AccountID) {
+			g = 1
+		}
+		groups[g] = append(groups[g], r)
+		positions[g] = append(positions[g], i)
+	}
+
+	signatures := make([][]byte, len(requests))
+	for g, signer := range signers {
+		if len(groups[g]) == 0 {
+			continue
+		}
+		results, err := signer.Sign(wrapper, context, groups[g])
+		if err != nil {
+			return nil, err
+		}
+		if len(results) != len(groups[g]) {
+			return nil, fmt.Errorf("signer returns %d signatures, expected %d", len(results), len(groups[g]))
+		}
+		for i, sig := range results {
+			signatures[positions[g][i]] = sig
+		}
+	}
+
+	return signatures, nil
+}
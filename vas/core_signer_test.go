@@ -0,0 +1,142 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/assetsadapterstore/vas-adapter/vasTransaction"
+	"github.com/blocktree/go-owcrypt"
+)
+
+//newCoreSignedTransaction 用固定私钥创建单输入的已签名交易单，模拟核心钱包签名结果
+func newCoreSignedTransaction(t *testing.T, prikey, pubkey []byte) (string, string, string) {
+
+	lockScript := "76a914" + hex.EncodeToString(owcrypt.Hash(pubkey, 0, owcrypt.HASH_ALG_HASH160)) + "88ac"
+	in := vasTransaction.Vin{TxID: "d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", Vout: 0}
+	out := vasTransaction.Vout{Address: "VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7", Amount: 900000}
+	unlock := []vasTransaction.TxUnlock{{LockScript: lockScript, Amount: 1000000, SigType: vasTransaction.SigHashAll}}
+
+	emptyTrans, err := vasTransaction.CreateEmptyRawTransaction([]vasTransaction.Vin{in}, []vasTransaction.Vout{out}, 0, false, MainNetAddressPrefix)
+	if err != nil {
+		t.Fatalf("create empty transaction failed, err: %v", err)
+	}
+
+	transHash, err := vasTransaction.CreateRawTransactionHashForSig(emptyTrans, unlock, false, MainNetAddressPrefix)
+	if err != nil {
+		t.Fatalf("create transaction hash failed, err: %v", err)
+	}
+
+	sigPub, err := vasTransaction.SignRawTransactionHash(transHash[0].GetTxHashHex(), prikey)
+	if err != nil {
+		t.Fatalf("sign transaction hash failed, err: %v", err)
+	}
+	transHash[0].Normal.SigPub = *sigPub
+
+	signedTrans, err := vasTransaction.InsertSignatureIntoEmptyTransaction(emptyTrans, transHash, unlock, false)
+	if err != nil {
+		t.Fatalf("insert signature failed, err: %v", err)
+	}
+
+	return emptyTrans, signedTrans, transHash[0].GetTxHashHex()
+}
+
+func TestCoreWalletSigner(t *testing.T) {
+
+	prikey, _ := hex.DecodeString("80bc398d7c4a674daa977566c2e6cd5040520027e57fe806dfaa868df4cc43ab")
+	pubkey, _ := owcrypt.GenPubkey(prikey, owcrypt.ECC_CURVE_SECP256K1)
+	pubkey = owcrypt.PointCompress(pubkey, owcrypt.ECC_CURVE_SECP256K1)
+
+	emptyTrans, signedTrans, sigHash := newCoreSignedTransaction(t, prikey, pubkey)
+
+	calls := make([]string, 0)
+	node := newRecordingMockNode(map[string]string{
+		"walletpassphrase":             `null`,
+		"walletlock":                   `null`,
+		"signrawtransactionwithwallet": `{"hex":"` + signedTrans + `","complete":true}`,
+	}, &calls)
+	defer node.Close()
+
+	wm := NewWalletManager()
+	wm.WalletClient = NewClient(node.URL, "", false)
+	wm.Config.WalletPassword = "1234"
+	wm.Config.CoreWalletAccounts = []string{"A"}
+
+	requests := []*SignRequest{
+		{AccountID: "A", InputIndex: 0, PublicKey: hex.EncodeToString(pubkey), SigHash: sigHash},
+	}
+	context := &SignContext{Sid: "order1", RawHex: emptyTrans}
+
+	//默认只做监听，不允许核心钱包签名
+	if _, err := wm.signRequests(nil, context, requests); err == nil {
+		t.Errorf("watch only core wallet should not sign")
+		return
+	}
+
+	wm.Config.CoreWalletWatchOnly = false
+	signatures, err := wm.signRequests(nil, context, requests)
+	if err != nil {
+		t.Errorf("core wallet sign failed, err: %v", err)
+		return
+	}
+	if len(signatures) != 1 || verifySignHash(requests[0], signatures[0]) != nil {
+		t.Errorf("unexpected core wallet signatures: %d", len(signatures))
+		return
+	}
+
+	//签名完成后重新加锁
+	if strings.Join(calls, ",") != "walletpassphrase,signrawtransactionwithwallet,walletlock" {
+		t.Errorf("core wallet should be locked after signing, calls: %v", calls)
+		return
+	}
+
+	//核心钱包的签名与待签名消息不一致
+	requests[0].SigHash = "00" + sigHash[2:]
+	if _, err = wm.signRequests(nil, context, requests); err == nil {
+		t.Errorf("signature of other sighash should be rejected")
+	}
+	requests[0].SigHash = sigHash
+
+	//混合其他账户输入的交易单，节点只能部分签名，请求的输入已签名即可
+	partialNode := newMockNode(map[string]string{
+		"walletpassphrase":             `null`,
+		"walletlock":                   `null`,
+		"signrawtransactionwithwallet": `{"hex":"` + signedTrans + `","complete":false,"errors":[{"txid":"d2","vout":1,"error":"missing key"}]}`,
+	})
+	defer partialNode.Close()
+	wm.WalletClient = NewClient(partialNode.URL, "", false)
+	if _, err = wm.signRequests(nil, context, requests); err != nil {
+		t.Errorf("partial signing should be accepted, err: %v", err)
+	}
+
+	//旧版本节点，请求的输入未签名
+	calls = calls[:0]
+	oldNode := newRecordingMockNode(map[string]string{
+		"walletpassphrase":   `null`,
+		"walletlock":         `null`,
+		"signrawtransaction": `{"hex":"` + emptyTrans + `","complete":false,"errors":[{"txid":"d1","vout":0,"error":"missing key"}]}`,
+	}, &calls)
+	defer oldNode.Close()
+	wm.WalletClient = NewClient(oldNode.URL, "", false)
+	if _, err = wm.signRequests(nil, context, requests); err == nil {
+		t.Errorf("unsigned requested input should return error")
+	}
+	if len(calls) == 0 || calls[len(calls)-1] != "walletlock" {
+		t.Errorf("core wallet should be locked after failed signing, calls: %v", calls)
+	}
+}
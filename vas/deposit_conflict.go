@@ -0,0 +1,362 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//DepositConflictObserver 入账冲突观测者，扫描器的观测者实现该接口时接收未确认入账被双花或替换的通知
+type DepositConflictObserver interface {
+	DepositConflictNotify(conflict *DepositConflict) error
+}
+
+//PendingDeposit 未确认入账的跟踪记录
+type PendingDeposit struct {
+	TxID                 string                             `storm:"id"`
+	Outpoints            []string                           //入账交易花费的输出
+	Transactions         map[string]*openwallet.Transaction //sourceKey对应的交易记录，冲突确认后以失败状态重新通知
+	ContractTransactions map[string]*openwallet.Transaction //sourceKey对应的omni代币交易记录
+	CreateTime           int64
+}
+
+//IsExpired 超过跟踪期限仍未确认的入账，例如已被交易池清除
+func (deposit *PendingDeposit) IsExpired(now int64, expire time.Duration) bool {
+	return now-deposit.CreateTime > int64(expire.Seconds())
+}
+
+//DepositConflict 未确认入账的冲突记录
+type DepositConflict struct {
+	ID           string `storm:"id"` //depositTxID_conflictTxID
+	DepositTxID  string `storm:"index"`
+	ConflictTxID string
+	Outpoint     string //被冲突交易花费的输出
+	SourceKeys   []string
+	BlockHash    string
+	BlockHeight  uint64 //冲突交易所在区块高度，0为交易池中
+	Confirmed    bool   //冲突交易已确认，原入账已标记为失败
+	CreateTime   int64
+}
+
+//depositIndex 未确认入账花费的输出索引，首次使用时从数据库加载
+type depositIndex struct {
+	mu         sync.Mutex
+	loaded     bool
+	expireTime int64             //上次清理过期入账的时间
+	outpoints  map[string]string //outpoint -> 入账txid
+	deposits   map[string]*PendingDeposit
+}
+
+func newDepositIndex() *depositIndex {
+	return &depositIndex{
+		outpoints: make(map[string]string),
+		deposits:  make(map[string]*PendingDeposit),
+	}
+}
+
+func outpointKey(txid string, vout uint64) string {
+	return fmt.Sprintf("%s:%d", txid, vout)
+}
+
+func (index *depositIndex) add(deposit *PendingDeposit) {
+	index.deposits[deposit.TxID] = deposit
+	for _, outpoint := range deposit.Outpoints {
+		index.outpoints[outpoint] = deposit.TxID
+	}
+}
+
+func (index *depositIndex) remove(txid string) {
+	deposit := index.deposits[txid]
+	if deposit == nil {
+		return
+	}
+	for _, outpoint := range deposit.Outpoints {
+		if index.outpoints[outpoint] == txid {
+			delete(index.outpoints, outpoint)
+		}
+	}
+	delete(index.deposits, txid)
+}
+
+//checkDepositConflicts 检查扫描到的交易单：
+//已确认的入账结束跟踪；花费了未确认入账相同输出的其他交易单通知冲突，冲突交易确认后原入账以失败状态重新通知；
+//交易池中的入账记录其花费的输出
+func (bs *VASBlockScanner) checkDepositConflicts(result *ExtractResult) {
+
+	index := bs.depositIndex
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if !index.loaded {
+		deposits, err := bs.wm.GetPendingDeposits()
+		if err != nil {
+			bs.wm.Log.Errorf("load pending deposits failed, err: %v", err)
+			return
+		}
+		for _, deposit := range deposits {
+			index.add(deposit)
+		}
+		index.loaded = true
+	}
+
+	bs.expirePendingDeposits()
+
+	confirmed := result.BlockHeight > 0
+
+	if confirmed && index.deposits[result.TxID] != nil {
+		index.remove(result.TxID)
+		if err := bs.wm.DeletePendingDeposit(result.TxID); err != nil {
+			bs.wm.Log.Errorf("delete pending deposit: %s failed, err: %v", result.TxID, err)
+		}
+	}
+
+	for _, outpoint := range result.spentOutpoints {
+		depositTxID, ok := index.outpoints[outpoint]
+		if !ok || depositTxID == result.TxID {
+			continue
+		}
+		deposit := index.deposits[depositTxID]
+		if bs.notifyDepositConflict(deposit, result, outpoint) {
+			index.remove(depositTxID)
+		}
+	}
+
+	if !confirmed && index.deposits[result.TxID] == nil && len(result.spentOutpoints) > 0 {
+		deposit := &PendingDeposit{
+			TxID:                 result.TxID,
+			Outpoints:            result.spentOutpoints,
+			Transactions:         make(map[string]*openwallet.Transaction),
+			ContractTransactions: make(map[string]*openwallet.Transaction),
+			CreateTime:           time.Now().Unix(),
+		}
+		for sourceKey, data := range result.extractData {
+			if len(data.TxOutputs) > 0 && data.Transaction != nil {
+				deposit.Transactions[sourceKey] = data.Transaction
+			}
+		}
+		for sourceKey, data := range result.extractContractData {
+			if len(data.TxOutputs) > 0 && data.Transaction != nil {
+				deposit.ContractTransactions[sourceKey] = data.Transaction
+			}
+		}
+		if len(deposit.Transactions) == 0 && len(deposit.ContractTransactions) == 0 {
+			return
+		}
+		if err := bs.wm.SavePendingDeposit(deposit); err != nil {
+			bs.wm.Log.Errorf("save pending deposit: %s failed, err: %v", deposit.TxID, err)
+			return
+		}
+		index.add(deposit)
+	}
+}
+
+//notifyDepositConflict 通知入账冲突，同一冲突只通知一次，冲突交易确认时再通知一次并将原入账标记为失败
+//返回原入账是否已结束跟踪
+func (bs *VASBlockScanner) notifyDepositConflict(deposit *PendingDeposit, result *ExtractResult, outpoint string) bool {
+
+	confirmed := result.BlockHeight > 0
+	conflict := &DepositConflict{
+		ID:           deposit.TxID + "_" + result.TxID,
+		DepositTxID:  deposit.TxID,
+		ConflictTxID: result.TxID,
+		Outpoint:     outpoint,
+		BlockHash:    result.BlockHash,
+		BlockHeight:  result.BlockHeight,
+		Confirmed:    confirmed,
+		CreateTime:   time.Now().Unix(),
+	}
+	for sourceKey := range deposit.Transactions {
+		conflict.SourceKeys = append(conflict.SourceKeys, sourceKey)
+	}
+	for sourceKey := range deposit.ContractTransactions {
+		if _, exist := deposit.Transactions[sourceKey]; !exist {
+			conflict.SourceKeys = append(conflict.SourceKeys, sourceKey)
+		}
+	}
+
+	exist, err := bs.wm.GetDepositConflict(conflict.ID)
+	if err == nil && (exist.Confirmed || !confirmed) {
+		return false
+	}
+
+	bs.wm.Log.Warningf("deposit: %s conflicts with tx: %s on outpoint: %s, confirmed: %v", deposit.TxID, result.TxID, outpoint, confirmed)
+
+	for o := range bs.Observers {
+		if observer, ok := o.(DepositConflictObserver); ok {
+			if err := observer.DepositConflictNotify(conflict); err != nil {
+				bs.wm.Log.Errorf("DepositConflictNotify unexpected error: %v", err)
+			}
+		}
+	}
+
+	if err := bs.wm.SaveDepositConflict(conflict); err != nil {
+		bs.wm.Log.Errorf("save deposit conflict: %s failed, err: %v", conflict.ID, err)
+	}
+
+	if !confirmed {
+		return false
+	}
+
+	//冲突交易已确认，原入账标记为失败，代币入账与主链币入账分开通知
+	newFailedData := func(transactions map[string]*openwallet.Transaction) map[string]*openwallet.TxExtractData {
+		failed := make(map[string]*openwallet.TxExtractData)
+		for sourceKey, tx := range transactions {
+			tx.Status = openwallet.TxStatusFail
+			tx.Reason = fmt.Sprintf("double spent by transaction: %s", result.TxID)
+			data := openwallet.NewBlockExtractData()
+			data.Transaction = tx
+			failed[sourceKey] = data
+		}
+		return failed
+	}
+	bs.newExtractDataNotify(result.BlockHeight, newFailedData(deposit.Transactions))
+	if len(deposit.ContractTransactions) > 0 {
+		bs.newExtractDataNotify(result.BlockHeight, newFailedData(deposit.ContractTransactions))
+	}
+
+	if err := bs.wm.DeletePendingDeposit(deposit.TxID); err != nil {
+		bs.wm.Log.Errorf("delete pending deposit: %s failed, err: %v", deposit.TxID, err)
+	}
+
+	return true
+}
+
+//expirePendingDeposits 清理超过跟踪期限的未确认入账，每分钟最多执行一次，调用方需持有索引锁
+func (bs *VASBlockScanner) expirePendingDeposits() {
+
+	index := bs.depositIndex
+	now := time.Now().Unix()
+	if now-index.expireTime < 60 {
+		return
+	}
+	index.expireTime = now
+
+	for txid, deposit := range index.deposits {
+		if !deposit.IsExpired(now, bs.wm.Config.PendingDepositExpire) {
+			continue
+		}
+		bs.wm.Log.Infof("pending deposit: %s expired, stop tracking conflicts", txid)
+		if err := bs.wm.DeletePendingDeposit(txid); err != nil {
+			bs.wm.Log.Errorf("delete pending deposit: %s failed, err: %v", txid, err)
+			continue
+		}
+		index.remove(txid)
+	}
+}
+
+//SavePendingDeposit 保存未确认入账的跟踪记录
+func (wm *WalletManager) SavePendingDeposit(deposit *PendingDeposit) error {
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(deposit)
+}
+
+//DeletePendingDeposit 删除未确认入账的跟踪记录
+func (wm *WalletManager) DeletePendingDeposit(txid string) error {
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.DeleteStruct(&PendingDeposit{TxID: txid})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	return nil
+}
+
+//GetPendingDeposits 获取所有未确认入账的跟踪记录
+func (wm *WalletManager) GetPendingDeposits() ([]*PendingDeposit, error) {
+
+	var deposits []*PendingDeposit
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&deposits)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	return deposits, nil
+}
+
+//SaveDepositConflict 保存入账冲突记录
+func (wm *WalletManager) SaveDepositConflict(conflict *DepositConflict) error {
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(conflict)
+}
+
+//GetDepositConflict 获取入账冲突记录
+func (wm *WalletManager) GetDepositConflict(id string) (*DepositConflict, error) {
+
+	var conflict DepositConflict
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.One("ID", id, &conflict)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conflict, nil
+}
+
+//GetDepositConflicts 获取未确认入账的冲突记录
+func (wm *WalletManager) GetDepositConflicts(depositTxID string) ([]*DepositConflict, error) {
+
+	var conflicts []*DepositConflict
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.Find("DepositTxID", depositTxID, &conflicts)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	return conflicts, nil
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//conflictObserver 记录扫描通知的观测者
+type conflictObserver struct {
+	conflicts   []*DepositConflict
+	extractData []*openwallet.TxExtractData
+}
+
+func (o *conflictObserver) BlockScanNotify(header *openwallet.BlockHeader) error {
+	return nil
+}
+
+func (o *conflictObserver) BlockExtractDataNotify(sourceKey string, data *openwallet.TxExtractData) error {
+	o.extractData = append(o.extractData, data)
+	return nil
+}
+
+func (o *conflictObserver) DepositConflictNotify(conflict *DepositConflict) error {
+	o.conflicts = append(o.conflicts, conflict)
+	return nil
+}
+
+func TestCheckDepositConflicts(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "deposit_conflict")
+	if err != nil {
+		t.Errorf("create temp dir failed, err: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	wm := NewWalletManager()
+	wm.Config.DBPath = dir
+	bs := NewVASBlockScanner(wm)
+	observer := &conflictObserver{}
+	bs.AddObserver(observer)
+
+	newDeposit := func(txid string, outpoints ...string) *ExtractResult {
+		data := openwallet.NewBlockExtractData()
+		data.TxOutputs = append(data.TxOutputs, &openwallet.TxOutPut{})
+		data.Transaction = &openwallet.Transaction{TxID: txid, Status: openwallet.TxStatusSuccess}
+		return &ExtractResult{
+			TxID:           txid,
+			spentOutpoints: outpoints,
+			extractData:    map[string]*openwallet.TxExtractData{"A": data},
+		}
+	}
+
+	//交易池中的入账
+	bs.checkDepositConflicts(newDeposit("d1", "aa:0"))
+	deposits, _ := wm.GetPendingDeposits()
+	if len(deposits) != 1 || deposits[0].TxID != "d1" {
+		t.Errorf("pending deposit is not tracked")
+		return
+	}
+
+	//交易池中出现替换交易，重复扫描只通知一次
+	replaced := &ExtractResult{TxID: "r1", spentOutpoints: []string{"aa:0"}}
+	bs.checkDepositConflicts(replaced)
+	bs.checkDepositConflicts(replaced)
+	if len(observer.conflicts) != 1 || observer.conflicts[0].Confirmed || observer.conflicts[0].DepositTxID != "d1" {
+		t.Errorf("unexpected conflicts: %d", len(observer.conflicts))
+		return
+	}
+
+	//替换交易确认，原入账标记为失败
+	bs.depositIndex = newDepositIndex()
+	replaced.BlockHeight = 100
+	bs.checkDepositConflicts(replaced)
+	if len(observer.conflicts) != 2 || !observer.conflicts[1].Confirmed {
+		t.Errorf("confirmed conflict is not notified")
+		return
+	}
+	if len(observer.extractData) != 1 || observer.extractData[0].Transaction.TxID != "d1" || observer.extractData[0].Transaction.Status != openwallet.TxStatusFail {
+		t.Errorf("original deposit is not marked failed")
+		return
+	}
+	deposits, _ = wm.GetPendingDeposits()
+	if len(deposits) != 0 {
+		t.Errorf("failed deposit should not be tracked")
+		return
+	}
+
+	//入账正常确认后结束跟踪
+	bs.checkDepositConflicts(newDeposit("d2", "bb:0"))
+	confirmed := newDeposit("d2", "bb:0")
+	confirmed.BlockHeight = 101
+	bs.checkDepositConflicts(confirmed)
+	bs.checkDepositConflicts(&ExtractResult{TxID: "r2", BlockHeight: 102, spentOutpoints: []string{"bb:0"}})
+	if len(observer.conflicts) != 2 {
+		t.Errorf("confirmed deposit should not conflict")
+		return
+	}
+
+	//omni代币入账被双花，代币记录同样标记为失败
+	omniDeposit := newDeposit("d3", "cc:0")
+	contractData := openwallet.NewBlockExtractData()
+	contractData.TxOutputs = append(contractData.TxOutputs, &openwallet.TxOutPut{})
+	contractData.Transaction = &openwallet.Transaction{TxID: "d3", Status: openwallet.TxStatusSuccess}
+	omniDeposit.extractContractData = map[string]*openwallet.TxExtractData{"A": contractData}
+	bs.checkDepositConflicts(omniDeposit)
+	bs.checkDepositConflicts(&ExtractResult{TxID: "r3", BlockHeight: 103, spentOutpoints: []string{"cc:0"}})
+	if len(observer.extractData) != 3 || observer.extractData[2].Transaction != contractData.Transaction || contractData.Transaction.Status != openwallet.TxStatusFail {
+		t.Errorf("omni deposit is not marked failed")
+		return
+	}
+
+	//超过跟踪期限的入账不再跟踪
+	bs.checkDepositConflicts(newDeposit("d4", "dd:0"))
+	deposits, _ = wm.GetPendingDeposits()
+	if len(deposits) != 1 {
+		t.Errorf("pending deposit is not tracked")
+		return
+	}
+	deposits[0].CreateTime -= int64(wm.Config.PendingDepositExpire.Seconds()) + 1
+	wm.SavePendingDeposit(deposits[0])
+	bs.depositIndex = newDepositIndex()
+	bs.checkDepositConflicts(&ExtractResult{TxID: "r4", BlockHeight: 104, spentOutpoints: []string{"dd:0"}})
+	deposits, _ = wm.GetPendingDeposits()
+	if len(deposits) != 0 || len(observer.conflicts) != 3 {
+		t.Errorf("expired deposit should not be tracked")
+	}
+}
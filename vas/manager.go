@@ -16,16 +16,19 @@
 package vas
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/blocktree/openwallet/hdkeystore"
 	"github.com/blocktree/openwallet/log"
 	"github.com/blocktree/openwallet/openwallet"
 	"github.com/shopspring/decimal"
-	"math"
 )
 
 type Transaction struct {
 	TxID          string
 	Size          uint64
+	Vsize         uint64 //虚拟大小，隔离见证交易小于Size
 	Version       uint64
 	LockTime      int64
 	Hex           string
@@ -48,6 +51,7 @@ type Vin struct {
 	N        uint64
 	Addr     string
 	Value    string
+	Sequence uint64
 }
 
 type Vout struct {
@@ -61,13 +65,42 @@ type Vout struct {
 type WalletManager struct {
 	openwallet.AssetsAdapterBase
 
-	Storage      *hdkeystore.HDKeystore        //秘钥存取
-	WalletClient *Client                       // 节点客户端
-	Config       *WalletConfig                 //钱包管理配置
-	Decoder      openwallet.AddressDecoder     //地址编码器
-	TxDecoder    openwallet.TransactionDecoder //交易单编码器
-	Log          *log.OWLogger                 //日志工具
-	Blockscanner *VASBlockScanner              //区块扫描器
+	Storage         *hdkeystore.HDKeystore        //秘钥存取
+	WalletClient    *Client                       // 节点客户端
+	OmniClient      *Client                       // OmniCore客户端
+	Config          *WalletConfig                 //钱包管理配置
+	Decoder         openwallet.AddressDecoder     //地址编码器
+	TxDecoder       openwallet.TransactionDecoder //交易单编码器
+	ContractDecoder *ContractDecoder              //智能合约解析器
+	Signer          Signer                        //交易单签名器
+	Log             *log.OWLogger                 //日志工具
+	Blockscanner    *VASBlockScanner              //区块扫描器
+	coinbaseCache   *coinbaseCache                //低确认数输出的coinbase标记缓存
+}
+
+//coinbaseCache 已查询过的低确认数输出是否来自coinbase交易，输出成熟后移除
+type coinbaseCache struct {
+	mu      sync.Mutex
+	outputs map[string]bool
+}
+
+func (cache *coinbaseCache) get(key string) (bool, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	isCoinBase, found := cache.outputs[key]
+	return isCoinBase, found
+}
+
+func (cache *coinbaseCache) set(key string, isCoinBase bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.outputs[key] = isCoinBase
+}
+
+func (cache *coinbaseCache) remove(key string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	delete(cache.outputs, key)
 }
 
 func NewWalletManager() *WalletManager {
@@ -75,8 +108,11 @@ func NewWalletManager() *WalletManager {
 	wm.Config = NewConfig(Symbol, CurveType, Decimals)
 	wm.Decoder = NewAddressDecoder(&wm)
 	wm.TxDecoder = NewTransactionDecoder(&wm)
+	wm.ContractDecoder = NewContractDecoder(&wm)
+	wm.Signer = NewHDSigner()
 	wm.Log = log.NewOWLogger(wm.Symbol())
 	wm.Blockscanner = NewVASBlockScanner(&wm)
+	wm.coinbaseCache = &coinbaseCache{outputs: make(map[string]bool)}
 
 	return &wm
 }
@@ -170,9 +206,72 @@ func (wm *WalletManager) getListUnspentByCore(min uint64, addresses ...string) (
 		utxos = append(utxos, NewUnspent(&a))
 	}
 
+	//挖矿收入未成熟前不可花费
+	err = wm.markImmatureUnspents(utxos)
+	if err != nil {
+		return nil, err
+	}
+
 	return utxos, nil
 }
 
+//markImmatureUnspents 标记未成熟的挖矿收入为不可花费
+func (wm *WalletManager) markImmatureUnspents(utxos []*Unspent) error {
+
+	for _, u := range utxos {
+
+		key := outpointKey(u.TxID, u.Vout)
+
+		//已成熟的无需检查
+		if u.Confirmations >= wm.Config.CoinbaseMaturity {
+			wm.coinbaseCache.remove(key)
+			continue
+		}
+
+		//未确认的utxo不可能是挖矿收入
+		if u.Confirmations == 0 {
+			continue
+		}
+
+		//只查询未缓存的输出，coinbase标记不会改变
+		if !u.IsCoinBase {
+			isCoinBase, found := wm.coinbaseCache.get(key)
+			if !found {
+				var err error
+				isCoinBase, err = wm.isCoinBaseOutput(u.TxID, u.Vout)
+				if err != nil {
+					return err
+				}
+				wm.coinbaseCache.set(key, isCoinBase)
+			}
+			u.IsCoinBase = isCoinBase
+		}
+
+		if u.IsImmature(wm.Config.CoinbaseMaturity) {
+			u.Spendable = false
+		}
+	}
+
+	return nil
+}
+
+//isCoinBaseOutput 查询输出是否来自coinbase交易
+func (wm *WalletManager) isCoinBaseOutput(txid string, vout uint64) (bool, error) {
+
+	request := []interface{}{
+		txid,
+		vout,
+		true,
+	}
+
+	result, err := wm.WalletClient.Call("gettxout", request)
+	if err != nil {
+		return false, err
+	}
+
+	return result.Get("coinbase").Bool(), nil
+}
+
 //GetInfo 获取核心钱包节点信息
 func (wm *WalletManager) GetInfo() error {
 
@@ -264,6 +363,52 @@ func (wm *WalletManager) sendRawTransactionByCore(txHex string) (string, error)
 
 }
 
+//GetOmniBalance 获取地址的omni代币余额
+func (wm *WalletManager) GetOmniBalance(address string, propertyID uint64) (decimal.Decimal, error) {
+
+	if wm.OmniClient == nil {
+		return decimal.Zero, fmt.Errorf("omni client is not setup")
+	}
+
+	request := []interface{}{
+		address,
+		propertyID,
+	}
+
+	result, err := wm.OmniClient.Call("omni_getbalance", request)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	/*
+		{
+			"balance": "1.00000000",
+			"reserved": "0.00000000",
+			"frozen": "0.00000000"
+		}
+	*/
+	return decimal.NewFromString(result.Get("balance").String())
+}
+
+//GetOmniTransaction 获取omni层解析的交易单
+func (wm *WalletManager) GetOmniTransaction(txid string) (*OmniTransaction, error) {
+
+	if wm.OmniClient == nil {
+		return nil, fmt.Errorf("omni client is not setup")
+	}
+
+	request := []interface{}{
+		txid,
+	}
+
+	result, err := wm.OmniClient.Call("omni_gettransaction", request)
+	if err != nil {
+		return nil, err
+	}
+
+	return newOmniTransaction(result), nil
+}
+
 //EstimateFeeRate 预估的没KB手续费率
 func (wm *WalletManager) EstimateFeeRate() (decimal.Decimal, error) {
 
@@ -277,18 +422,20 @@ func (wm *WalletManager) EstimateFeeRate() (decimal.Decimal, error) {
 	return wm.Config.MinFees, nil
 }
 
+//estimateTxBytes 预估交易单字节数，计算公式如下：180 * 输入数额 + 34 * 输出数额 + 10 + 额外字节数
+func estimateTxBytes(inputs, outputs, extraBytes int64) int64 {
+	return inputs*180 + outputs*34 + 10 + extraBytes
+}
+
 //EstimateFee 预估手续费
 func (wm *WalletManager) EstimateFee(inputs, outputs int64, feeRate decimal.Decimal) (decimal.Decimal, error) {
+	return wm.EstimateFeeWithExtraBytes(inputs, outputs, 0, feeRate)
+}
 
-	var piece int64 = 1
-
-	//UTXO如果大于设定限制，则分拆成多笔交易单发送
-	if inputs > int64(wm.Config.MaxTxInputs) {
-		piece = int64(math.Ceil(float64(inputs) / float64(wm.Config.MaxTxInputs)))
-	}
+//EstimateFeeWithExtraBytes 预估手续费，extraBytes为额外的交易字节数，例如OP_RETURN数据输出
+func (wm *WalletManager) EstimateFeeWithExtraBytes(inputs, outputs, extraBytes int64, feeRate decimal.Decimal) (decimal.Decimal, error) {
 
-	//计算公式如下：180 * 输入数额 + 34 * 输出数额 + 10
-	trx_bytes := decimal.New(inputs*180+outputs*34+piece*10, 0)
+	trx_bytes := decimal.New(estimateTxBytes(inputs, outputs, extraBytes), 0)
 	trx_fee := trx_bytes.Div(decimal.New(1000, 0)).Mul(feeRate)
 	trx_fee = trx_fee.Round(wm.Decimal())
 	//wm.Log.Debugf("trx_fee: %s", trx_fee.String())
@@ -303,6 +450,27 @@ func (wm *WalletManager) EstimateFee(inputs, outputs int64, feeRate decimal.Deci
 	//return wm.Config.MinFees, nil
 }
 
+//MaxTxInputsForOutputs 单笔交易单可使用的最大输入数，同时受最大输入数和标准交易最大重量限制
+func (wm *WalletManager) MaxTxInputsForOutputs(outputs int64) int {
+	return wm.MaxTxInputsWithExtraBytes(outputs, 0)
+}
+
+//MaxTxInputsWithExtraBytes 单笔交易单可使用的最大输入数，extraBytes为额外的交易字节数，例如OP_RETURN数据输出
+func (wm *WalletManager) MaxTxInputsWithExtraBytes(outputs, extraBytes int64) int {
+	maxInputs := wm.Config.MaxTxInputs
+	if wm.Config.MaxTxWeight > 0 {
+		//按非隔离见证交易估算，重量 = 字节数 * 4
+		byWeight := int((wm.Config.MaxTxWeight/4 - outputs*34 - 10 - extraBytes) / 180)
+		if byWeight < maxInputs {
+			maxInputs = byWeight
+		}
+	}
+	if maxInputs < 1 {
+		maxInputs = 1
+	}
+	return maxInputs
+}
+
 //GetBlockHash 根据区块高度获得区块hash
 func (wm *WalletManager) GetBlockByHeight(height uint32) (*Block, error) {
 
@@ -212,6 +212,63 @@ func TestListUnspent(t *testing.T) {
 	t.Logf("totalBalance: %s \n", totalBalance.String())
 }
 
+func TestGetBalanceDetailByAddress(t *testing.T) {
+
+	//aa未成熟的挖矿收入，bb已成熟的普通收入，cc低确认数需查询gettxout判断
+	var calls []string
+	node := newRecordingMockNode(map[string]string{
+		"listunspent": `[
+			{"txid":"aa","vout":0,"address":"VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV","amount":2,"confirmations":5,"coinbase":true},
+			{"txid":"bb","vout":0,"address":"VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV","amount":1,"confirmations":200},
+			{"txid":"cc","vout":1,"address":"VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV","amount":0.5,"confirmations":3}
+		]`,
+		"gettxout": `{"value":0.5,"coinbase":false}`,
+	}, &calls)
+	defer node.Close()
+
+	wm := NewWalletManager()
+	wm.WalletClient = NewClient(node.URL, "", false)
+
+	utxos, err := wm.ListUnspent(0, "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV")
+	if err != nil {
+		t.Errorf("ListUnspent failed unexpected error: %v\n", err)
+		return
+	}
+
+	spendable := filterSpendableUnspents(utxos)
+	if len(spendable) != 2 {
+		t.Errorf("spendable unspents = %d, want 2", len(spendable))
+		return
+	}
+	for _, u := range spendable {
+		if u.TxID == "aa" {
+			t.Errorf("immature coinbase unspent should not be spendable")
+			return
+		}
+	}
+
+	balances, err := wm.GetBalanceDetailByAddress("VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV")
+	if err != nil {
+		t.Errorf("GetBalanceDetailByAddress failed unexpected error: %v\n", err)
+		return
+	}
+	if len(balances) != 1 || balances[0].Balance.Balance != "1.5" || balances[0].ImmatureBalance != "2" {
+		t.Errorf("unexpected balance detail: %+v", balances[0])
+		return
+	}
+
+	//cc的coinbase标记已缓存，再次选币不重复查询
+	gettxout := 0
+	for _, method := range calls {
+		if method == "gettxout" {
+			gettxout++
+		}
+	}
+	if gettxout != 1 {
+		t.Errorf("gettxout calls = %d, want 1", gettxout)
+	}
+}
+
 func TestEstimateFee(t *testing.T) {
 	feeRate, _ := tw.EstimateFeeRate()
 	t.Logf("EstimateFee feeRate = %s\n", feeRate.StringFixed(8))
@@ -243,4 +300,26 @@ func TestWalletManager_ListAddresses(t *testing.T) {
 
 func TestWalletManager_GetInfo(t *testing.T) {
 	tw.GetInfo()
-}
\ No newline at end of file
+}
+
+func TestGetOmniBalance(t *testing.T) {
+
+	node := newMockNode(map[string]string{
+		"omni_getbalance": `{"balance":"12.5","reserved":"0.00000000","frozen":"0.00000000"}`,
+	})
+	defer node.Close()
+
+	wm := NewWalletManager()
+	wm.OmniClient = NewClient(node.URL, "", false)
+
+	balance, err := wm.GetOmniBalance("VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", 31)
+	if err != nil {
+		t.Errorf("GetOmniBalance failed unexpected error: %v", err)
+		return
+	}
+
+	if !balance.Equal(decimal.RequireFromString("12.5")) {
+		t.Errorf("unexpected omni balance: %s", balance.String())
+		return
+	}
+}
@@ -16,12 +16,15 @@
 package vas
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"github.com/assetsadapterstore/vas-adapter/vasTransaction"
 	"github.com/blocktree/openwallet/crypto"
 	"github.com/blocktree/openwallet/openwallet"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
 	"github.com/tidwall/gjson"
 	"strings"
 )
@@ -94,6 +97,8 @@ type Unspent struct {
 	Confirmations uint64 `json:"confirmations"`
 	Spendable     bool   `json:"spendable"`
 	Solvable      bool   `json:"solvable"`
+	IsCoinBase    bool   `json:"coinbase"`
+	RedeemScript  string `json:"redeemScript"`
 	HDAddress     openwallet.Address
 }
 
@@ -110,10 +115,23 @@ func NewUnspent(json *gjson.Result) *Unspent {
 	//obj.Spendable = gjson.Get(json.Raw, "spendable").Bool()
 	obj.Spendable = true
 	obj.Solvable = gjson.Get(json.Raw, "solvable").Bool()
+	obj.IsCoinBase = gjson.Get(json.Raw, "coinbase").Bool()
+	obj.RedeemScript = gjson.Get(json.Raw, "redeemScript").String()
 
 	return obj
 }
 
+//IsImmature 是否未成熟的挖矿收入，未成熟前不可花费
+func (u *Unspent) IsImmature(maturity uint64) bool {
+	return u.IsCoinBase && u.Confirmations < maturity
+}
+
+//BalanceDetail 地址余额明细，Balance只包含可花费部分，未成熟的挖矿收入单独统计
+type BalanceDetail struct {
+	*openwallet.Balance
+	ImmatureBalance string
+}
+
 type UnspentSort struct {
 	Values     []*Unspent
 	Comparator func(a, b *Unspent) int
@@ -283,6 +301,10 @@ func (wm *WalletManager) newTxByCore(json *gjson.Result) *Transaction {
 	obj.Confirmations = gjson.Get(json.Raw, "confirmations").Uint()
 	obj.Blocktime = gjson.Get(json.Raw, "blocktime").Int()
 	obj.Size = gjson.Get(json.Raw, "size").Uint()
+	obj.Vsize = gjson.Get(json.Raw, "vsize").Uint()
+	if obj.Vsize == 0 {
+		obj.Vsize = obj.Size
+	}
 	//obj.Fees = gjson.Get(json.Raw, "fees").String()
 	obj.Decimals = wm.Decimal()
 	obj.Vins = make([]*Vin, 0)
@@ -290,6 +312,9 @@ func (wm *WalletManager) newTxByCore(json *gjson.Result) *Transaction {
 		for i, vin := range vins.Array() {
 			input := newTxVinByCore(&vin)
 			input.N = uint64(i)
+			if len(input.Coinbase) > 0 {
+				obj.IsCoinBase = true
+			}
 			obj.Vins = append(obj.Vins, input)
 		}
 	}
@@ -324,6 +349,7 @@ func newTxVinByCore(json *gjson.Result) *Vin {
 	obj.TxID = gjson.Get(json.Raw, "txid").String()
 	obj.Vout = gjson.Get(json.Raw, "vout").Uint()
 	obj.Coinbase = gjson.Get(json.Raw, "coinbase").String()
+	obj.Sequence = gjson.Get(json.Raw, "sequence").Uint()
 	//obj.Addr = gjson.Get(json.Raw, "addr").String()
 	//obj.Value = gjson.Get(json.Raw, "value").String()
 
@@ -366,6 +392,205 @@ func newTxVoutByCore(json *gjson.Result) *Vout {
 	return &obj
 }
 
+//IsNullData 是否OP_RETURN数据输出
+func (v *Vout) IsNullData() bool {
+	if v.Type == "nulldata" || v.Type == "OP_RETURN" {
+		return true
+	}
+	return strings.HasPrefix(v.ScriptPubKey, "6a")
+}
+
+//NullData 提取OP_RETURN输出携带的数据
+func (v *Vout) NullData() ([]byte, error) {
+	if !v.IsNullData() {
+		return nil, fmt.Errorf("output is not OP_RETURN script")
+	}
+	return decodeNullDataScript(v.ScriptPubKey)
+}
+
+//IsReplaceable 交易单是否声明了BIP125可替换
+func (tx *Transaction) IsReplaceable() bool {
+	for _, input := range tx.Vins {
+		if input.Sequence <= uint64(vasTransaction.SequenceMaxBip125RBF) {
+			return true
+		}
+	}
+	return false
+}
+
+//Memo 提取交易单第一个OP_RETURN输出的备注数据，omni载荷不作为备注
+func (tx *Transaction) Memo() []byte {
+	for _, output := range tx.Vouts {
+		if !output.IsNullData() {
+			continue
+		}
+		data, err := output.NullData()
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		if isOmniPayload(data) {
+			continue
+		}
+		return data
+	}
+	return nil
+}
+
+//OmniPayload 提取交易单OP_RETURN输出中的omni载荷
+func (tx *Transaction) OmniPayload() (*OmniPayload, bool) {
+	for _, output := range tx.Vouts {
+		if !output.IsNullData() {
+			continue
+		}
+		data, err := output.NullData()
+		if err != nil {
+			continue
+		}
+		if payload, ok := decodeOmniPayload(data); ok {
+			return payload, true
+		}
+	}
+	return nil, false
+}
+
+//OmniSender omni交易的发送方，为输入金额合计最大的地址
+func (tx *Transaction) OmniSender() string {
+	var (
+		sender  string
+		maxSum  = decimal.Zero
+		sums    = make(map[string]decimal.Decimal)
+		ordered = make([]string, 0)
+	)
+	for _, input := range tx.Vins {
+		if len(input.Addr) == 0 {
+			continue
+		}
+		value, _ := decimal.NewFromString(input.Value)
+		if _, exist := sums[input.Addr]; !exist {
+			ordered = append(ordered, input.Addr)
+		}
+		sums[input.Addr] = sums[input.Addr].Add(value)
+	}
+	for _, addr := range ordered {
+		if sums[addr].GreaterThan(maxSum) || len(sender) == 0 {
+			sender = addr
+			maxSum = sums[addr]
+		}
+	}
+	return sender
+}
+
+//OmniReference omni交易的接收方，为最后一个非发送方的地址输出，没有时为最后一个地址输出
+func (tx *Transaction) OmniReference(sender string) string {
+	reference := ""
+	for i := len(tx.Vouts) - 1; i >= 0; i-- {
+		output := tx.Vouts[i]
+		if output.IsNullData() || len(output.Addr) == 0 {
+			continue
+		}
+		if output.Addr != sender {
+			return output.Addr
+		}
+		if len(reference) == 0 {
+			reference = output.Addr
+		}
+	}
+	return reference
+}
+
+const (
+	//omni交易类型
+	OmniSimpleSend = 0
+	//omni可分割代币的精度
+	OmniDivisibleDecimals = 8
+)
+
+//omni载荷标识
+var omniMarker = []byte("omni")
+
+//OmniPayload omni交易载荷
+type OmniPayload struct {
+	Version    uint16
+	TxType     uint16
+	PropertyID uint64
+	Amount     uint64
+}
+
+func isOmniPayload(data []byte) bool {
+	return len(data) >= 8 && string(data[:4]) == string(omniMarker)
+}
+
+//decodeOmniPayload 解析omni载荷，目前只支持simple send
+func decodeOmniPayload(data []byte) (*OmniPayload, bool) {
+	if !isOmniPayload(data) {
+		return nil, false
+	}
+	payload := &OmniPayload{
+		Version: binary.BigEndian.Uint16(data[4:6]),
+		TxType:  binary.BigEndian.Uint16(data[6:8]),
+	}
+	if payload.TxType == OmniSimpleSend {
+		if len(data) < 20 {
+			return nil, false
+		}
+		payload.PropertyID = uint64(binary.BigEndian.Uint32(data[8:12]))
+		payload.Amount = binary.BigEndian.Uint64(data[12:20])
+	}
+	return payload, true
+}
+
+//OmniTransaction omni层解析的交易单
+type OmniTransaction struct {
+	TxID             string
+	SendingAddress   string
+	ReferenceAddress string
+	TypeInt          uint64
+	PropertyID       uint64
+	Amount           string
+	Divisible        bool
+	Confirmations    uint64
+	//是否经过omni层验证，未确认的交易单没有验证结果
+	Validated     bool
+	Valid         bool
+	InvalidReason string
+}
+
+func newOmniTransaction(json *gjson.Result) *OmniTransaction {
+	obj := OmniTransaction{}
+	obj.TxID = json.Get("txid").String()
+	obj.SendingAddress = json.Get("sendingaddress").String()
+	obj.ReferenceAddress = json.Get("referenceaddress").String()
+	obj.TypeInt = json.Get("type_int").Uint()
+	obj.PropertyID = json.Get("propertyid").Uint()
+	obj.Amount = json.Get("amount").String()
+	obj.Divisible = json.Get("divisible").Bool()
+	obj.Confirmations = json.Get("confirmations").Uint()
+	obj.Validated = json.Get("valid").Exists()
+	obj.Valid = json.Get("valid").Bool()
+	obj.InvalidReason = json.Get("invalidreason").String()
+	return &obj
+}
+
+//decodeNullDataScript 解析OP_RETURN脚本，合并所有压栈数据
+func decodeNullDataScript(script string) ([]byte, error) {
+	scriptBytes, err := hex.DecodeString(script)
+	if err != nil {
+		return nil, err
+	}
+	if len(scriptBytes) == 0 || scriptBytes[0] != txscript.OP_RETURN {
+		return nil, fmt.Errorf("script is not OP_RETURN")
+	}
+	pushes, err := txscript.PushedData(scriptBytes[1:])
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0)
+	for _, p := range pushes {
+		data = append(data, p...)
+	}
+	return data, nil
+}
+
 func DecodeScript(script string) ([]byte, error) {
 	opcodes := strings.Split(script, " ")
 	scriptBuilder := txscript.NewScriptBuilder()
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"github.com/blocktree/openwallet/common"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//ContractDecoder omni代币解析器，通过OmniCore客户端查询代币余额
+type ContractDecoder struct {
+	*openwallet.SmartContractDecoderBase
+	wm *WalletManager
+}
+
+//NewContractDecoder 智能合约解析器
+func NewContractDecoder(wm *WalletManager) *ContractDecoder {
+	decoder := ContractDecoder{}
+	decoder.wm = wm
+	return &decoder
+}
+
+//GetTokenBalanceByAddress 查询地址的代币余额，合约地址为omni的propertyID
+func (decoder *ContractDecoder) GetTokenBalanceByAddress(contract openwallet.SmartContract, address ...string) ([]*openwallet.TokenBalance, error) {
+
+	var tokenBalanceList []*openwallet.TokenBalance
+
+	propertyID := common.NewString(contract.Address).UInt64()
+
+	for _, addr := range address {
+		balance, err := decoder.wm.GetOmniBalance(addr, propertyID)
+		if err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "get address[%s] omni token balance failed, err: %v", addr, err)
+		}
+
+		//按代币精度截断，避免四舍五入后余额大于实际可用余额
+		balanceStr := balance.Truncate(int32(contract.Decimals)).StringFixed(int32(contract.Decimals))
+		tokenBalance := &openwallet.TokenBalance{
+			Contract: &contract,
+			Balance: &openwallet.Balance{
+				Address:          addr,
+				Symbol:           contract.Symbol,
+				Balance:          balanceStr,
+				ConfirmBalance:   balanceStr,
+				UnconfirmBalance: "0",
+			},
+		}
+
+		tokenBalanceList = append(tokenBalanceList, tokenBalance)
+	}
+
+	return tokenBalanceList, nil
+}
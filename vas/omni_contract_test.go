@@ -0,0 +1,266 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+func TestContractDecoder_GetTokenBalanceByAddress(t *testing.T) {
+
+	node := newMockNode(map[string]string{
+		"omni_getbalance": `{"balance":"12.5","reserved":"0.00000000","frozen":"0.00000000"}`,
+	})
+	defer node.Close()
+
+	wm := NewWalletManager()
+	wm.OmniClient = NewClient(node.URL, "", false)
+
+	contract := openwallet.SmartContract{
+		Symbol:   wm.Symbol(),
+		Address:  "31",
+		Protocol: "omni",
+		Decimals: 8,
+	}
+
+	balances, err := wm.GetSmartContractDecoder().GetTokenBalanceByAddress(contract, "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", "VY1kyYTC4HS3VJtGyUnHnDhmgkHCDRRf6m")
+	if err != nil {
+		t.Errorf("GetTokenBalanceByAddress failed unexpected error: %v", err)
+		return
+	}
+
+	if len(balances) != 2 {
+		t.Errorf("unexpected token balance count: %d", len(balances))
+		return
+	}
+
+	for _, b := range balances {
+		if b.Balance.Balance != "12.50000000" || b.Contract.Address != "31" {
+			t.Errorf("unexpected token balance: %s, %+v", b.Balance.Address, b.Balance)
+		}
+	}
+
+	//按代币精度截断，不四舍五入
+	contract.Decimals = 0
+	balances, err = wm.ContractDecoder.GetTokenBalanceByAddress(contract, "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV")
+	if err != nil || len(balances) != 1 || balances[0].Balance.Balance != "12" {
+		t.Errorf("token balance should be truncated, err: %v", err)
+	}
+
+	//节点不支持omni接口时返回错误
+	unsupported := newMockNode(nil)
+	defer unsupported.Close()
+	wm.OmniClient = NewClient(unsupported.URL, "", false)
+	_, err = wm.ContractDecoder.GetTokenBalanceByAddress(contract, "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV")
+	if err == nil {
+		t.Errorf("GetTokenBalanceByAddress should fail when omni api is not supported")
+	}
+}
+
+func TestCreateOmniSummaryRawTransaction(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "omni_summary")
+	if err != nil {
+		t.Errorf("create temp dir failed, err: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	node := newMockNode(map[string]string{
+		"omni_getbalance:VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf": `{"balance":"12.5","reserved":"0.00000000","frozen":"0.00000000"}`,
+		"omni_getbalance:VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV": `{"balance":"0.5","reserved":"0.00000000","frozen":"0.00000000"}`,
+		"listunspent": `[{"txid":"d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401","vout":0,"address":"VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf","scriptPubKey":"76a914d46043209073ad39879356295562d952cd9dae3a88ac","amount":0.1,"confirmations":200}]`,
+	})
+	defer node.Close()
+
+	wm := NewWalletManager()
+	wm.Config.DBPath = dir
+	wm.Config.OmniSupport = true
+	wm.Config.OmniTransferCost = "0.00000546"
+	wm.WalletClient = NewClient(node.URL, "", false)
+	wm.OmniClient = wm.WalletClient
+	decoder := NewTransactionDecoder(wm)
+	wrapper := &policyWalletDAI{addresses: map[string]bool{
+		"VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf": true,
+		"VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV": true,
+	}}
+
+	contractID := openwallet.GenContractID(wm.Symbol(), "31")
+	sumRawTx := &openwallet.SummaryRawTransaction{
+		Coin: openwallet.Coin{
+			Symbol:     wm.Symbol(),
+			IsContract: true,
+			ContractID: contractID,
+			Contract:   openwallet.SmartContract{ContractID: contractID, Symbol: wm.Symbol(), Address: "31", Protocol: "omni", Decimals: 8},
+		},
+		Account:         &openwallet.AssetsAccount{AccountID: "acc"},
+		SummaryAddress:  "VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7",
+		MinTransfer:     "1",
+		RetainedBalance: "0.5",
+		FeeRate:         "0.0001",
+		AddressLimit:    -1,
+	}
+
+	//只有代币余额达到最低转账的地址参与汇总，汇总数量扣除保留余额
+	rawTxArray, err := decoder.CreateSummaryRawTransactionWithError(wrapper, sumRawTx)
+	if err != nil {
+		t.Errorf("CreateSummaryRawTransactionWithError failed unexpected error: %v", err)
+		return
+	}
+	if len(rawTxArray) != 1 || rawTxArray[0].Error != nil {
+		t.Errorf("unexpected omni summary result: %+v", rawTxArray)
+		return
+	}
+	rawTx := rawTxArray[0].RawTx
+	if rawTx.To["VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7"] != "12.00000000" || len(rawTx.RawHex) == 0 {
+		t.Errorf("unexpected omni summary transaction: %+v", rawTx)
+		return
+	}
+
+	//omni交易单签名前同样重新计算签名哈希
+	preview, err := decoder.CheckRawTransactionSigHashes(rawTx)
+	if err != nil {
+		t.Errorf("check omni sighashes failed, err: %v", err)
+		return
+	}
+	if preview.Fees.StringFixed(wm.Decimal()) != rawTx.Fees || preview.Omni == nil || preview.Omni.PropertyID != 31 || preview.Omni.Amount != 1200000000 ||
+		preview.OmniSender != "VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf" || preview.OmniReceiver != "VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7" {
+		t.Errorf("unexpected omni sign preview: %+v", preview)
+		return
+	}
+
+	//omni交易单签名同样执行签名策略
+	policy := NewSignPolicy()
+	policy.ContractLimits["31"] = &SignPolicyLimit{MaxTxAmount: decimal.RequireFromString("10")}
+	wm.Config.SignPolicy = policy
+	err = decoder.SignRawTransaction(wrapper, rawTx)
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != ErrSignPolicyViolation {
+		t.Errorf("omni token amount over limit should be rejected, err: %v", err)
+	}
+}
+
+func TestCreateOmniRawTransaction(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "omni_transfer")
+	if err != nil {
+		t.Errorf("create temp dir failed, err: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	utxo := `[{"txid":"%s","vout":0,"address":"VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf","scriptPubKey":"76a914d46043209073ad39879356295562d952cd9dae3a88ac","amount":%s,"confirmations":200}]`
+	newNode := func(txid, amount string) *httptest.Server {
+		return newMockNode(map[string]string{
+			"omni_getbalance:VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf": `{"balance":"12.5","reserved":"0.00000000","frozen":"0.00000000"}`,
+			"omni_getbalance:VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV": `{"balance":"0.5","reserved":"0.00000000","frozen":"0.00000000"}`,
+			"listunspent": fmt.Sprintf(utxo, txid, amount),
+		})
+	}
+
+	wm := NewWalletManager()
+	wm.Config.DBPath = dir
+	wm.Config.OmniSupport = true
+	decoder := NewTransactionDecoder(wm)
+	wrapper := &policyWalletDAI{addresses: map[string]bool{
+		"VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf": true,
+		"VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV": true,
+	}}
+
+	contractID := openwallet.GenContractID(wm.Symbol(), "31")
+	newRawTx := func(amount string) *openwallet.RawTransaction {
+		return &openwallet.RawTransaction{
+			Coin: openwallet.Coin{
+				Symbol:     wm.Symbol(),
+				IsContract: true,
+				ContractID: contractID,
+				Contract:   openwallet.SmartContract{ContractID: contractID, Symbol: wm.Symbol(), Address: "31", Protocol: "omni", Decimals: 8},
+			},
+			Account: &openwallet.AssetsAccount{AccountID: "acc"},
+			To:      map[string]string{"VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7": amount},
+			FeeRate: "0.0001",
+		}
+	}
+	use := func(node *httptest.Server) {
+		wm.WalletClient = NewClient(node.URL, "", false)
+		wm.OmniClient = wm.WalletClient
+	}
+
+	//没有单个地址持有足够的代币，即使账户合计足够
+	node := newNode("d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", "0.1")
+	defer node.Close()
+	use(node)
+	err = decoder.CreateOmniRawTransaction(wrapper, newRawTx("12.8"))
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientTokenBalanceOfAddress {
+		t.Errorf("not enough token should be refused, err: %v", err)
+		return
+	}
+
+	//选择持有足够代币的地址作为发送方，输出依次为参考输出、找零和omni载荷
+	rawTx := newRawTx("10")
+	err = decoder.CreateOmniRawTransaction(wrapper, rawTx)
+	if err != nil {
+		t.Errorf("CreateOmniRawTransaction failed unexpected error: %v", err)
+		return
+	}
+	preview, err := decoder.CheckRawTransactionSigHashes(rawTx)
+	if err != nil {
+		t.Errorf("check omni sighashes failed, err: %v", err)
+		return
+	}
+	if rawTx.Fees != "0.00002890" || preview.OmniSender != "VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf" || preview.Omni == nil || preview.Omni.Amount != 1000000000 {
+		t.Errorf("unexpected omni transaction: %+v, preview: %+v", rawTx, preview)
+		return
+	}
+	if len(preview.Outputs) != 3 ||
+		preview.Outputs[0].Address != "VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7" || preview.Outputs[0].Amount.String() != "0.00000546" ||
+		preview.Outputs[1].Address != "VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf" || preview.Outputs[1].Amount.String() != "0.09996564" ||
+		!preview.Outputs[2].NullData {
+		t.Errorf("unexpected omni outputs: %+v, %+v, %+v", preview.Outputs[0], preview.Outputs[1], preview.Outputs[len(preview.Outputs)-1])
+		return
+	}
+
+	//低于粉尘限额的找零并入手续费
+	dustNode := newNode("e134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", "0.0000374")
+	defer dustNode.Close()
+	use(dustNode)
+	rawTx = newRawTx("10")
+	err = decoder.CreateOmniRawTransaction(wrapper, rawTx)
+	if err != nil {
+		t.Errorf("CreateOmniRawTransaction failed unexpected error: %v", err)
+		return
+	}
+	preview, err = decoder.CheckRawTransactionSigHashes(rawTx)
+	if err != nil || rawTx.Fees != "0.00003194" || len(preview.Outputs) != 2 || !preview.Outputs[1].NullData {
+		t.Errorf("dust change should be added to fees, fees: %s, err: %v", rawTx.Fees, err)
+		return
+	}
+
+	//发送方地址的主链币不足以支付转账成本和手续费
+	poorNode := newNode("f134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", "0.00003")
+	defer poorNode.Close()
+	use(poorNode)
+	err = decoder.CreateOmniRawTransaction(wrapper, newRawTx("10"))
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientFees {
+		t.Errorf("not enough native coin should be refused, err: %v", err)
+	}
+}
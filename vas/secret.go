@@ -0,0 +1,93 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"fmt"
+
+	"github.com/blocktree/openwallet/hdkeystore"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//redacted 私钥格式化输出时的替代内容
+const redacted = "[REDACTED]"
+
+//PrivateKey 签名使用的私钥，任何格式化输出（日志、fmt、json）都不会显示内容，使用后调用Wipe清零
+type PrivateKey struct {
+	key []byte
+}
+
+//newPrivateKey 复制私钥数据并清零原数据
+func newPrivateKey(keyBytes []byte) *PrivateKey {
+	key := make([]byte, len(keyBytes))
+	copy(key, keyBytes)
+	wipeBytes(keyBytes)
+	return &PrivateKey{key: key}
+}
+
+//derivePrivateKey 根据签名地址的HDPath派生私钥
+func derivePrivateKey(key *hdkeystore.HDKey, keySignature *openwallet.KeySignature) (*PrivateKey, error) {
+	if keySignature.Address == nil {
+		return nil, fmt.Errorf("key signature address is empty")
+	}
+	childKey, err := key.DerivedKeyWithPath(keySignature.Address.HDPath, keySignature.EccType)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := childKey.GetPrivateKeyBytes()
+	if err != nil {
+		return nil, err
+	}
+	return newPrivateKey(keyBytes), nil
+}
+
+//Bytes 私钥数据，只能传给签名函数，不要保存或输出
+func (k *PrivateKey) Bytes() []byte {
+	return k.key
+}
+
+//Wipe 清零私钥数据
+func (k *PrivateKey) Wipe() {
+	wipeBytes(k.key)
+	k.key = nil
+}
+
+func (k *PrivateKey) String() string {
+	return redacted
+}
+
+func (k *PrivateKey) GoString() string {
+	return redacted
+}
+
+//Format 覆盖所有格式化动词，包括%x、%v、%+v
+func (k *PrivateKey) Format(f fmt.State, verb rune) {
+	f.Write([]byte(redacted))
+}
+
+func (k *PrivateKey) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redacted + `"`), nil
+}
+
+func (k *PrivateKey) MarshalText() ([]byte, error) {
+	return []byte(redacted), nil
+}
+
+func wipeBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
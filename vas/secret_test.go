@@ -0,0 +1,114 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+func TestPrivateKeyRedacted(t *testing.T) {
+
+	source := []byte{0xde, 0xad, 0xbe, 0xef}
+	key := newPrivateKey(source)
+
+	for _, b := range source {
+		if b != 0 {
+			t.Errorf("source key bytes are not wiped")
+			break
+		}
+	}
+
+	outputs := []string{
+		fmt.Sprintf("%v", key),
+		fmt.Sprintf("%+v", key),
+		fmt.Sprintf("%s", key),
+		fmt.Sprintf("%x", key),
+		fmt.Sprintf("%#v", struct{ Key *PrivateKey }{key}),
+	}
+	data, _ := json.Marshal(map[string]interface{}{"key": key})
+	outputs = append(outputs, string(data))
+
+	for _, out := range outputs {
+		if strings.Contains(out, "dead") || !strings.Contains(out, redacted) {
+			t.Errorf("private key is not redacted: %s", out)
+		}
+	}
+
+	if len(key.Bytes()) != 4 || key.Bytes()[0] != 0xde {
+		t.Errorf("private key bytes are not copied")
+		return
+	}
+
+	keyBytes := key.Bytes()
+	key.Wipe()
+	if keyBytes[0] != 0 || key.Bytes() != nil {
+		t.Errorf("private key is not wiped")
+	}
+}
+
+func TestSignAuditRecord(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sign_audit")
+	if err != nil {
+		t.Errorf("create temp dir failed, err: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	wm := NewWalletManager()
+	wm.Config.DBPath = dir
+
+	rawTx := &openwallet.RawTransaction{Sid: "order1", RawHex: "0100"}
+	keySignature := &openwallet.KeySignature{
+		Address: &openwallet.Address{Address: "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV"},
+		Message: "aabb",
+	}
+	wm.auditSignature(rawTx, "account", 0, keySignature)
+	wm.auditSignature(rawTx, "account", 1, keySignature)
+
+	records, err := wm.GetSignAuditRecords("order1")
+	if err != nil {
+		t.Errorf("get sign audit records failed, err: %v", err)
+		return
+	}
+	if len(records) != 2 || records[0].Address != "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV" || records[0].SigHash != "aabb" {
+		t.Errorf("unexpected sign audit records: %+v", records)
+		return
+	}
+
+	//签名时交易单号未知，广播后按未签名交易单哈希关联
+	unsignedTxHash := rawTx.GetExtParam().Get("unsignedTxHash").String()
+	if unsignedTxHash != "677b2d718464ee0121475600b929c0b4155667486577d1320b18c2dc7d4b4f99" || records[0].UnsignedTxHash != unsignedTxHash || len(records[0].TxID) > 0 {
+		t.Errorf("sign audit record should be keyed on unsigned tx hash: %+v", records[0])
+		return
+	}
+	err = wm.LinkSignAuditTxID(rawTx, "txid1")
+	if err != nil {
+		t.Errorf("link sign audit txid failed, err: %v", err)
+		return
+	}
+	records, _ = wm.GetSignAuditRecords("order1")
+	if len(records) != 2 || records[0].TxID != "txid1" || records[1].TxID != "txid1" {
+		t.Errorf("sign audit records should be linked to txid: %+v", records)
+	}
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+//SignAuditRecord 签名审计记录，只记录公开数据
+type SignAuditRecord struct {
+	ID             string `storm:"id"`
+	Sid            string `storm:"index"`
+	UnsignedTxHash string `storm:"index"` //未签名交易单的哈希，签名时交易单号未知，广播后关联TxID
+	TxID           string
+	AccountID      string
+	InputIndex     int
+	Address        string
+	SigHash        string
+	CreateTime     int64
+}
+
+//auditSignature 记录一次输入签名的审计日志，未签名交易单的哈希记录到扩展参数unsignedTxHash，广播后用于关联交易单号
+func (wm *WalletManager) auditSignature(rawTx *openwallet.RawTransaction, accountID string, inputIndex int, keySignature *openwallet.KeySignature) {
+
+	unsignedTxHash, err := newUnsignedTxHash(rawTx.RawHex)
+	if err != nil {
+		wm.Log.Errorf("compute unsigned tx hash failed, err: %v", err)
+	}
+	rawTx.SetExtParam("unsignedTxHash", unsignedTxHash)
+
+	record := &SignAuditRecord{
+		ID:             fmt.Sprintf("%s_%d_%d", keySignature.Message, inputIndex, time.Now().UnixNano()),
+		Sid:            rawTx.Sid,
+		UnsignedTxHash: unsignedTxHash,
+		TxID:           rawTx.TxID,
+		AccountID:      accountID,
+		InputIndex:     inputIndex,
+		SigHash:        keySignature.Message,
+		CreateTime:     time.Now().Unix(),
+	}
+	if keySignature.Address != nil {
+		record.Address = keySignature.Address.Address
+	}
+
+	wm.Log.Infof("sign audit: sid=%s unsignedTxHash=%s account=%s input=%d address=%s sighash=%s",
+		record.Sid, record.UnsignedTxHash, record.AccountID, record.InputIndex, record.Address, record.SigHash)
+
+	err = wm.SaveSignAuditRecord(record)
+	if err != nil {
+		wm.Log.Errorf("save sign audit record failed, err: %v", err)
+	}
+}
+
+//newUnsignedTxHash 未签名交易单的哈希，对交易单字节做双SHA256
+func newUnsignedTxHash(rawHex string) (string, error) {
+	txBytes, err := hex.DecodeString(rawHex)
+	if err != nil {
+		return "", fmt.Errorf("invalid transaction hex data")
+	}
+	return hex.EncodeToString(owcrypt.Hash(txBytes, 0, owcrypt.HASh_ALG_DOUBLE_SHA256)), nil
+}
+
+//LinkSignAuditTxID 交易单广播后，把交易单号写入签名审计记录，签名后的交易单无法还原未签名交易单，
+//未签名交易单哈希由签名时写入的扩展参数unsignedTxHash获取
+func (wm *WalletManager) LinkSignAuditTxID(rawTx *openwallet.RawTransaction, txid string) error {
+
+	var records []*SignAuditRecord
+
+	unsignedTxHash := rawTx.GetExtParam().Get("unsignedTxHash").String()
+	if len(unsignedTxHash) == 0 {
+		return nil
+	}
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.Find("UnsignedTxHash", unsignedTxHash, &records)
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	for _, record := range records {
+		record.TxID = txid
+		err = db.Save(record)
+		if err != nil {
+			return err
+		}
+		wm.Log.Infof("sign audit: sid=%s unsignedTxHash=%s input=%d txid=%s", record.Sid, record.UnsignedTxHash, record.InputIndex, record.TxID)
+	}
+
+	return nil
+}
+
+//SaveSignAuditRecord 保存签名审计记录
+func (wm *WalletManager) SaveSignAuditRecord(record *SignAuditRecord) error {
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(record)
+}
+
+//GetSignAuditRecords 获取业务订单号的签名审计记录
+func (wm *WalletManager) GetSignAuditRecords(sid string) ([]*SignAuditRecord, error) {
+
+	var records []*SignAuditRecord
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.Find("Sid", sid, &records)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	return records, nil
+}
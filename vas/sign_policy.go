@@ -0,0 +1,364 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/blocktree/openwallet/common"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	//ErrSignPolicyViolation 交易单违反签名策略
+	ErrSignPolicyViolation = 2010
+)
+
+const (
+	//签名策略规则
+	PolicyRuleBlacklist   = "blacklist"
+	PolicyRuleWhitelist   = "whitelist"
+	PolicyRuleMaxOutputs  = "maxOutputs"
+	PolicyRuleMaxFeeRate  = "maxFeeRate"
+	PolicyRuleMaxTxAmount = "maxTxAmount"
+	PolicyRuleMaxDaily    = "maxDailyAmount"
+)
+
+//SignPolicyLimit 账户的转出金额限制，0为不限制
+type SignPolicyLimit struct {
+	MaxTxAmount    decimal.Decimal `json:"maxTxAmount"`    //单笔交易转出上限
+	MaxDailyAmount decimal.Decimal `json:"maxDailyAmount"` //每日累计转出上限
+}
+
+//检查每日累计限额与记录转出金额之间需要完成签名，用进程锁保证原子性
+var signPolicyLock sync.Mutex
+
+//SignPolicy 签名策略，签名前检查交易单，数值为0或列表为空时不检查对应规则
+type SignPolicy struct {
+	SignPolicyLimit
+	AccountLimits  map[string]*SignPolicyLimit `json:"accountLimits"`  //指定账户的金额限制，覆盖默认限制
+	ContractLimits map[string]*SignPolicyLimit `json:"contractLimits"` //代币的金额限制，key为合约地址（omni的propertyID），未配置的代币不限制金额
+	Whitelist      []string                    `json:"whitelist"`      //允许转出的外部地址
+	Blacklist      []string                    `json:"blacklist"`      //禁止转出的地址
+	MaxFeeRate     decimal.Decimal             `json:"maxFeeRate"`     //每KB费率上限
+	MaxOutputs     int                         `json:"maxOutputs"`     //输出数量上限
+}
+
+//NewSignPolicy 不限制任何规则的签名策略
+func NewSignPolicy() *SignPolicy {
+	return &SignPolicy{
+		AccountLimits:  make(map[string]*SignPolicyLimit),
+		ContractLimits: make(map[string]*SignPolicyLimit),
+	}
+}
+
+//LoadSignPolicyFile 从json文件加载签名策略
+func LoadSignPolicyFile(path string) (*SignPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := NewSignPolicy()
+	err = json.Unmarshal(data, policy)
+	if err != nil {
+		return nil, fmt.Errorf("parse sign policy file failed, err: %v", err)
+	}
+	return policy, nil
+}
+
+//LimitOf 账户的金额限制
+func (policy *SignPolicy) LimitOf(accountID string) SignPolicyLimit {
+	if limit, ok := policy.AccountLimits[accountID]; ok && limit != nil {
+		return *limit
+	}
+	return policy.SignPolicyLimit
+}
+
+//ContractLimitOf 代币的金额限制
+func (policy *SignPolicy) ContractLimitOf(contractAddress string) SignPolicyLimit {
+	if limit, ok := policy.ContractLimits[contractAddress]; ok && limit != nil {
+		return *limit
+	}
+	return SignPolicyLimit{}
+}
+
+//SignPolicyUsage 账户已签名交易单的转出金额，用于统计每日累计转出
+type SignPolicyUsage struct {
+	ID         string `storm:"id"` //第一个输入的签名哈希，重复签名同一交易单不重复统计
+	AccountID  string `storm:"index"`
+	Contract   string //代币的合约地址，主链币为空
+	Date       string
+	Amount     string
+	CreateTime int64
+}
+
+//SignPolicyViolation 违反签名策略的审计记录
+type SignPolicyViolation struct {
+	ID         string `storm:"id"`
+	Sid        string `storm:"index"`
+	AccountID  string `storm:"index"`
+	Rule       string
+	Reason     string
+	CreateTime int64
+}
+
+//checkSignPolicy 按签名策略检查交易单，返回账户转出到外部地址的金额，违反时记录审计并返回ErrSignPolicyViolation
+//代币交易单的转出金额为omni载荷的代币数量，按代币的金额限制检查
+//调用方需持有signPolicyLock直到签名完成并记录转出金额
+func (decoder *TransactionDecoder) checkSignPolicy(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, preview *SignPreview) (decimal.Decimal, error) {
+
+	var (
+		policy    = decoder.wm.Config.SignPolicy
+		accountID string
+		contract  string
+		amount    = decimal.Zero
+		outputs   = 0
+	)
+
+	if rawTx.Account != nil {
+		accountID = rawTx.Account.AccountID
+	}
+
+	//代币交易单必须携带与合约一致的omni载荷
+	if rawTx.Coin.IsContract {
+		contract = rawTx.Coin.Contract.Address
+		if preview.Omni == nil || preview.Omni.TxType != OmniSimpleSend {
+			return amount, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "omni simple send payload is not found")
+		}
+		if preview.Omni.PropertyID != common.NewString(contract).UInt64() {
+			return amount, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "omni property: %d is not match the contract: %s", preview.Omni.PropertyID, contract)
+		}
+	}
+
+	if policy == nil {
+		return amount, nil
+	}
+
+	violate := func(rule, format string, args ...interface{}) (decimal.Decimal, error) {
+		reason := fmt.Sprintf(format, args...)
+		decoder.wm.auditSignPolicyViolation(rawTx, accountID, rule, reason)
+		return amount, openwallet.Errorf(ErrSignPolicyViolation, "sign policy [%s] violated: %s", rule, reason)
+	}
+
+	blacklist := make(map[string]bool)
+	for _, addr := range policy.Blacklist {
+		blacklist[addr] = true
+	}
+	whitelist := make(map[string]bool)
+	for _, addr := range policy.Whitelist {
+		whitelist[addr] = true
+	}
+
+	for _, output := range preview.Outputs {
+		if output.NullData {
+			continue
+		}
+		outputs++
+
+		if blacklist[output.Address] {
+			return violate(PolicyRuleBlacklist, "destination: %s is in blacklist", output.Address)
+		}
+
+		//本账户地址为找零，不计入转出金额
+		if isAccountAddress(wrapper, accountID, output.Address) {
+			continue
+		}
+
+		if len(whitelist) > 0 && !whitelist[output.Address] {
+			return violate(PolicyRuleWhitelist, "destination: %s is not in whitelist", output.Address)
+		}
+
+		amount = amount.Add(output.Amount)
+	}
+
+	if policy.MaxOutputs > 0 && outputs > policy.MaxOutputs {
+		return violate(PolicyRuleMaxOutputs, "outputs count: %d is over limit: %d", outputs, policy.MaxOutputs)
+	}
+
+	if policy.MaxFeeRate.GreaterThan(decimal.Zero) && preview.FeeRate.GreaterThan(policy.MaxFeeRate) {
+		return violate(PolicyRuleMaxFeeRate, "fee rate: %s is over limit: %s", preview.FeeRate.String(), policy.MaxFeeRate.String())
+	}
+
+	limit := policy.LimitOf(accountID)
+
+	if rawTx.Coin.IsContract {
+		amount = decimal.Zero
+		//接收方为本账户地址时不计入转出金额
+		if !isAccountAddress(wrapper, accountID, preview.OmniReceiver) {
+			amount = decimal.New(int64(preview.Omni.Amount), -int32(rawTx.Coin.Contract.Decimals))
+		}
+		limit = policy.ContractLimitOf(contract)
+	}
+
+	if limit.MaxTxAmount.GreaterThan(decimal.Zero) && amount.GreaterThan(limit.MaxTxAmount) {
+		return violate(PolicyRuleMaxTxAmount, "amount: %s is over limit: %s", amount.String(), limit.MaxTxAmount.String())
+	}
+
+	if limit.MaxDailyAmount.GreaterThan(decimal.Zero) {
+		spent, err := decoder.wm.getSignPolicyDailyAmount(accountID, contract, time.Now(), signPolicyUsageID(rawTx))
+		if err != nil {
+			return amount, err
+		}
+		if spent.Add(amount).GreaterThan(limit.MaxDailyAmount) {
+			return violate(PolicyRuleMaxDaily, "daily amount: %s + %s is over limit: %s", spent.String(), amount.String(), limit.MaxDailyAmount.String())
+		}
+	}
+
+	return amount, nil
+}
+
+//isAccountAddress 地址是否属于交易单的资产账户，钱包内其他账户的地址视为外部地址
+func isAccountAddress(wrapper openwallet.WalletDAI, accountID, address string) bool {
+	if len(accountID) == 0 {
+		return false
+	}
+	addr, err := wrapper.GetAddress(address)
+	return err == nil && addr != nil && addr.AccountID == accountID
+}
+
+//signPolicyUsageID 以第一个输入的签名哈希标识交易单
+func signPolicyUsageID(rawTx *openwallet.RawTransaction) string {
+	keySignatures, err := orderedKeySignatures(rawTx)
+	if err != nil || len(keySignatures) == 0 {
+		return ""
+	}
+	return keySignatures[0].Message
+}
+
+func signPolicyDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+//SaveSignPolicyUsage 记录账户已签名交易单的转出金额
+func (wm *WalletManager) SaveSignPolicyUsage(rawTx *openwallet.RawTransaction, amount decimal.Decimal) error {
+
+	usageID := signPolicyUsageID(rawTx)
+	if len(usageID) == 0 || rawTx.Account == nil {
+		return nil
+	}
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	now := time.Now()
+	usage := &SignPolicyUsage{
+		ID:         usageID,
+		AccountID:  rawTx.Account.AccountID,
+		Contract:   signPolicyContract(rawTx),
+		Date:       signPolicyDate(now),
+		Amount:     amount.String(),
+		CreateTime: now.Unix(),
+	}
+	return db.Save(usage)
+}
+
+//signPolicyContract 交易单转出代币的合约地址，主链币为空
+func signPolicyContract(rawTx *openwallet.RawTransaction) string {
+	if rawTx.Coin.IsContract {
+		return rawTx.Coin.Contract.Address
+	}
+	return ""
+}
+
+//GetSignPolicyDailyAmount 账户在指定日期已签名的主链币转出金额，excludeID为正在检查的交易单
+func (wm *WalletManager) GetSignPolicyDailyAmount(accountID string, day time.Time, excludeID string) (decimal.Decimal, error) {
+	return wm.getSignPolicyDailyAmount(accountID, "", day, excludeID)
+}
+
+//getSignPolicyDailyAmount 账户在指定日期已签名的转出金额，contract为空时统计主链币
+func (wm *WalletManager) getSignPolicyDailyAmount(accountID, contract string, day time.Time, excludeID string) (decimal.Decimal, error) {
+
+	var usages []*SignPolicyUsage
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return decimal.Zero, err
+	}
+	defer db.Close()
+
+	err = db.Find("AccountID", accountID, &usages)
+	if err != nil && err != storm.ErrNotFound {
+		return decimal.Zero, err
+	}
+
+	total := decimal.Zero
+	date := signPolicyDate(day)
+	for _, usage := range usages {
+		if usage.Date != date || usage.Contract != contract || usage.ID == excludeID {
+			continue
+		}
+		amount, _ := decimal.NewFromString(usage.Amount)
+		total = total.Add(amount)
+	}
+
+	return total, nil
+}
+
+//auditSignPolicyViolation 记录违反签名策略的审计日志
+func (wm *WalletManager) auditSignPolicyViolation(rawTx *openwallet.RawTransaction, accountID, rule, reason string) {
+
+	record := &SignPolicyViolation{
+		ID:         fmt.Sprintf("%s_%s_%d", accountID, rule, time.Now().UnixNano()),
+		Sid:        rawTx.Sid,
+		AccountID:  accountID,
+		Rule:       rule,
+		Reason:     reason,
+		CreateTime: time.Now().Unix(),
+	}
+
+	wm.Log.Warningf("sign policy violation: sid=%s account=%s rule=%s reason=%s", record.Sid, record.AccountID, record.Rule, record.Reason)
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		wm.Log.Errorf("save sign policy violation failed, err: %v", err)
+		return
+	}
+	defer db.Close()
+
+	err = db.Save(record)
+	if err != nil {
+		wm.Log.Errorf("save sign policy violation failed, err: %v", err)
+	}
+}
+
+//GetSignPolicyViolations 获取账户违反签名策略的审计记录
+func (wm *WalletManager) GetSignPolicyViolations(accountID string) ([]*SignPolicyViolation, error) {
+
+	var records []*SignPolicyViolation
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.Find("AccountID", accountID, &records)
+	if err != nil && err != storm.ErrNotFound {
+		return nil, err
+	}
+
+	return records, nil
+}
@@ -0,0 +1,212 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//policyWalletDAI 只实现地址查询的钱包数据接口
+type policyWalletDAI struct {
+	openwallet.WalletDAIBase
+	addresses map[string]bool
+	accounts  map[string]string //地址所属的资产账户
+}
+
+func (w *policyWalletDAI) GetAddress(address string) (*openwallet.Address, error) {
+	if w.addresses[address] {
+		return &openwallet.Address{Address: address, AccountID: w.accounts[address]}, nil
+	}
+	return nil, fmt.Errorf("address not found")
+}
+
+func (w *policyWalletDAI) GetAddressList(offset, limit int, cols ...interface{}) ([]*openwallet.Address, error) {
+	for i := 0; i+1 < len(cols); i += 2 {
+		if cols[i] == "Address" {
+			if address, ok := cols[i+1].(string); ok && w.addresses[address] {
+				return []*openwallet.Address{{Address: address}}, nil
+			}
+			return nil, fmt.Errorf("address not found")
+		}
+	}
+	//未指定地址时返回全部地址
+	addresses := make([]*openwallet.Address, 0)
+	for address := range w.addresses {
+		addresses = append(addresses, &openwallet.Address{Address: address})
+	}
+	return addresses, nil
+}
+
+func TestCheckSignPolicy(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sign_policy")
+	if err != nil {
+		t.Errorf("create temp dir failed, err: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	policyFile := filepath.Join(dir, "policy.json")
+	ioutil.WriteFile(policyFile, []byte(`{
+		"maxTxAmount": "1",
+		"maxDailyAmount": "1.5",
+		"accountLimits": {"vip": {"maxTxAmount": "10"}},
+		"blacklist": ["VBlack"],
+		"maxFeeRate": "0.01",
+		"maxOutputs": 2
+	}`), 0644)
+
+	policy, err := LoadSignPolicyFile(policyFile)
+	if err != nil {
+		t.Errorf("load sign policy failed, err: %v", err)
+		return
+	}
+
+	wm := NewWalletManager()
+	wm.Config.DBPath = dir
+	wm.Config.SignPolicy = policy
+	decoder := NewTransactionDecoder(wm)
+	wrapper := &policyWalletDAI{
+		addresses: map[string]bool{"VChange": true, "VOther": true},
+		accounts:  map[string]string{"VChange": "A", "VOther": "B"},
+	}
+
+	newRawTx := func(accountID, message string) *openwallet.RawTransaction {
+		return &openwallet.RawTransaction{
+			Account:    &openwallet.AssetsAccount{AccountID: accountID},
+			Signatures: map[string][]*openwallet.KeySignature{accountID: {{Message: message}}},
+		}
+	}
+	newPreview := func(feeRate string, outputs ...*SignPreviewOutput) *SignPreview {
+		return &SignPreview{Outputs: outputs, FeeRate: decimal.RequireFromString(feeRate)}
+	}
+	output := func(address, amount string) *SignPreviewOutput {
+		return &SignPreviewOutput{Address: address, Amount: decimal.RequireFromString(amount)}
+	}
+
+	//找零不计入转出金额
+	rawTx := newRawTx("A", "h1")
+	amount, err := decoder.checkSignPolicy(wrapper, rawTx, newPreview("0.001", output("VTo", "0.8"), output("VChange", "5")))
+	if err != nil || !amount.Equal(decimal.RequireFromString("0.8")) {
+		t.Errorf("check sign policy unexpected result: %s, %v", amount.String(), err)
+		return
+	}
+	wm.SaveSignPolicyUsage(rawTx, amount)
+
+	//重复检查同一交易单不累计
+	if _, err = decoder.checkSignPolicy(wrapper, rawTx, newPreview("0.001", output("VTo", "0.8"))); err != nil {
+		t.Errorf("re-sign the same transaction should pass, err: %v", err)
+	}
+
+	cases := []struct {
+		accountID string
+		preview   *SignPreview
+		rule      string
+	}{
+		{"A", newPreview("0.001", output("VBlack", "0.1")), PolicyRuleBlacklist},
+		{"A", newPreview("0.001", output("V1", "0.1"), output("V2", "0.1"), output("V3", "0.1")), PolicyRuleMaxOutputs},
+		{"A", newPreview("0.02", output("VTo", "0.1")), PolicyRuleMaxFeeRate},
+		{"A", newPreview("0.001", output("VTo", "1.1")), PolicyRuleMaxTxAmount},
+		{"A", newPreview("0.001", output("VOther", "1.1")), PolicyRuleMaxTxAmount}, //钱包内其他账户的地址计入转出金额
+		{"A", newPreview("0.001", output("VTo", "0.8")), PolicyRuleMaxDaily},
+	}
+
+	for i, c := range cases {
+		_, err = decoder.checkSignPolicy(wrapper, newRawTx(c.accountID, fmt.Sprintf("c%d", i)), c.preview)
+		owErr, ok := err.(*openwallet.Error)
+		if !ok || owErr.Code() != ErrSignPolicyViolation {
+			t.Errorf("case %d: rule %s should be violated, err: %v", i, c.rule, err)
+		}
+	}
+
+	//账户单独的限额
+	if _, err = decoder.checkSignPolicy(wrapper, newRawTx("vip", "v1"), newPreview("0.001", output("VTo", "5"))); err != nil {
+		t.Errorf("account limit should override default limit, err: %v", err)
+	}
+
+	violations, err := wm.GetSignPolicyViolations("A")
+	if err != nil || len(violations) != len(cases) {
+		t.Errorf("unexpected sign policy violations: %d, %v", len(violations), err)
+	}
+
+	spent, _ := wm.GetSignPolicyDailyAmount("A", time.Now(), "")
+	if !spent.Equal(decimal.RequireFromString("0.8")) {
+		t.Errorf("unexpected daily amount: %s", spent.String())
+		return
+	}
+
+	//代币按omni载荷数量和代币限额检查，每日累计与主链币分开统计
+	policy.ContractLimits = map[string]*SignPolicyLimit{"31": {MaxTxAmount: decimal.RequireFromString("100"), MaxDailyAmount: decimal.RequireFromString("150")}}
+	newTokenRawTx := func(message string) *openwallet.RawTransaction {
+		rawTx := newRawTx("A", message)
+		rawTx.Coin = openwallet.Coin{IsContract: true, Contract: openwallet.SmartContract{Address: "31", Decimals: 8}}
+		return rawTx
+	}
+	newTokenPreview := func(property, amount uint64, receiver string) *SignPreview {
+		preview := newPreview("0.001", output(receiver, "0.00000546"))
+		preview.Omni = &OmniPayload{TxType: OmniSimpleSend, PropertyID: property, Amount: amount}
+		preview.OmniReceiver = receiver
+		return preview
+	}
+
+	tokenRawTx := newTokenRawTx("t1")
+	amount, err = decoder.checkSignPolicy(wrapper, tokenRawTx, newTokenPreview(31, 8000000000, "VTo"))
+	if err != nil || !amount.Equal(decimal.RequireFromString("80")) {
+		t.Errorf("check token sign policy unexpected result: %s, %v", amount.String(), err)
+		return
+	}
+	wm.SaveSignPolicyUsage(tokenRawTx, amount)
+
+	tokenCases := []struct {
+		preview *SignPreview
+		rule    string
+	}{
+		{newTokenPreview(31, 1000000000, "VBlack"), PolicyRuleBlacklist},
+		{newTokenPreview(31, 11000000000, "VTo"), PolicyRuleMaxTxAmount},
+		{newTokenPreview(31, 11000000000, "VOther"), PolicyRuleMaxTxAmount},
+		{newTokenPreview(31, 8000000000, "VTo"), PolicyRuleMaxDaily},
+	}
+	for i, c := range tokenCases {
+		_, err = decoder.checkSignPolicy(wrapper, newTokenRawTx(fmt.Sprintf("t%d", i+2)), c.preview)
+		owErr, ok := err.(*openwallet.Error)
+		if !ok || owErr.Code() != ErrSignPolicyViolation {
+			t.Errorf("token case %d: rule %s should be violated, err: %v", i, c.rule, err)
+		}
+	}
+
+	//载荷与合约不一致时拒绝签名
+	if _, err = decoder.checkSignPolicy(wrapper, newTokenRawTx("t9"), newTokenPreview(3, 1, "VTo")); err == nil {
+		t.Errorf("omni property mismatch should be rejected")
+	}
+
+	//内部转账不计入转出金额
+	if amount, err = decoder.checkSignPolicy(wrapper, newTokenRawTx("t10"), newTokenPreview(31, 50000000000, "VChange")); err != nil || !amount.IsZero() {
+		t.Errorf("token transfer to wallet address should not be counted: %s, %v", amount.String(), err)
+	}
+
+	spent, _ = wm.GetSignPolicyDailyAmount("A", time.Now(), "")
+	if !spent.Equal(decimal.RequireFromString("0.8")) {
+		t.Errorf("token usage should not be counted in coin daily amount: %s", spent.String())
+	}
+}
@@ -0,0 +1,222 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/assetsadapterstore/vas-adapter/vasTransaction"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/imroc/req"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	//签名器类型
+	SignerTypeHD     = "hd"     //钱包HD密钥在进程内签名
+	SignerTypeRemote = "remote" //远程签名服务
+	SignerTypeCore   = "core"   //核心钱包使用节点内的私钥签名
+)
+
+//SignContext 交易单上下文，供签名服务审核将要授权的内容
+type SignContext struct {
+	Sid     string               `json:"sid"`
+	Symbol  string               `json:"symbol"`
+	RawHex  string               `json:"rawHex"`
+	Fees    string               `json:"fees"`
+	To      map[string]string    `json:"to"`
+	Outputs []*SignPreviewOutput `json:"outputs,omitempty"`
+}
+
+//SignRequest 单个输入的签名请求
+type SignRequest struct {
+	AccountID  string `json:"accountID"`
+	InputIndex int    `json:"inputIndex"`
+	Address    string `json:"address"`
+	PublicKey  string `json:"publicKey"`
+	HDPath     string `json:"hdPath"`
+	EccType    uint32 `json:"eccType"`
+	SigHash    string `json:"sigHash"`
+}
+
+//Signer 签名器，对交易单的签名哈希签名，返回与请求顺序一致的64字节签名(r||s)
+type Signer interface {
+	Sign(wrapper openwallet.WalletDAI, context *SignContext, requests []*SignRequest) ([][]byte, error)
+}
+
+//HDSigner 使用钱包HD密钥在进程内签名
+type HDSigner struct{}
+
+//NewHDSigner 创建HD密钥签名器
+func NewHDSigner() *HDSigner {
+	return &HDSigner{}
+}
+
+//Sign 派生每个输入地址的私钥签名，私钥使用后清零
+func (signer *HDSigner) Sign(wrapper openwallet.WalletDAI, context *SignContext, requests []*SignRequest) ([][]byte, error) {
+
+	key, err := wrapper.HDKey()
+	if err != nil {
+		return nil, err
+	}
+
+	signatures := make([][]byte, 0, len(requests))
+	for _, r := range requests {
+		privateKey, err := derivePrivateKey(key, &openwallet.KeySignature{
+			EccType: r.EccType,
+			Address: &openwallet.Address{Address: r.Address, HDPath: r.HDPath},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		sigPub, err := vasTransaction.SignRawTransactionHash(r.SigHash, privateKey.Bytes())
+		privateKey.Wipe()
+		if err != nil {
+			return nil, fmt.Errorf("transaction hash sign failed, unexpected error: %v", err)
+		}
+		signatures = append(signatures, sigPub.Signature)
+	}
+
+	return signatures, nil
+}
+
+//RemoteSigner JSON over HTTP的远程签名服务
+//请求：POST {"context": SignContext, "requests": [SignRequest]}
+//响应：{"signatures": ["hex"]} 或 {"error": "message"}
+type RemoteSigner struct {
+	URL     string
+	Token   string
+	Timeout time.Duration
+	api     *req.Req
+}
+
+//NewRemoteSigner 创建远程签名器，签名期间持有签名锁，请求必须设置超时时间
+func NewRemoteSigner(url, token string, timeout time.Duration) *RemoteSigner {
+	api := req.New()
+	api.SetTimeout(timeout)
+	return &RemoteSigner{
+		URL:     url,
+		Token:   token,
+		Timeout: timeout,
+		api:     api,
+	}
+}
+
+//Sign 发送签名请求到远程签名服务，返回的签名用请求中的公钥验证
+func (signer *RemoteSigner) Sign(wrapper openwallet.WalletDAI, context *SignContext, requests []*SignRequest) ([][]byte, error) {
+
+	body := map[string]interface{}{
+		"context":  context,
+		"requests": requests,
+	}
+
+	header := req.Header{
+		"Accept": "application/json",
+	}
+	if len(signer.Token) > 0 {
+		header["Authorization"] = "Bearer " + signer.Token
+	}
+
+	r, err := signer.api.Post(signer.URL, req.BodyJSON(&body), header)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, fmt.Errorf("remote signer request timeout: %v", signer.Timeout)
+		}
+		return nil, err
+	}
+
+	if r.Response().StatusCode != 200 {
+		return nil, fmt.Errorf("remote signer response status: %d, body: %s", r.Response().StatusCode, r.String())
+	}
+
+	resp := gjson.ParseBytes(r.Bytes())
+	if errMsg := resp.Get("error"); errMsg.Exists() && errMsg.Type != gjson.Null {
+		return nil, fmt.Errorf("remote signer refused: %s", errMsg.String())
+	}
+
+	results := resp.Get("signatures").Array()
+	if len(results) != len(requests) {
+		return nil, fmt.Errorf("remote signer returns %d signatures, expected %d", len(results), len(requests))
+	}
+
+	signatures := make([][]byte, 0, len(requests))
+	for i, result := range results {
+		signature, err := hex.DecodeString(result.String())
+		if err != nil || len(signature) != 64 {
+			return nil, fmt.Errorf("remote signer returns invalid signature of input %d", requests[i].InputIndex)
+		}
+		err = verifySignHash(requests[i], signature)
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, signature)
+	}
+
+	return signatures, nil
+}
+
+//verifySignHash 用请求地址的公钥验证签名
+func verifySignHash(r *SignRequest, signature []byte) error {
+	pubkey, err := hex.DecodeString(r.PublicKey)
+	if err != nil || len(pubkey) != 33 {
+		return fmt.Errorf("public key of input %d is invalid", r.InputIndex)
+	}
+	hash, err := hex.DecodeString(r.SigHash)
+	if err != nil || len(hash) != 32 {
+		return fmt.Errorf("sighash of input %d is invalid", r.InputIndex)
+	}
+	pubkey = owcrypt.PointDecompress(pubkey, owcrypt.ECC_CURVE_SECP256K1)[1:]
+	if owcrypt.Verify(pubkey, nil, 0, hash, 32, signature, owcrypt.ECC_CURVE_SECP256K1) != owcrypt.SUCCESS {
+		return fmt.Errorf("signature of input %d is not match the public key", r.InputIndex)
+	}
+	return nil
+}
+
+//newSignRequest 根据待签名信息创建签名请求
+func newSignRequest(accountID string, inputIndex int, keySignature *openwallet.KeySignature) *SignRequest {
+	r := &SignRequest{
+		AccountID:  accountID,
+		InputIndex: inputIndex,
+		EccType:    keySignature.EccType,
+		SigHash:    keySignature.Message,
+	}
+	if keySignature.Address != nil {
+		r.Address = keySignature.Address.Address
+		r.PublicKey = keySignature.Address.PublicKey
+		r.HDPath = keySignature.Address.HDPath
+	}
+	return r
+}
+
+//newSignContext 创建签名上下文，preview为空时不包含解析的输出
+func (wm *WalletManager) newSignContext(rawTx *openwallet.RawTransaction, preview *SignPreview) *SignContext {
+	context := &SignContext{
+		Sid:    rawTx.Sid,
+		Symbol: wm.Symbol(),
+		RawHex: rawTx.RawHex,
+		Fees:   rawTx.Fees,
+		To:     rawTx.To,
+	}
+	if preview != nil {
+		context.Outputs = preview.Outputs
+	}
+	return context
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/assetsadapterstore/vas-adapter/vasTransaction"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/tidwall/gjson"
+)
+
+//newRemoteSignerServer 模拟远程签名服务，用固定私钥签名
+func newRemoteSignerServer(t *testing.T, prikey []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		req := gjson.ParseBytes(body)
+		if req.Get("context.sid").String() != "order1" {
+			w.Write([]byte(`{"error":"missing transaction context"}`))
+			return
+		}
+		signatures := make([]string, 0)
+		for _, request := range req.Get("requests").Array() {
+			sigPub, err := vasTransaction.SignRawTransactionHash(request.Get("sigHash").String(), prikey)
+			if err != nil {
+				t.Errorf("fake remote signer sign failed, err: %v", err)
+			}
+			signatures = append(signatures, hex.EncodeToString(sigPub.Signature))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"signatures": signatures})
+	}))
+}
+
+func TestRemoteSigner(t *testing.T) {
+
+	prikey, _ := hex.DecodeString("80bc398d7c4a674daa977566c2e6cd5040520027e57fe806dfaa868df4cc43ab")
+	pubkey, _ := owcrypt.GenPubkey(prikey, owcrypt.ECC_CURVE_SECP256K1)
+	pubkey = owcrypt.PointCompress(pubkey, owcrypt.ECC_CURVE_SECP256K1)
+
+	server := newRemoteSignerServer(t, prikey)
+	defer server.Close()
+
+	requests := []*SignRequest{
+		{InputIndex: 0, Address: "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", PublicKey: hex.EncodeToString(pubkey), HDPath: "m/44'/88'/0'/0/1", SigHash: "bcbd8d9c35f5d7da24ba5b6e1b3b5ee0c3a4b2bd0cff8d3e2d0d6e5a2b6d8e10"},
+		{InputIndex: 1, Address: "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", PublicKey: hex.EncodeToString(pubkey), HDPath: "m/44'/88'/0'/0/1", SigHash: "0a9d1c5ee0c3a4b2bd0cff8d3e2d0d6e5a2b6d8e10bcbd8d9c35f5d7da24ba5b"},
+	}
+	context := &SignContext{Sid: "order1", Symbol: "VAS"}
+
+	signer := NewRemoteSigner(server.URL, "token", time.Second)
+	signatures, err := signer.Sign(nil, context, requests)
+	if err != nil {
+		t.Errorf("remote signer sign failed, err: %v", err)
+		return
+	}
+	if len(signatures) != 2 || len(signatures[0]) != 64 {
+		t.Errorf("unexpected remote signatures: %d", len(signatures))
+		return
+	}
+
+	//签名与请求的公钥不一致
+	otherKey, _ := hex.DecodeString("1111111111111111111111111111111111111111111111111111111111111111")
+	otherServer := newRemoteSignerServer(t, otherKey)
+	defer otherServer.Close()
+	if _, err = NewRemoteSigner(otherServer.URL, "token", time.Second).Sign(nil, context, requests); err == nil {
+		t.Errorf("signature of other key should be rejected")
+	}
+
+	//签名服务拒绝
+	if _, err = signer.Sign(nil, &SignContext{Sid: "order2"}, requests); err == nil {
+		t.Errorf("refused request should return error")
+	}
+
+	//未授权
+	if _, err = NewRemoteSigner(server.URL, "", time.Second).Sign(nil, context, requests); err == nil {
+		t.Errorf("unauthorized request should return error")
+	}
+
+	//签名服务无响应时超时返回
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer slowServer.Close()
+	if _, err = NewRemoteSigner(slowServer.URL, "token", 50*time.Millisecond).Sign(nil, context, requests); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("slow remote signer should time out, err: %v", err)
+	}
+}
@@ -16,6 +16,8 @@
 package vas
 
 import (
+	"bytes"
+	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -24,6 +26,8 @@ import (
 	"github.com/blocktree/openwallet/common"
 	"github.com/blocktree/openwallet/openwallet"
 	"github.com/shopspring/decimal"
+	"math"
+	"math/big"
 	"sort"
 	"strings"
 	"time"
@@ -43,7 +47,11 @@ func NewTransactionDecoder(wm *WalletManager) *TransactionDecoder {
 
 //CreateRawTransaction 创建交易单
 func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
-	return decoder.CreateBTCRawTransaction(wrapper, rawTx)
+	if rawTx.Coin.IsContract {
+		return decoder.CreateOmniRawTransaction(wrapper, rawTx)
+	} else {
+		return decoder.CreateBTCRawTransaction(wrapper, rawTx)
+	}
 }
 
 //SignRawTransaction 签名交易单
@@ -71,7 +79,7 @@ func (decoder *TransactionDecoder) CreateSummaryRawTransaction(wrapper openwalle
 		rawTxArray        = make([]*openwallet.RawTransaction, 0)
 		err               error
 	)
-	rawTxWithErrArray, err = decoder.CreateVASSummaryRawTransaction(wrapper, sumRawTx)
+	rawTxWithErrArray, err = decoder.CreateSummaryRawTransactionWithError(wrapper, sumRawTx)
 	if err != nil {
 		return nil, err
 	}
@@ -95,6 +103,16 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 		return nil, fmt.Errorf("transaction is not completed validation")
 	}
 
+	//广播前检查
+	preflight, err := decoder.PreflightRawTransaction(rawTx)
+	if err != nil {
+		return nil, err
+	}
+	if !preflight.Allowed {
+		decoder.wm.Log.Warningf("[Sid: %s] preflight rejected raw hex: %s", rawTx.Sid, rawTx.RawHex)
+		return nil, openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, "transaction rejected by preflight: %s", preflight.RejectReason)
+	}
+
 	txid, err := decoder.wm.SendRawTransaction(rawTx.RawHex)
 	if err != nil {
 		decoder.wm.Log.Warningf("[Sid: %s] submit raw hex: %s", rawTx.Sid, rawTx.RawHex)
@@ -104,6 +122,23 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 	rawTx.TxID = txid
 	rawTx.IsSubmit = true
 
+	//签名审计记录关联交易单号
+	if err := decoder.wm.LinkSignAuditTxID(rawTx, txid); err != nil {
+		decoder.wm.Log.Errorf("link sign audit txid failed, err: %v", err)
+	}
+
+	//标记锁定的utxo已花费
+	if reservationID := rawTx.GetExtParam().Get("reservationID").String(); len(reservationID) > 0 {
+		if err := decoder.wm.MarkUTXOReservationSpent(reservationID, txid); err != nil {
+			decoder.wm.Log.Errorf("mark utxo reservation spent failed, err: %v", err)
+		}
+	}
+
+	//记录已广播的交易单，用于跟踪确认和重新广播
+	if err := decoder.wm.TrackOutboundTx(rawTx); err != nil {
+		decoder.wm.Log.Errorf("track outbound tx failed, err: %v", err)
+	}
+
 	decimals := int32(0)
 	fees := "0"
 	if rawTx.Coin.IsContract {
@@ -171,6 +206,16 @@ func (decoder *TransactionDecoder) CreateBTCRawTransaction(wrapper openwallet.Wa
 		return err
 	}
 
+	//已查询账户全部地址时，清理utxo已不存在的预留记录
+	if len(address) < limit {
+		if err := decoder.wm.PruneUTXOReservations(accountID, unspents); err != nil {
+			decoder.wm.Log.Errorf("prune utxo reservations failed, err: %v", err)
+		}
+	}
+
+	//排除已被其他交易单锁定的utxo
+	unspents = decoder.wm.filterReservedUnspents(unspents)
+
 	if len(unspents) == 0 {
 		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "[%s] balance is not enough", accountID)
 	}
@@ -179,6 +224,16 @@ func (decoder *TransactionDecoder) CreateBTCRawTransaction(wrapper openwallet.Wa
 		return errors.New("Receiver addresses is empty!")
 	}
 
+	//OP_RETURN备注数据
+	memoData, err := getRawTransactionMemoData(rawTx)
+	if err != nil {
+		return err
+	}
+	memoBytes := int64(0)
+	if len(memoData) > 0 {
+		memoBytes = int64(vasTransaction.NullDataOutputSize(len(memoData)))
+	}
+
 	//计算总发送金额
 	for addr, amount := range rawTx.To {
 		deamount, _ := decimal.NewFromString(amount)
@@ -240,7 +295,7 @@ func (decoder *TransactionDecoder) CreateBTCRawTransaction(wrapper openwallet.Wa
 		}
 
 		//计算手续费，找零地址有2个，一个是发送，一个是新创建的
-		fees, err := decoder.wm.EstimateFee(int64(len(usedUTXO)), int64(len(destinations)+1), feesRate)
+		fees, err := decoder.wm.EstimateFeeWithExtraBytes(int64(len(usedUTXO)), int64(len(destinations)+1), memoBytes, feesRate)
 		if err != nil {
 			return err
 		}
@@ -259,10 +314,10 @@ func (decoder *TransactionDecoder) CreateBTCRawTransaction(wrapper openwallet.Wa
 
 	}
 
-	//UTXO如果大于设定限制，则分拆成多笔交易单发送
-	if len(usedUTXO) > decoder.wm.Config.MaxTxInputs {
-		errStr := fmt.Sprintf("The transaction is use max inputs over: %d", decoder.wm.Config.MaxTxInputs)
-		return errors.New(errStr)
+	//UTXO如果大于设定限制，改为优先使用大额utxo构建，仍超过限制时需要通过CreateRawTransactionPieces分拆成多笔交易单发送
+	if maxInputs := decoder.wm.MaxTxInputsWithExtraBytes(int64(len(destinations)+1), memoBytes); len(usedUTXO) > maxInputs {
+		_, err = decoder.createRawTransactionPieces(wrapper, rawTx, 1)
+		return err
 	}
 
 	//取账户最后一个地址
@@ -292,6 +347,7 @@ func (decoder *TransactionDecoder) CreateBTCRawTransaction(wrapper openwallet.Wa
 	//changeAmount := balance.Sub(totalSend).Sub(actualFees)
 	if changeAmount.GreaterThan(decimal.New(0, 0)) {
 		outputAddrs = appendOutput(outputAddrs, changeAddress, changeAmount)
+		rawTx.SetExtParam("changeAddress", changeAddress)
 		//outputAddrs[changeAddress] = changeAmount.StringFixed(decoder.wm.Decimal())
 	}
 
@@ -303,6 +359,319 @@ func (decoder *TransactionDecoder) CreateBTCRawTransaction(wrapper openwallet.Wa
 	return nil
 }
 
+//ErrConsolidationRequired consolidate模式返回合并交易单时的错误，合并交易确认后需要重新创建付款交易单
+var ErrConsolidationRequired = errors.New("payment requires consolidation, resubmit it after the consolidation transactions are confirmed")
+
+//CreateRawTransactionPieces 创建交易单，所需utxo超过单笔交易单的输入限制时，按扩展参数splitMode处理：
+//split（默认）：把付款拆分成多笔交易单，每笔交易单独立计算手续费
+//consolidate：返回把utxo合并到找零地址的交易单和ErrConsolidationRequired，合并交易确认后再重新创建付款交易单
+func (decoder *TransactionDecoder) CreateRawTransactionPieces(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) ([]*openwallet.RawTransaction, error) {
+	return decoder.createRawTransactionPieces(wrapper, rawTx, 0)
+}
+
+//createRawTransactionPieces 创建分拆的交易单，maxPieces大于0时，需要的交易单数量超过maxPieces则不创建
+func (decoder *TransactionDecoder) createRawTransactionPieces(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, maxPieces int) ([]*openwallet.RawTransaction, error) {
+
+	var (
+		totalSend    = decimal.Zero
+		balance      = decimal.Zero
+		totalFees    = decimal.Zero
+		feesRate     = decimal.Zero
+		usedUTXO     = make([]*Unspent, 0)
+		destinations = make([]string, 0)
+		pieces       = make([]*openwallet.RawTransaction, 0)
+		accountID    = rawTx.Account.AccountID
+	)
+
+	if len(rawTx.To) == 0 {
+		return nil, errors.New("Receiver addresses is empty!")
+	}
+
+	address, err := wrapper.GetAddressList(0, -1, "AccountID", accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(address) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrAccountNotAddress, "[%s] have not addresses", accountID)
+	}
+
+	searchAddrs := make([]string, 0)
+	for _, address := range address {
+		searchAddrs = append(searchAddrs, address.Address)
+	}
+
+	unspents, err := decoder.wm.ListUnspent(0, searchAddrs...)
+	if err != nil {
+		return nil, err
+	}
+	if err := decoder.wm.PruneUTXOReservations(accountID, unspents); err != nil {
+		decoder.wm.Log.Errorf("prune utxo reservations failed, err: %v", err)
+	}
+	unspents = filterSpendableUnspents(unspents)
+	unspents = decoder.wm.filterReservedUnspents(unspents)
+
+	//优先使用大额utxo，减少分拆数量
+	sort.Sort(UnspentSort{unspents, func(a, b *Unspent) int {
+		a_amount, _ := decimal.NewFromString(a.Amount)
+		b_amount, _ := decimal.NewFromString(b.Amount)
+		if a_amount.LessThan(b_amount) {
+			return 1
+		} else {
+			return -1
+		}
+	}})
+
+	for addr, amount := range rawTx.To {
+		deamount, _ := decimal.NewFromString(amount)
+		totalSend = totalSend.Add(deamount)
+		destinations = append(destinations, addr)
+	}
+	sort.Strings(destinations)
+
+	if len(rawTx.FeeRate) == 0 {
+		feesRate, err = decoder.wm.EstimateFeeRate()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		feesRate, _ = decimal.NewFromString(rawTx.FeeRate)
+	}
+
+	memoData, err := getRawTransactionMemoData(rawTx)
+	if err != nil {
+		return nil, err
+	}
+	memoBytes := int64(0)
+	if len(memoData) > 0 {
+		memoBytes = int64(vasTransaction.NullDataOutputSize(len(memoData)))
+	}
+
+	mode := rawTx.GetExtParam().Get("splitMode").String()
+	maxInputs := decoder.wm.MaxTxInputsWithExtraBytes(int64(len(destinations)+1), memoBytes)
+
+	//按分拆后每笔交易单的手续费，选择足够支付的utxo
+	for _, u := range unspents {
+		ua, _ := decimal.NewFromString(u.Amount)
+		balance = balance.Add(ua)
+		usedUTXO = append(usedUTXO, u)
+
+		totalFees, err = decoder.estimatePiecesFees(len(usedUTXO), maxInputs, int64(len(destinations)+1), memoBytes, feesRate)
+		if err != nil {
+			return nil, err
+		}
+		if balance.GreaterThanOrEqual(totalSend.Add(totalFees)) {
+			break
+		}
+	}
+
+	if balance.LessThan(totalSend.Add(totalFees)) {
+		return nil, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "The balance: %s is not enough! ", balance.StringFixed(decoder.wm.Decimal()))
+	}
+
+	changeAddress := usedUTXO[0].Address
+	chunks := splitUnspents(usedUTXO, maxInputs)
+
+	//无需分拆，使用已选择的utxo直接构建交易单
+	if len(chunks) == 1 {
+		fees, err := decoder.wm.EstimateFeeWithExtraBytes(int64(len(usedUTXO)), int64(len(destinations)+1), memoBytes, feesRate)
+		if err != nil {
+			return nil, err
+		}
+		outputAddrs := make(map[string]decimal.Decimal)
+		for _, addr := range destinations {
+			amount, _ := decimal.NewFromString(rawTx.To[addr])
+			outputAddrs = appendOutput(outputAddrs, addr, amount)
+		}
+		changeAmount := balance.Sub(totalSend).Sub(fees)
+		if changeAmount.GreaterThan(decimal.Zero) {
+			outputAddrs = appendOutput(outputAddrs, changeAddress, changeAmount)
+			rawTx.SetExtParam("changeAddress", changeAddress)
+		}
+		rawTx.FeeRate = feesRate.StringFixed(decoder.wm.Decimal())
+		rawTx.Fees = fees.StringFixed(decoder.wm.Decimal())
+		err = decoder.createVASRawTransaction(wrapper, rawTx, usedUTXO, outputAddrs)
+		if err != nil {
+			return nil, err
+		}
+		return []*openwallet.RawTransaction{rawTx}, nil
+	}
+
+	if maxPieces > 0 && len(chunks) > maxPieces {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "The transaction is use max inputs over: %d, please split it into %d pieces by CreateRawTransactionPieces", maxInputs, len(chunks))
+	}
+
+	if mode == "consolidate" {
+		//合并utxo到找零地址
+		for _, chunk := range chunks {
+			chunkBalance := sumUnspents(chunk)
+			fees, err := decoder.wm.EstimateFee(int64(len(chunk)), 1, feesRate)
+			if err != nil {
+				decoder.cancelRawTransactions(pieces)
+				return nil, err
+			}
+			//扣除手续费后低于粉尘限额的utxo不合并
+			amount := chunkBalance.Sub(fees)
+			if !amount.GreaterThan(decimal.Zero) || amount.LessThan(decoder.wm.Config.DustLimit) {
+				continue
+			}
+			piece := decoder.newRawTransactionPiece(rawTx, map[string]decimal.Decimal{changeAddress: amount}, fees, feesRate, len(pieces), len(chunks))
+			piece.ExtParam = ""
+			piece.SetExtParam("consolidation", true)
+			piece.SetExtParam("pieceIndex", len(pieces))
+			err = decoder.createVASRawTransaction(wrapper, piece, chunk, map[string]decimal.Decimal{changeAddress: amount})
+			if err != nil {
+				decoder.cancelRawTransactions(pieces)
+				return nil, err
+			}
+			pieces = append(pieces, piece)
+		}
+		setRawTransactionPiecesCount(pieces)
+		return pieces, ErrConsolidationRequired
+	}
+
+	//分拆付款，按目标地址顺序依次填充每笔交易单
+	remaining := make(map[string]decimal.Decimal)
+	for _, addr := range destinations {
+		remaining[addr], _ = decimal.NewFromString(rawTx.To[addr])
+	}
+
+	for _, chunk := range chunks {
+		chunkBalance := sumUnspents(chunk)
+		outputs := int64(1)
+		for _, addr := range destinations {
+			if remaining[addr].GreaterThan(decimal.Zero) {
+				outputs++
+			}
+		}
+		fees, err := decoder.wm.EstimateFeeWithExtraBytes(int64(len(chunk)), outputs, memoBytes, feesRate)
+		if err != nil {
+			decoder.cancelRawTransactions(pieces)
+			return nil, err
+		}
+
+		available := chunkBalance.Sub(fees)
+		outputAddrs := make(map[string]decimal.Decimal)
+		for _, addr := range destinations {
+			if !available.GreaterThan(decimal.Zero) {
+				break
+			}
+			need := remaining[addr]
+			if !need.GreaterThan(decimal.Zero) {
+				continue
+			}
+			pay := decimal.Min(need, available)
+			outputAddrs = appendOutput(outputAddrs, addr, pay)
+			remaining[addr] = need.Sub(pay)
+			available = available.Sub(pay)
+		}
+
+		if len(outputAddrs) == 0 {
+			break
+		}
+
+		to := make(map[string]decimal.Decimal)
+		for a, m := range outputAddrs {
+			to[a] = m
+		}
+		if available.GreaterThan(decimal.Zero) {
+			outputAddrs = appendOutput(outputAddrs, changeAddress, available)
+		}
+
+		piece := decoder.newRawTransactionPiece(rawTx, to, fees, feesRate, len(pieces), len(chunks))
+		if available.GreaterThan(decimal.Zero) {
+			piece.SetExtParam("changeAddress", changeAddress)
+		}
+		err = decoder.createVASRawTransaction(wrapper, piece, chunk, outputAddrs)
+		if err != nil {
+			decoder.cancelRawTransactions(pieces)
+			return nil, err
+		}
+		pieces = append(pieces, piece)
+	}
+
+	for addr, m := range remaining {
+		if m.GreaterThan(decimal.Zero) {
+			decoder.cancelRawTransactions(pieces)
+			return nil, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "address: %s still remains %s unpaid", addr, m.StringFixed(decoder.wm.Decimal()))
+		}
+	}
+
+	setRawTransactionPiecesCount(pieces)
+
+	return pieces, nil
+}
+
+//setRawTransactionPiecesCount 按实际创建的交易单数量更新pieceCount
+func setRawTransactionPiecesCount(pieces []*openwallet.RawTransaction) {
+	for _, piece := range pieces {
+		piece.SetExtParam("pieceCount", len(pieces))
+	}
+}
+
+//newRawTransactionPiece 创建分拆的交易单
+func (decoder *TransactionDecoder) newRawTransactionPiece(rawTx *openwallet.RawTransaction, to map[string]decimal.Decimal, fees, feesRate decimal.Decimal, index, count int) *openwallet.RawTransaction {
+	rawTxTo := make(map[string]string)
+	for a, m := range to {
+		rawTxTo[a] = m.StringFixed(decoder.wm.Decimal())
+	}
+	piece := &openwallet.RawTransaction{
+		Coin:     rawTx.Coin,
+		Sid:      rawTx.Sid,
+		Account:  rawTx.Account,
+		FeeRate:  feesRate.StringFixed(decoder.wm.Decimal()),
+		To:       rawTxTo,
+		Fees:     fees.StringFixed(decoder.wm.Decimal()),
+		Required: 1,
+		ExtParam: rawTx.ExtParam,
+	}
+	piece.SetExtParam("pieceIndex", index)
+	piece.SetExtParam("pieceCount", count)
+	return piece
+}
+
+//estimatePiecesFees 预估分拆成多笔交易单的总手续费
+func (decoder *TransactionDecoder) estimatePiecesFees(inputs, maxInputs int, outputs, extraBytes int64, feesRate decimal.Decimal) (decimal.Decimal, error) {
+	totalFees := decimal.Zero
+	for inputs > 0 {
+		n := inputs
+		if n > maxInputs {
+			n = maxInputs
+		}
+		fees, err := decoder.wm.EstimateFeeWithExtraBytes(int64(n), outputs, extraBytes, feesRate)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		totalFees = totalFees.Add(fees)
+		inputs -= n
+	}
+	return totalFees, nil
+}
+
+//splitUnspents 按最大输入数分组utxo
+func splitUnspents(unspents []*Unspent, maxInputs int) [][]*Unspent {
+	chunks := make([][]*Unspent, 0)
+	for len(unspents) > 0 {
+		n := len(unspents)
+		if n > maxInputs {
+			n = maxInputs
+		}
+		chunks = append(chunks, unspents[:n])
+		unspents = unspents[n:]
+	}
+	return chunks
+}
+
+//sumUnspents 合计utxo数量
+func sumUnspents(unspents []*Unspent) decimal.Decimal {
+	total := decimal.Zero
+	for _, u := range unspents {
+		ua, _ := decimal.NewFromString(u.Amount)
+		total = total.Add(ua)
+	}
+	return total
+}
+
 //SignRawTransaction 签名交易单
 func (decoder *TransactionDecoder) SignVASRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
 
@@ -311,58 +680,63 @@ func (decoder *TransactionDecoder) SignVASRawTransaction(wrapper openwallet.Wall
 		return fmt.Errorf("transaction signature is empty")
 	}
 
-	key, err := wrapper.HDKey()
+	//重新计算签名哈希，与待签名消息不一致时拒绝签名
+	preview, err := decoder.CheckRawTransactionSigHashes(rawTx)
 	if err != nil {
 		return err
 	}
 
-	keySignatures := rawTx.Signatures[rawTx.Account.AccountID]
-	if keySignatures != nil {
-		for _, keySignature := range keySignatures {
+	for i, output := range preview.Outputs {
+		decoder.wm.Log.Infof("sign transaction output[%d]: %s:%s", i, output.Address, output.Amount.StringFixed(decoder.wm.Decimal()))
+	}
+	decoder.wm.Log.Infof("sign transaction fees: %s", preview.Fees.StringFixed(decoder.wm.Decimal()))
 
-			childKey, err := key.DerivedKeyWithPath(keySignature.Address.HDPath, keySignature.EccType)
-			keyBytes, err := childKey.GetPrivateKeyBytes()
-			if err != nil {
-				return err
-			}
-			decoder.wm.Log.Debug("privateKey:", hex.EncodeToString(keyBytes))
+	//签名策略检查，检查、签名和记录转出金额期间持有锁，避免并发签名同时通过每日限额
+	signPolicyLock.Lock()
+	defer signPolicyLock.Unlock()
 
-			//privateKeys = append(privateKeys, keyBytes)
-			txHash := vasTransaction.TxHash{
-				Hash: keySignature.Message,
-				Normal: &vasTransaction.NormalTx{
-					Address: keySignature.Address.Address,
-					SigType: vasTransaction.SigHashAll,
-				},
-			}
-			//transHash = append(transHash, txHash)
+	policyAmount, err := decoder.checkSignPolicy(wrapper, rawTx, preview)
+	if err != nil {
+		return err
+	}
 
-			decoder.wm.Log.Debug("hash:", txHash.GetTxHashHex())
+	//输入可能来自同一钱包的多个账户，例如手续费支持账户
+	signAccounts := make(map[*openwallet.KeySignature]string)
+	for accountID, keySignatures := range rawTx.Signatures {
+		for _, keySignature := range keySignatures {
+			signAccounts[keySignature] = accountID
+		}
+	}
 
-			//签名交易
-			/////////交易单哈希签名
-			sigPub, err := vasTransaction.SignRawTransactionHash(txHash.GetTxHashHex(), keyBytes)
-			if err != nil {
-				return fmt.Errorf("transaction hash sign failed, unexpected error: %v", err)
-			} else {
+	keySignatures, err := orderedKeySignatures(rawTx)
+	if err != nil {
+		return err
+	}
 
-				//for i, s := range sigPub {
-				//	decoder.wm.Log.Info("第", i+1, "个签名结果")
-				//	decoder.wm.Log.Info()
-				//	decoder.wm.Log.Info("对应的公钥为")
-				//	decoder.wm.Log.Info(hex.EncodeToString(s.Pubkey))
-				//}
+	requests := make([]*SignRequest, 0, len(keySignatures))
+	for i, keySignature := range keySignatures {
+		requests = append(requests, newSignRequest(signAccounts[keySignature], i, keySignature))
+	}
 
-				//txHash.Normal.SigPub = *sigPub
-			}
+	//签名交易
+	/////////交易单哈希签名
+	signatures, err := decoder.wm.signRequests(wrapper, decoder.wm.newSignContext(rawTx, preview), requests)
+	if err != nil {
+		return err
+	}
 
-			keySignature.Signature = hex.EncodeToString(sigPub.Signature)
-		}
+	for i, keySignature := range keySignatures {
+		keySignature.Signature = hex.EncodeToString(signatures[i])
+		decoder.wm.auditSignature(rawTx, requests[i].AccountID, i, keySignature)
 	}
 
-	decoder.wm.Log.Info("transaction hash sign success")
+	//记录转出金额，用于每日累计限额
+	err = decoder.wm.SaveSignPolicyUsage(rawTx, policyAmount)
+	if err != nil {
+		decoder.wm.Log.Errorf("save sign policy usage failed, err: %v", err)
+	}
 
-	rawTx.Signatures[rawTx.Account.AccountID] = keySignatures
+	decoder.wm.Log.Info("transaction hash sign success")
 
 	//decoder.wm.Log.Info("rawTx.Signatures 1:", rawTx.Signatures)
 
@@ -393,34 +767,36 @@ func (decoder *TransactionDecoder) VerifyVASRawTransaction(wrapper openwallet.Wa
 
 	//TODO:待支持多重签名
 
-	for accountID, keySignatures := range rawTx.Signatures {
-		decoder.wm.Log.Debug("accountID Signatures:", accountID)
-		for _, keySignature := range keySignatures {
-
-			signature, _ := hex.DecodeString(keySignature.Signature)
-			pubkey, _ := hex.DecodeString(keySignature.Address.PublicKey)
+	keySignatures, err := orderedKeySignatures(rawTx)
+	if err != nil {
+		return err
+	}
 
-			signaturePubkey := vasTransaction.SignaturePubkey{
-				Signature: signature,
-				Pubkey:    pubkey,
-			}
+	for _, keySignature := range keySignatures {
 
-			//sigPub = append(sigPub, signaturePubkey)
+		signature, _ := hex.DecodeString(keySignature.Signature)
+		pubkey, _ := hex.DecodeString(keySignature.Address.PublicKey)
 
-			txHash := vasTransaction.TxHash{
-				Hash: keySignature.Message,
-				Normal: &vasTransaction.NormalTx{
-					Address: keySignature.Address.Address,
-					SigType: vasTransaction.SigHashAll,
-					SigPub:  signaturePubkey,
-				},
-			}
+		signaturePubkey := vasTransaction.SignaturePubkey{
+			Signature: signature,
+			Pubkey:    pubkey,
+		}
 
-			transHash = append(transHash, txHash)
+		//sigPub = append(sigPub, signaturePubkey)
 
-			decoder.wm.Log.Debug("Signature:", keySignature.Signature)
-			decoder.wm.Log.Debug("PublicKey:", keySignature.Address.PublicKey)
+		txHash := vasTransaction.TxHash{
+			Hash: keySignature.Message,
+			Normal: &vasTransaction.NormalTx{
+				Address: keySignature.Address.Address,
+				SigType: vasTransaction.SigHashAll,
+				SigPub:  signaturePubkey,
+			},
 		}
+
+		transHash = append(transHash, txHash)
+
+		decoder.wm.Log.Debug("Signature:", keySignature.Signature)
+		decoder.wm.Log.Debug("PublicKey:", keySignature.Address.PublicKey)
 	}
 
 	txBytes, err := hex.DecodeString(emptyTrans)
@@ -468,16 +844,21 @@ func (decoder *TransactionDecoder) VerifyVASRawTransaction(wrapper openwallet.Wa
 
 	/////////验证交易单
 	//验证时，对于公钥哈希地址，需要将对应的锁定脚本传入TxUnlock结构体
-	pass := vasTransaction.VerifyRawTransaction(signedTrans, txUnlocks, decoder.wm.Config.SupportSegWit, addressPrefix)
-	if pass {
-		decoder.wm.Log.Debug("transaction verify passed")
-		rawTx.IsCompleted = true
-		rawTx.RawHex = signedTrans
-	} else {
+	//签名须为严格DER编码且为低S值，否则会被节点拒绝
+	err = vasTransaction.VerifyRawTransactionWithError(signedTrans, txUnlocks, decoder.wm.Config.SupportSegWit, addressPrefix)
+	if err != nil {
 		decoder.wm.Log.Debug("transaction verify failed")
 		rawTx.IsCompleted = false
+		if verifyErr, ok := err.(*vasTransaction.VerifyError); ok && verifyErr.Index >= 0 && verifyErr.Index < len(keySignatures) {
+			return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "input %d [%s] verify failed: %s", verifyErr.Index, keySignatures[verifyErr.Index].Address.Address, verifyErr.Reason)
+		}
+		return openwallet.Errorf(openwallet.ErrVerifyRawTransactionFailed, "transaction verify failed: %v", err)
 	}
 
+	decoder.wm.Log.Debug("transaction verify passed")
+	rawTx.IsCompleted = true
+	rawTx.RawHex = signedTrans
+
 	return nil
 }
 
@@ -499,69 +880,64 @@ func (decoder *TransactionDecoder) SignOmniRawTransaction(wrapper openwallet.Wal
 		return fmt.Errorf("transaction signature is empty")
 	}
 
-	key, err := wrapper.HDKey()
+	//重新计算签名哈希，与待签名消息不一致时拒绝签名
+	preview, err := decoder.CheckRawTransactionSigHashes(rawTx)
+	if err != nil {
+		return err
+	}
+
+	for i, output := range preview.Outputs {
+		decoder.wm.Log.Infof("sign transaction output[%d]: %s:%s", i, output.Address, output.Amount.StringFixed(decoder.wm.Decimal()))
+	}
+	decoder.wm.Log.Infof("sign transaction fees: %s", preview.Fees.StringFixed(decoder.wm.Decimal()))
+
+	//签名策略检查，检查、签名和记录转出金额期间持有锁，避免并发签名同时通过每日限额
+	signPolicyLock.Lock()
+	defer signPolicyLock.Unlock()
+
+	policyAmount, err := decoder.checkSignPolicy(wrapper, rawTx, preview)
 	if err != nil {
 		return err
 	}
+	decoder.wm.Log.Infof("sign omni transaction: property: %d, amount: %d, %s -> %s", preview.Omni.PropertyID, preview.Omni.Amount, preview.OmniSender, preview.OmniReceiver)
 
 	//keySignatures := rawTx.Signatures[rawTx.Account.AccountID]
 	for accountID, keySignatures := range rawTx.Signatures {
 
 		decoder.wm.Log.Debug("accountID:", accountID)
 
-		if keySignatures != nil {
-			for _, keySignature := range keySignatures {
+		requests := make([]*SignRequest, 0, len(keySignatures))
+		for i, keySignature := range keySignatures {
+			requests = append(requests, newSignRequest(accountID, i, keySignature))
+		}
 
-				childKey, err := key.DerivedKeyWithPath(keySignature.Address.HDPath, keySignature.EccType)
-				keyBytes, err := childKey.GetPrivateKeyBytes()
-				if err != nil {
-					return err
-				}
+		//签名交易
+		/////////交易单哈希签名
+		signatures, err := decoder.wm.signRequests(wrapper, decoder.wm.newSignContext(rawTx, preview), requests)
+		if err != nil {
+			return err
+		}
 
-				decoder.wm.Log.Debug("privateKey:", hex.EncodeToString(keyBytes))
+		for i, keySignature := range keySignatures {
+			keySignature.Signature = hex.EncodeToString(signatures[i])
+			decoder.wm.auditSignature(rawTx, accountID, i, keySignature)
+		}
 
-				//privateKeys = append(privateKeys, keyBytes)
-				txHash := omniTransaction.TxHash{
-					Hash: keySignature.Message,
-					Normal: &omniTransaction.NormalTx{
-						Address: keySignature.Address.Address,
-						SigType: vasTransaction.SigHashAll,
-					},
-				}
-				//transHash = append(transHash, txHash)
+		rawTx.Signatures[accountID] = keySignatures
+	}
 
-				decoder.wm.Log.Debug("hash:", txHash.GetTxHashHex())
+	//记录转出金额，用于每日累计限额
+	err = decoder.wm.SaveSignPolicyUsage(rawTx, policyAmount)
+	if err != nil {
+		decoder.wm.Log.Errorf("save sign policy usage failed, err: %v", err)
+	}
 
-				//签名交易
-				/////////交易单哈希签名
-				sigPub, err := omniTransaction.SignRawTransactionHash(txHash.GetTxHashHex(), keyBytes)
-				if err != nil {
-					return fmt.Errorf("transaction hash sign failed, unexpected error: %v", err)
-				} else {
+	decoder.wm.Log.Info("transaction hash sign success")
 
-					//for i, s := range sigPub {
-					//	decoder.wm.Log.Info("第", i+1, "个签名结果")
-					//	decoder.wm.Log.Info()
-					//	decoder.wm.Log.Info("对应的公钥为")
-					//	decoder.wm.Log.Info(hex.EncodeToString(s.Pubkey))
-					//}
+	//decoder.wm.Log.Info("rawTx.Signatures 1:", rawTx.Signatures)
 
-					//txHash.Normal.SigPub = *sigPub
-				}
-
-				keySignature.Signature = hex.EncodeToString(sigPub.Signature)
-			}
-		}
-
-		rawTx.Signatures[accountID] = keySignatures
-	}
-
-	decoder.wm.Log.Info("transaction hash sign success")
-
-	//decoder.wm.Log.Info("rawTx.Signatures 1:", rawTx.Signatures)
-
-	return nil
-}
+	return nil
+}
 
 //VerifyOmniRawTransaction 验证交易单，验证交易单并返回加入签名后的交易单
 func (decoder *TransactionDecoder) VerifyOmniRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
@@ -691,20 +1067,22 @@ func (decoder *TransactionDecoder) VerifyOmniRawTransaction(wrapper openwallet.W
 func (decoder *TransactionDecoder) CreateVASSummaryRawTransaction(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
 
 	var (
-		feesRate       = decimal.New(0, 0)
-		accountID      = sumRawTx.Account.AccountID
-		minTransfer, _ = decimal.NewFromString(sumRawTx.MinTransfer)
-		//retainedBalance, _ = decimal.NewFromString(sumRawTx.RetainedBalance)
-		sumAddresses     = make([]string, 0)
-		rawTxArray       = make([]*openwallet.RawTransactionWithError, 0)
-		sumUnspents      []*Unspent
-		outputAddrs      map[string]decimal.Decimal
-		totalInputAmount decimal.Decimal
+		feesRate           = decimal.New(0, 0)
+		accountID          = sumRawTx.Account.AccountID
+		minTransfer, _     = decimal.NewFromString(sumRawTx.MinTransfer)
+		retainedBalance, _ = decimal.NewFromString(sumRawTx.RetainedBalance)
+		sumAddresses       = make([]string, 0)
+		rawTxArray         = make([]*openwallet.RawTransactionWithError, 0)
+		sumUnspents        []*Unspent
+		outputAddrs        map[string]decimal.Decimal
+		totalInputAmount   decimal.Decimal
+		feesSupportID      string
+		feesSupportPool    []*Unspent
 	)
 
-	//if minTransfer.LessThan(retainedBalance) {
-	//	return nil, fmt.Errorf("mini transfer amount must be greater than address retained balance")
-	//}
+	if minTransfer.LessThan(retainedBalance) {
+		return nil, fmt.Errorf("mini transfer amount must be greater than address retained balance")
+	}
 
 	address, err := wrapper.GetAddressList(sumRawTx.AddressStartIndex, sumRawTx.AddressLimit, "AccountID", sumRawTx.Account.AccountID)
 	if err != nil {
@@ -749,12 +1127,36 @@ func (decoder *TransactionDecoder) CreateVASSummaryRawTransaction(wrapper openwa
 		feesRate, _ = decimal.NewFromString(sumRawTx.FeeRate)
 	}
 
+	//手续费支持账户的utxo用于支付汇总手续费，汇总数量不扣除手续费
+	if sumRawTx.FeesSupportAccount != nil && len(sumRawTx.FeesSupportAccount.AccountID) > 0 {
+		feesSupportID = sumRawTx.FeesSupportAccount.AccountID
+		feesSupportPool, err = decoder.getFeesSupportUnspents(wrapper, feesSupportID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	sumUnspents = make([]*Unspent, 0)
 	outputAddrs = make(map[string]decimal.Decimal, 0)
 	totalInputAmount = decimal.Zero
 
+	maxInputs := decoder.wm.MaxTxInputsForOutputs(1)
+
 	for i, addr := range sumAddresses {
 
+		//汇总交易有一个汇总输出，保留余额时每个地址增加一个找回输出，手续费支持时增加一个输入和找零输出
+		outputCount := int64(1)
+		if retainedBalance.GreaterThan(decimal.Zero) {
+			outputCount += int64(len(outputAddrs)) + 1
+		}
+		if len(feesSupportID) > 0 {
+			outputCount++
+		}
+		maxInputs = decoder.wm.MaxTxInputsForOutputs(outputCount)
+		if len(feesSupportID) > 0 && maxInputs > 1 {
+			maxInputs--
+		}
+
 		unspents, err := decoder.wm.ListUnspent(sumRawTx.Confirms, addr)
 		if err != nil {
 			return nil, err
@@ -763,25 +1165,40 @@ func (decoder *TransactionDecoder) CreateVASSummaryRawTransaction(wrapper openwa
 		//保留1个omni的最低转账成本的utxo 用于汇总omni
 		unspents = decoder.keepOmniCostUTXONotToUse(unspents)
 
-		//尽可能筹够最大input数
-		if len(unspents)+len(sumUnspents) < decoder.wm.Config.MaxTxInputs {
-			sumUnspents = append(sumUnspents, unspents...)
-			//if retainedBalance.GreaterThan(decimal.Zero) {
-			//	outputAddrs = appendOutput(outputAddrs, addr, retainedBalance)
-			//outputAddrs[addr] = retainedBalance.StringFixed(decoder.wm.Decimal())
-			//}
+		//排除不可花费的utxo，例如未成熟的挖矿收入
+		unspents = filterSpendableUnspents(unspents)
+
+		//排除已被其他交易单锁定的utxo
+		unspents = decoder.wm.filterReservedUnspents(unspents)
+
+		//尽可能筹够最大input数，超出部分留到下次汇总
+		if space := maxInputs - len(sumUnspents); space <= 0 {
+			unspents = nil
+		} else if len(unspents) > space {
+			unspents = unspents[:space]
+		}
+		if len(unspents) > 0 {
+			if retainedBalance.GreaterThan(decimal.Zero) {
+				//地址保留余额，余额不超过保留余额时不汇总该地址
+				addrAmount := decimal.Zero
+				for _, u := range unspents {
+					ua, _ := decimal.NewFromString(u.Amount)
+					addrAmount = addrAmount.Add(ua)
+				}
+				if addrAmount.GreaterThan(retainedBalance) {
+					sumUnspents = append(sumUnspents, unspents...)
+					outputAddrs = appendOutput(outputAddrs, addr, retainedBalance)
+				}
+			} else {
+				sumUnspents = append(sumUnspents, unspents...)
+			}
 			//decoder.wm.Log.Debugf("sumUnspents: %+v", sumUnspents)
 		}
 
 		//如果utxo已经超过最大输入，或遍历地址完结，就可以进行构建交易单
-		if i == len(sumAddresses)-1 || len(sumUnspents) >= decoder.wm.Config.MaxTxInputs {
+		if (i == len(sumAddresses)-1 || len(sumUnspents) >= maxInputs) && len(sumUnspents) > 0 {
 			//执行构建交易单工作
 			//decoder.wm.Log.Debugf("sumUnspents: %+v", sumUnspents)
-			//计算手续费，构建交易单inputs，地址保留余额>0，地址需要加入输出，最后+1是汇总地址
-			fees, createErr := decoder.wm.EstimateFee(int64(len(sumUnspents)), int64(len(outputAddrs)+1), feesRate)
-			if createErr != nil {
-				return nil, createErr
-			}
 
 			//计算这笔交易单的汇总数量
 			for _, u := range sumUnspents {
@@ -792,24 +1209,52 @@ func (decoder *TransactionDecoder) CreateVASSummaryRawTransaction(wrapper openwa
 				}
 			}
 
+			var (
+				fees          decimal.Decimal
+				createErr     error
+				feesUnspents  []*Unspent
+				feesChange    = decimal.Zero
+				feesChangeTo  string
+				retainedTotal = retainedBalance.Mul(decimal.New(int64(len(outputAddrs)), 0))
+			)
+
+			//手续费支持账户支付时，确认有汇总数量后再选择手续费utxo，避免跳过的交易单占用手续费utxo
+			if len(feesSupportID) == 0 {
+				//计算手续费，构建交易单inputs，地址保留余额>0，地址需要加入输出，最后+1是汇总地址
+				fees, createErr = decoder.wm.EstimateFee(int64(len(sumUnspents)), int64(len(outputAddrs)+1), feesRate)
+				if createErr != nil {
+					return nil, createErr
+				}
+			}
+
 			/*
 
 					汇总数量计算：
 
 					1. 输入总数量 = 合计账户地址的所有utxo
 					2. 账户地址输出总数量 = 账户地址保留余额 * 地址数
-				    3. 汇总数量 = 输入总数量 - 账户地址输出总数量 - 手续费
+				    3. 汇总数量 = 输入总数量 - 账户地址输出总数量 - 手续费（手续费支持账户支付时不扣除）
 			*/
-			//retainedBalanceTotal := retainedBalance.Mul(decimal.New(int64(len(outputAddrs)), 0))
-			sumAmount := totalInputAmount.Sub(fees)
+			sumAmount := totalInputAmount.Sub(retainedTotal)
+			if len(feesSupportID) == 0 {
+				sumAmount = sumAmount.Sub(fees)
+			}
 
 			decoder.wm.Log.Debugf("totalInputAmount: %v", totalInputAmount)
-			//decoder.wm.Log.Debugf("retainedBalanceTotal: %v", retainedBalanceTotal)
+			decoder.wm.Log.Debugf("retainedBalanceTotal: %v", retainedTotal)
 			decoder.wm.Log.Debugf("fees: %v", fees)
 			decoder.wm.Log.Debugf("sumAmount: %v", sumAmount)
 
 			if sumAmount.GreaterThan(decimal.Zero) {
 
+				if len(feesSupportID) > 0 {
+					//手续费支持账户支付手续费，找零返回手续费支持账户
+					feesUnspents, feesSupportPool, fees, feesChange, createErr = decoder.selectFeesSupportUnspents(feesSupportPool, int64(len(sumUnspents)), int64(len(outputAddrs)+2), feesRate)
+					if len(feesUnspents) > 0 {
+						feesChangeTo = feesUnspents[0].Address
+					}
+				}
+
 				//最后填充汇总地址及汇总数量
 				outputAddrs = appendOutput(outputAddrs, sumRawTx.SummaryAddress, sumAmount)
 				//outputAddrs[sumRawTx.SummaryAddress] = sumAmount.StringFixed(decoder.wm.Decimal())
@@ -829,7 +1274,20 @@ func (decoder *TransactionDecoder) CreateVASSummaryRawTransaction(wrapper openwa
 					Required: 1,
 				}
 
-				createErr = decoder.createVASRawTransaction(wrapper, rawTx, sumUnspents, outputAddrs)
+				if createErr == nil && len(feesSupportID) > 0 {
+					rawTx.SetExtParam("feesSupportAccountID", feesSupportID)
+					if feesChange.GreaterThan(decimal.Zero) {
+						outputAddrs = appendOutput(outputAddrs, feesChangeTo, feesChange)
+					}
+					createErr = decoder.createVASRawTransaction(wrapper, rawTx, append(sumUnspents, feesUnspents...), outputAddrs)
+					//构建失败的交易单不占用手续费utxo，放回手续费支持账户的utxo池
+					if createErr != nil {
+						feesSupportPool = append(feesUnspents, feesSupportPool...)
+					}
+				} else if createErr == nil {
+					createErr = decoder.createVASRawTransaction(wrapper, rawTx, sumUnspents, outputAddrs)
+				}
+
 				rawTxWithErr := &openwallet.RawTransactionWithError{
 					RawTx: rawTx,
 					Error: openwallet.ConvertError(createErr),
@@ -871,6 +1329,8 @@ func (decoder *TransactionDecoder) createVASRawTransaction(
 		txTo             = make([]string, 0)
 		accountID        = rawTx.Account.AccountID
 		addressPrefix    vasTransaction.AddressPrefix
+		inputAccounts    = make([]string, 0)
+		feesSupportID    = rawTx.GetExtParam().Get("feesSupportAccountID").String()
 	)
 
 	if len(usedUTXO) == 0 {
@@ -886,6 +1346,13 @@ func (decoder *TransactionDecoder) createVASRawTransaction(
 		//deamount, _ := decimal.NewFromString(amount)
 		totalSend = totalSend.Add(amount)
 		destinations = append(destinations, addr)
+		//手续费支持账户的找零不计入账户的转账amount
+		if len(feesSupportID) > 0 {
+			feesAddrs, findErr := wrapper.GetAddressList(0, -1, "AccountID", feesSupportID, "Address", addr)
+			if findErr == nil && len(feesAddrs) > 0 {
+				continue
+			}
+		}
 		//计算账户的实际转账amount
 		addresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", accountID, "Address", addr)
 		if findErr != nil || len(addresses) == 0 {
@@ -894,51 +1361,82 @@ func (decoder *TransactionDecoder) createVASRawTransaction(
 		}
 	}
 
-	//UTXO如果大于设定限制，则分拆成多笔交易单发送
-	if len(usedUTXO) > decoder.wm.Config.MaxTxInputs {
-		errStr := fmt.Sprintf("The transaction is use max inputs over: %d", decoder.wm.Config.MaxTxInputs)
-		return errors.New(errStr)
+	//UTXO如果大于设定限制，需要分拆成多笔交易单发送
+	if maxInputs := decoder.wm.MaxTxInputsForOutputs(int64(len(to))); len(usedUTXO) > maxInputs {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "The transaction is use max inputs over: %d", maxInputs)
+	}
+
+	if decoder.wm.Config.IsTestNet {
+		addressPrefix = TestNetAddressPrefix
+	} else {
+		addressPrefix = MainNetAddressPrefix
+	}
+
+	//OP_RETURN备注数据
+	memoData, err := getRawTransactionMemoData(rawTx)
+	if err != nil {
+		return err
+	}
+
+	//按排序策略排列输入输出，签名哈希与TxFrom/TxTo保持相同顺序
+	usedUTXO, outputs, err := decoder.orderTxInputsAndOutputs(rawTx, usedUTXO, to, memoData, addressPrefix)
+	if err != nil {
+		return err
 	}
 
 	//装配输入
 	for _, utxo := range usedUTXO {
-		in := vasTransaction.Vin{utxo.TxID, uint32(utxo.Vout)}
+		in := vasTransaction.Vin{TxID: utxo.TxID, Vout: uint32(utxo.Vout)}
 		vins = append(vins, in)
 
-		txUnlock := vasTransaction.TxUnlock{LockScript: utxo.ScriptPubKey, SigType: vasTransaction.SigHashAll}
+		utxoAmount, _ := decimal.NewFromString(utxo.Amount)
+		txUnlock := vasTransaction.TxUnlock{
+			LockScript:   utxo.ScriptPubKey,
+			RedeemScript: utxo.RedeemScript,
+			Amount:       uint64(utxoAmount.Shift(decoder.wm.Decimal()).IntPart()),
+			SigType:      vasTransaction.SigHashAll,
+		}
 		txUnlocks = append(txUnlocks, txUnlock)
 
 		txFrom = append(txFrom, fmt.Sprintf("%s:%s", utxo.Address, utxo.Amount))
 	}
 
-	//装配输入
-	for to, amount := range to {
-		txTo = append(txTo, fmt.Sprintf("%s:%s", to, amount.String()))
-		amount = amount.Shift(decoder.wm.Decimal())
-		out := vasTransaction.Vout{to, uint64(amount.IntPart())}
+	//装配输出，数据输出按排序后的位置插入
+	dataIndex := 0
+	for i, output := range outputs {
+		if len(output.data) > 0 {
+			dataIndex = i
+			continue
+		}
+		txTo = append(txTo, fmt.Sprintf("%s:%s", output.Address, output.Amount.String()))
+		amount := output.Amount.Shift(decoder.wm.Decimal())
+		out := vasTransaction.Vout{Address: output.Address, Amount: uint64(amount.IntPart())}
 		vouts = append(vouts, out)
 	}
 
-	//锁定时间
-	lockTime := uint32(0)
-
-	//追加手续费支持
-	replaceable := false
-
-	if decoder.wm.Config.IsTestNet {
-		addressPrefix = TestNetAddressPrefix
-	} else {
-		addressPrefix = MainNetAddressPrefix
+	//锁定时间，小于500000000为区块高度，否则为时间戳
+	lockTime, err := getRawTransactionLockTime(rawTx)
+	if err != nil {
+		return err
 	}
 
+	//追加手续费支持，需要在扩展参数中声明
+	replaceable := rawTx.GetExtParam().Get("replaceable").Bool()
+
 	/////////构建空交易单
-	emptyTrans, err := vasTransaction.CreateEmptyRawTransaction(vins, vouts, lockTime, replaceable, addressPrefix)
+	emptyTrans, err := vasTransaction.CreateEmptyRawTransactionWithDataAt(vins, vouts, memoData, dataIndex, lockTime, replaceable, addressPrefix)
 
 	if err != nil {
 		return fmt.Errorf("create transaction failed, unexpected error: %v", err)
 		//decoder.wm.Log.Error("构建空交易单失败")
 	}
 
+	//设置输入的相对时间锁
+	emptyTrans, err = setRawTransactionRelativeLocks(rawTx, emptyTrans, usedUTXO)
+	if err != nil {
+		return err
+	}
+
 	////////构建用于签名的交易单哈希
 	transHash, err := vasTransaction.CreateRawTransactionHashForSig(emptyTrans, txUnlocks, decoder.wm.Config.SupportSegWit, addressPrefix)
 	if err != nil {
@@ -953,7 +1451,7 @@ func (decoder *TransactionDecoder) createVASRawTransaction(
 	}
 
 	//装配签名
-	keySigs := make([]*openwallet.KeySignature, 0)
+	keySigs := make(map[string][]*openwallet.KeySignature)
 
 	for i, txHash := range transHash {
 
@@ -987,17 +1485,46 @@ func (decoder *TransactionDecoder) createVASRawTransaction(
 			Message: beSignHex,
 		}
 
-		keySigs = append(keySigs, &signature)
+		//输入可能来自不同账户，例如手续费支持账户，签名按账户分组
+		signAccountID := addr.AccountID
+		if len(signAccountID) == 0 {
+			signAccountID = accountID
+		}
+		keySigs[signAccountID] = append(keySigs[signAccountID], &signature)
+		inputAccounts = append(inputAccounts, signAccountID)
 
 	}
 
-	feesDec, _ := decimal.NewFromString(rawTx.Fees)
-	accountTotalSent = accountTotalSent.Add(feesDec)
+	//手续费支持账户支付的手续费不计入账户的转账amount
+	if len(feesSupportID) == 0 {
+		feesDec, _ := decimal.NewFromString(rawTx.Fees)
+		accountTotalSent = accountTotalSent.Add(feesDec)
+	}
 	accountTotalSent = decimal.Zero.Sub(accountTotalSent)
 
 	//TODO:多重签名要使用owner的公钥填充
 
-	rawTx.Signatures[rawTx.Account.AccountID] = keySigs
+	for signAccountID, sigs := range keySigs {
+		rawTx.Signatures[signAccountID] = sigs
+	}
+
+	//多个账户签名时，记录每个输入的签名账户，用于按输入顺序合并签名
+	if len(keySigs) > 1 {
+		rawTx.SetExtParam("inputAccounts", inputAccounts)
+	}
+
+	//记录输入的前置输出，签名前用于重新计算签名哈希
+	err = setRawTransactionPrevouts(rawTx, usedUTXO)
+	if err != nil {
+		return err
+	}
+
+	//锁定交易单使用的utxo
+	err = decoder.reserveRawTransactionUnspents(rawTx, usedUTXO)
+	if err != nil {
+		return err
+	}
+
 	rawTx.IsBuilt = true
 	rawTx.TxAmount = accountTotalSent.StringFixed(decoder.wm.Decimal())
 	rawTx.TxFrom = txFrom
@@ -1006,6 +1533,464 @@ func (decoder *TransactionDecoder) createVASRawTransaction(
 	return nil
 }
 
+//BumpFee 以更高的费率重建未确认的可替换交易单，使用相同的输入，增加的手续费从找零中扣除
+func (decoder *TransactionDecoder) BumpFee(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, txid string, newFeeRate string) (*openwallet.RawTransaction, error) {
+
+	var (
+		usedUTXO       = make([]*Unspent, 0)
+		outputAddrs    = make(map[string]decimal.Decimal)
+		totalInput     = decimal.Zero
+		totalOutput    = decimal.Zero
+		changeAddress  string
+		recordedChange string
+		changeAmount   = decimal.Zero
+		outputCount    = int64(0)
+		memoBytes      = int64(0)
+		destinationOut = make(map[string]decimal.Decimal)
+	)
+
+	if account == nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "account is empty")
+	}
+
+	feesRate, err := decimal.NewFromString(newFeeRate)
+	if err != nil || !feesRate.GreaterThan(decimal.Zero) {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid fee rate: %s", newFeeRate)
+	}
+
+	trx, err := decoder.wm.GetTransaction(txid)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "get transaction: %s failed, unexpected error: %v", txid, err)
+	}
+
+	if trx.Confirmations > 0 || len(trx.BlockHash) > 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction: %s is already confirmed", txid)
+	}
+
+	if !trx.IsReplaceable() {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction: %s is not replaceable", txid)
+	}
+
+	//还原原交易单的输入
+	for _, input := range trx.Vins {
+		preTx, err := decoder.wm.GetTransaction(input.TxID)
+		if err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "get transaction: %s failed, unexpected error: %v", input.TxID, err)
+		}
+		if len(preTx.Vouts) <= int(input.Vout) {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "input: %s:%d not found", input.TxID, input.Vout)
+		}
+		preOut := preTx.Vouts[input.Vout]
+		amount, _ := decimal.NewFromString(preOut.Value)
+		totalInput = totalInput.Add(amount)
+		usedUTXO = append(usedUTXO, &Unspent{
+			TxID:         input.TxID,
+			Vout:         input.Vout,
+			Address:      preOut.Addr,
+			Amount:       preOut.Value,
+			ScriptPubKey: preOut.ScriptPubKey,
+			Spendable:    true,
+		})
+	}
+
+	//找零地址以构建时记录的为准，没有记录时账户在原交易单中只能有一个输出，否则无法区分找零和转给自己的输出
+	if outbound, findErr := decoder.wm.GetOutboundTx(txid); findErr == nil {
+		recordedChange = outbound.ChangeAddress
+	}
+
+	//还原原交易单的输出
+	for _, output := range trx.Vouts {
+		amount, _ := decimal.NewFromString(output.Value)
+		totalOutput = totalOutput.Add(amount)
+
+		if output.IsNullData() {
+			data, _ := output.NullData()
+			if len(data) > 0 {
+				memoBytes = int64(vasTransaction.NullDataOutputSize(len(data)))
+			}
+			continue
+		}
+
+		outputCount++
+		isChange := false
+		if len(recordedChange) > 0 {
+			isChange = output.Addr == recordedChange
+		} else {
+			addresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", account.AccountID, "Address", output.Addr)
+			isChange = findErr == nil && len(addresses) > 0
+		}
+		if isChange {
+			if len(changeAddress) > 0 {
+				return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction: %s has ambiguous change outputs", txid)
+			}
+			changeAddress = output.Addr
+			changeAmount = amount
+			continue
+		}
+		destinationOut = appendOutput(destinationOut, output.Addr, amount)
+	}
+
+	if len(changeAddress) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction: %s has no change output to pay fees", txid)
+	}
+
+	//新手续费必须高于原手续费
+	oldFees := totalInput.Sub(totalOutput)
+	newFees, err := decoder.wm.EstimateFeeWithExtraBytes(int64(len(usedUTXO)), outputCount, memoBytes, feesRate)
+	if err != nil {
+		return nil, err
+	}
+	if !newFees.GreaterThan(oldFees) {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "new fees: %s must be greater than original fees: %s", newFees.StringFixed(decoder.wm.Decimal()), oldFees.StringFixed(decoder.wm.Decimal()))
+	}
+
+	//BIP125规则4：增加的手续费不低于增量中继费率 * 替换交易单大小
+	txBytes := decimal.New(estimateTxBytes(int64(len(usedUTXO)), outputCount, memoBytes), 0)
+	minIncrease := txBytes.Div(decimal.New(1000, 0)).Mul(decoder.wm.Config.IncrementalRelayFee).Round(decoder.wm.Decimal())
+	if newFees.Sub(oldFees).LessThan(minIncrease) {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "fees increase: %s is less than incremental relay fee: %s", newFees.Sub(oldFees).StringFixed(decoder.wm.Decimal()), minIncrease.StringFixed(decoder.wm.Decimal()))
+	}
+
+	changeAmount = changeAmount.Sub(newFees.Sub(oldFees))
+	if changeAmount.LessThan(decimal.Zero) {
+		return nil, openwallet.Errorf(openwallet.ErrInsufficientFees, "change: %s is not enough to pay fees: %s", changeAmount.Add(newFees.Sub(oldFees)).StringFixed(decoder.wm.Decimal()), newFees.StringFixed(decoder.wm.Decimal()))
+	}
+
+	//剩余找零低于粉尘限额，不再输出找零，并入手续费
+	if changeAmount.GreaterThan(decimal.Zero) && changeAmount.LessThan(decoder.wm.Config.DustLimit) {
+		newFees = newFees.Add(changeAmount)
+		changeAmount = decimal.Zero
+	}
+	if changeAmount.IsZero() && len(destinationOut) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrInsufficientFees, "transaction: %s has no output left after paying fees: %s", txid, newFees.StringFixed(decoder.wm.Decimal()))
+	}
+
+	raxTxTo := make(map[string]string, 0)
+	for a, m := range destinationOut {
+		outputAddrs = appendOutput(outputAddrs, a, m)
+		raxTxTo[a] = m.StringFixed(decoder.wm.Decimal())
+	}
+	if changeAmount.GreaterThan(decimal.Zero) {
+		outputAddrs = appendOutput(outputAddrs, changeAddress, changeAmount)
+	}
+
+	rawTx := &openwallet.RawTransaction{
+		Coin: openwallet.Coin{
+			Symbol:     decoder.wm.Symbol(),
+			IsContract: false,
+		},
+		Account:  account,
+		FeeRate:  feesRate.StringFixed(decoder.wm.Decimal()),
+		To:       raxTxTo,
+		Fees:     newFees.StringFixed(decoder.wm.Decimal()),
+		Required: 1,
+	}
+	rawTx.SetExtParam("replaceable", true)
+	rawTx.SetExtParam("replacesTxID", txid)
+	if changeAmount.GreaterThan(decimal.Zero) {
+		rawTx.SetExtParam("changeAddress", changeAddress)
+	}
+	if memo := trx.Memo(); len(memo) > 0 {
+		rawTx.SetExtParam("memoHex", hex.EncodeToString(memo))
+	}
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("Bump Fee TxID: %s", txid)
+	decoder.wm.Log.Std.Notice("Original Fees: %v", oldFees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("New Fees: %v", newFees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Change: %v", changeAmount.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Change Address: %v", changeAddress)
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	err = decoder.createVASRawTransaction(wrapper, rawTx, usedUTXO, outputAddrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return rawTx, nil
+}
+
+//CreateCPFPRawTransaction 花费未确认父交易中账户的输出构建子交易，使父子交易整体达到目标费率
+func (decoder *TransactionDecoder) CreateCPFPRawTransaction(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, parentTxID string, targetFeeRate string) (*openwallet.RawTransaction, error) {
+
+	var (
+		usedUTXO    = make([]*Unspent, 0)
+		outputAddrs = make(map[string]decimal.Decimal)
+		parentIn    = decimal.Zero
+		parentOut   = decimal.Zero
+		balance     = decimal.Zero
+		ownAddrs    = make([]string, 0)
+	)
+
+	if account == nil {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "account is empty")
+	}
+
+	feesRate, err := decimal.NewFromString(targetFeeRate)
+	if err != nil || !feesRate.GreaterThan(decimal.Zero) {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid fee rate: %s", targetFeeRate)
+	}
+
+	parent, err := decoder.wm.GetTransaction(parentTxID)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "get transaction: %s failed, unexpected error: %v", parentTxID, err)
+	}
+
+	if parent.Confirmations > 0 || len(parent.BlockHash) > 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction: %s is already confirmed", parentTxID)
+	}
+
+	//计算父交易已支付的手续费
+	for _, input := range parent.Vins {
+		preTx, err := decoder.wm.GetTransaction(input.TxID)
+		if err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "get transaction: %s failed, unexpected error: %v", input.TxID, err)
+		}
+		//父交易还有未确认的祖先交易时，打包费率无法只按父子交易计算
+		if preTx.Confirmations == 0 && len(preTx.BlockHash) == 0 {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction: %s has unconfirmed ancestor: %s", parentTxID, input.TxID)
+		}
+		if len(preTx.Vouts) <= int(input.Vout) {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "input: %s:%d not found", input.TxID, input.Vout)
+		}
+		amount, _ := decimal.NewFromString(preTx.Vouts[input.Vout].Value)
+		parentIn = parentIn.Add(amount)
+	}
+
+	//查找父交易中属于账户的输出
+	for _, output := range parent.Vouts {
+		amount, _ := decimal.NewFromString(output.Value)
+		parentOut = parentOut.Add(amount)
+		if output.IsNullData() || len(output.Addr) == 0 {
+			continue
+		}
+		addresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", account.AccountID, "Address", output.Addr)
+		if findErr == nil && len(addresses) > 0 {
+			ownAddrs = append(ownAddrs, output.Addr)
+		}
+	}
+
+	if len(ownAddrs) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "transaction: %s has no output of account: %s", parentTxID, account.AccountID)
+	}
+
+	unspents, err := decoder.wm.ListUnspent(0, ownAddrs...)
+	if err != nil {
+		return nil, err
+	}
+	unspents = decoder.wm.filterReservedUnspents(unspents)
+
+	for _, u := range unspents {
+		if u.TxID != parentTxID || !u.Spendable {
+			continue
+		}
+		ua, _ := decimal.NewFromString(u.Amount)
+		balance = balance.Add(ua)
+		usedUTXO = append(usedUTXO, u)
+	}
+
+	if len(usedUTXO) == 0 {
+		return nil, openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "transaction: %s has no spendable output of account: %s", parentTxID, account.AccountID)
+	}
+
+	/*
+		子交易手续费计算：
+
+		1. 打包总手续费 = (父交易虚拟大小 + 子交易大小) * 目标费率
+		2. 子交易手续费 = 打包总手续费 - 父交易已支付手续费
+		3. 子交易手续费不低于按目标费率计算的自身手续费
+	*/
+	parentFees := parentIn.Sub(parentOut)
+	childFees, err := decoder.wm.EstimateFee(int64(len(usedUTXO)), 1, feesRate)
+	if err != nil {
+		return nil, err
+	}
+	packageFees, err := decoder.wm.EstimateFeeWithExtraBytes(int64(len(usedUTXO)), 1, int64(parent.Vsize), feesRate)
+	if err != nil {
+		return nil, err
+	}
+	if packageFees.Sub(parentFees).GreaterThan(childFees) {
+		childFees = packageFees.Sub(parentFees)
+	}
+
+	sendAmount := balance.Sub(childFees)
+	if !sendAmount.GreaterThan(decimal.Zero) {
+		return nil, openwallet.Errorf(openwallet.ErrInsufficientFees, "outputs: %s is not enough to pay child fees: %s", balance.StringFixed(decoder.wm.Decimal()), childFees.StringFixed(decoder.wm.Decimal()))
+	}
+
+	//子交易转回账户自己的地址
+	changeAddress := usedUTXO[0].Address
+	outputAddrs = appendOutput(outputAddrs, changeAddress, sendAmount)
+
+	rawTx := &openwallet.RawTransaction{
+		Coin: openwallet.Coin{
+			Symbol:     decoder.wm.Symbol(),
+			IsContract: false,
+		},
+		Account:  account,
+		FeeRate:  feesRate.StringFixed(decoder.wm.Decimal()),
+		To:       map[string]string{changeAddress: sendAmount.StringFixed(decoder.wm.Decimal())},
+		Fees:     childFees.StringFixed(decoder.wm.Decimal()),
+		Required: 1,
+	}
+	rawTx.SetExtParam("cpfpParentTxID", parentTxID)
+
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+	decoder.wm.Log.Std.Notice("CPFP Parent TxID: %s", parentTxID)
+	decoder.wm.Log.Std.Notice("Parent Fees: %v", parentFees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Child Fees: %v", childFees.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Receive: %v", sendAmount.StringFixed(decoder.wm.Decimal()))
+	decoder.wm.Log.Std.Notice("Receive Address: %v", changeAddress)
+	decoder.wm.Log.Std.Notice("-----------------------------------------------")
+
+	err = decoder.createVASRawTransaction(wrapper, rawTx, usedUTXO, outputAddrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return rawTx, nil
+}
+
+//CreateOmniRawTransaction 创建omni代币交易单，选择持有足够代币的地址作为发送方，该地址的utxo支付转账成本和手续费
+func (decoder *TransactionDecoder) CreateOmniRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) error {
+
+	var (
+		accountID  = rawTx.Account.AccountID
+		feesRate   = decimal.Zero
+		toAmount   = decimal.Zero
+		sender     string
+		tokenTotal = decimal.Zero
+	)
+
+	if !decoder.wm.Config.OmniSupport {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "omni token is not supported")
+	}
+
+	if len(rawTx.To) != 1 {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "omni token transfer only support one receiver")
+	}
+
+	for _, amount := range rawTx.To {
+		toAmount, _ = decimal.NewFromString(amount)
+	}
+
+	if !toAmount.GreaterThan(decimal.Zero) {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "omni token transfer amount must be greater than 0")
+	}
+
+	address, err := wrapper.GetAddressList(0, -1, "AccountID", accountID)
+	if err != nil {
+		return err
+	}
+
+	if len(address) == 0 {
+		return openwallet.Errorf(openwallet.ErrAccountNotAddress, "[%s] have not addresses", accountID)
+	}
+
+	//选择持有足够代币的地址作为发送方，找到后不再查询其他地址
+	for _, a := range address {
+		tokenBalances, err := decoder.wm.ContractDecoder.GetTokenBalanceByAddress(rawTx.Coin.Contract, a.Address)
+		if err != nil {
+			return err
+		}
+		if len(tokenBalances) == 0 {
+			continue
+		}
+		tokenBalance, _ := decimal.NewFromString(tokenBalances[0].Balance.Balance)
+		tokenTotal = tokenTotal.Add(tokenBalance)
+		if tokenBalance.GreaterThanOrEqual(toAmount) {
+			sender = a.Address
+			break
+		}
+	}
+
+	if len(sender) == 0 {
+		return openwallet.Errorf(openwallet.ErrInsufficientTokenBalanceOfAddress, "account: %s has no address with enough token balance, account token balance: %s", accountID, tokenTotal.String())
+	}
+
+	if len(rawTx.FeeRate) == 0 {
+		feesRate, err = decoder.wm.EstimateFeeRate()
+		if err != nil {
+			return err
+		}
+	} else {
+		feesRate, _ = decimal.NewFromString(rawTx.FeeRate)
+	}
+
+	return decoder.createOmniRawTransactionFromSender(wrapper, rawTx, sender, feesRate)
+}
+
+//createOmniRawTransactionFromSender 使用发送方地址的utxo支付转账成本和手续费，创建omni代币交易单
+func (decoder *TransactionDecoder) createOmniRawTransactionFromSender(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction, sender string, feesRate decimal.Decimal) error {
+
+	var (
+		toAddress string
+		usedUTXO  = make([]*Unspent, 0)
+		balance   = decimal.Zero
+		fees      = decimal.Zero
+	)
+
+	for addr := range rawTx.To {
+		toAddress = addr
+	}
+
+	transferCost, _ := decimal.NewFromString(decoder.wm.Config.OmniTransferCost)
+	if !transferCost.GreaterThan(decimal.Zero) {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "omni transfer cost: %s must be greater than 0", decoder.wm.Config.OmniTransferCost)
+	}
+
+	//omni交易的发送方由输入地址决定，只使用发送方地址的utxo
+	unspents, err := decoder.wm.ListUnspent(0, sender)
+	if err != nil {
+		return err
+	}
+	unspents = filterSpendableUnspents(unspents)
+	unspents = decoder.wm.filterReservedUnspents(unspents)
+
+	sort.Sort(UnspentSort{unspents, func(a, b *Unspent) int {
+		a_amount, _ := decimal.NewFromString(a.Amount)
+		b_amount, _ := decimal.NewFromString(b.Amount)
+		if a_amount.LessThan(b_amount) {
+			return 1
+		} else {
+			return -1
+		}
+	}})
+
+	//simple send载荷20字节，输出为接收方和找零
+	payloadBytes := int64(vasTransaction.NullDataOutputSize(20))
+	for _, u := range unspents {
+		ua, _ := decimal.NewFromString(u.Amount)
+		balance = balance.Add(ua)
+		usedUTXO = append(usedUTXO, u)
+
+		fees, err = decoder.wm.EstimateFeeWithExtraBytes(int64(len(usedUTXO)), 2, payloadBytes, feesRate)
+		if err != nil {
+			return err
+		}
+		if balance.GreaterThanOrEqual(transferCost.Add(fees)) {
+			break
+		}
+	}
+
+	if balance.LessThan(transferCost.Add(fees)) || len(usedUTXO) == 0 {
+		return openwallet.Errorf(openwallet.ErrInsufficientFees, "address: %s balance: %s is not enough to pay omni transfer cost: %s and fees: %s", sender, balance.String(), transferCost.String(), fees.String())
+	}
+
+	//低于粉尘限额的找零并入手续费
+	coinTo := map[string]decimal.Decimal{toAddress: transferCost}
+	if change := balance.Sub(transferCost).Sub(fees); change.GreaterThanOrEqual(decoder.wm.Config.DustLimit) && change.GreaterThan(decimal.Zero) {
+		coinTo = appendOutput(coinTo, sender, change)
+	} else {
+		fees = fees.Add(change)
+	}
+
+	rawTx.FeeRate = feesRate.StringFixed(decoder.wm.Decimal())
+	rawTx.Fees = fees.StringFixed(decoder.wm.Decimal())
+
+	return decoder.createOmniRawTransaction(wrapper, rawTx, usedUTXO, coinTo, rawTx.To)
+}
+
 //createOmniRawTransaction 创建omni原始交易单
 func (decoder *TransactionDecoder) createOmniRawTransaction(
 	wrapper openwallet.WalletDAI,
@@ -1127,8 +2112,8 @@ func (decoder *TransactionDecoder) createOmniRawTransaction(
 	//锁定时间
 	lockTime := uint32(0)
 
-	//追加手续费支持
-	replaceable := false
+	//追加手续费支持，需要在扩展参数中声明
+	replaceable := rawTx.GetExtParam().Get("replaceable").Bool()
 
 	/////////构建空交易单
 	emptyTrans, err := omniTransaction.CreateEmptyRawTransaction(vins, vouts, omniDetail, lockTime, replaceable, addressPrefix)
@@ -1200,6 +2185,19 @@ func (decoder *TransactionDecoder) createOmniRawTransaction(
 	accountTotalSent = decimal.Zero.Sub(accountTotalSent)
 
 	rawTx.Signatures = signatures
+
+	//记录输入的前置输出，签名前用于重新计算签名哈希
+	err = setRawTransactionPrevouts(rawTx, usedUTXO)
+	if err != nil {
+		return err
+	}
+
+	//锁定交易单使用的utxo
+	err = decoder.reserveRawTransactionUnspents(rawTx, usedUTXO)
+	if err != nil {
+		return err
+	}
+
 	rawTx.IsBuilt = true
 	rawTx.TxAmount = accountTotalSent.StringFixed(tokenDecimals)
 	rawTx.TxFrom = txFrom
@@ -1210,7 +2208,357 @@ func (decoder *TransactionDecoder) createOmniRawTransaction(
 
 // CreateSummaryRawTransactionWithError 创建汇总交易，返回能原始交易单数组（包含带错误的原始交易单）
 func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
-	return decoder.CreateVASSummaryRawTransaction(wrapper, sumRawTx)
+	if sumRawTx.Coin.IsContract {
+		return decoder.CreateOmniSummaryRawTransaction(wrapper, sumRawTx)
+	} else {
+		return decoder.CreateVASSummaryRawTransaction(wrapper, sumRawTx)
+	}
+}
+
+//CreateOmniSummaryRawTransaction 创建omni代币汇总交易，代币余额达到最低转账的地址各自构建一笔交易单，由该地址的utxo支付转账成本和手续费
+func (decoder *TransactionDecoder) CreateOmniSummaryRawTransaction(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
+
+	var (
+		feesRate           = decimal.Zero
+		accountID          = sumRawTx.Account.AccountID
+		minTransfer, _     = decimal.NewFromString(sumRawTx.MinTransfer)
+		retainedBalance, _ = decimal.NewFromString(sumRawTx.RetainedBalance)
+		tokenDecimals      = int32(sumRawTx.Coin.Contract.Decimals)
+		rawTxArray         = make([]*openwallet.RawTransactionWithError, 0)
+	)
+
+	if !decoder.wm.Config.OmniSupport {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "omni token is not supported")
+	}
+
+	if minTransfer.LessThan(retainedBalance) {
+		return nil, fmt.Errorf("mini transfer amount must be greater than address retained balance")
+	}
+
+	address, err := wrapper.GetAddressList(sumRawTx.AddressStartIndex, sumRawTx.AddressLimit, "AccountID", accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(address) == 0 {
+		return nil, fmt.Errorf("[%s] have not addresses", accountID)
+	}
+
+	searchAddrs := make([]string, 0)
+	for _, address := range address {
+		searchAddrs = append(searchAddrs, address.Address)
+	}
+
+	tokenBalances, err := decoder.wm.ContractDecoder.GetTokenBalanceByAddress(sumRawTx.Coin.Contract, searchAddrs...)
+	if err != nil {
+		return nil, err
+	}
+
+	//取得费率
+	if len(sumRawTx.FeeRate) == 0 {
+		feesRate, err = decoder.wm.EstimateFeeRate()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		feesRate, _ = decimal.NewFromString(sumRawTx.FeeRate)
+	}
+
+	for _, tb := range tokenBalances {
+
+		//检查代币余额是否超过最低转账，汇总数量 = 代币余额 - 地址保留余额
+		tokenBalance, _ := decimal.NewFromString(tb.Balance.Balance)
+		if tokenBalance.LessThan(minTransfer) {
+			continue
+		}
+		sumAmount := tokenBalance.Sub(retainedBalance)
+		if !sumAmount.GreaterThan(decimal.Zero) {
+			continue
+		}
+
+		decoder.wm.Log.Debugf("address: %s token balance: %v, sumAmount: %v", tb.Balance.Address, tokenBalance, sumAmount)
+
+		//创建一笔交易单
+		rawTx := &openwallet.RawTransaction{
+			Coin:     sumRawTx.Coin,
+			Account:  sumRawTx.Account,
+			FeeRate:  sumRawTx.FeeRate,
+			To:       map[string]string{sumRawTx.SummaryAddress: sumAmount.StringFixed(tokenDecimals)},
+			Required: 1,
+		}
+
+		createErr := decoder.createOmniRawTransactionFromSender(wrapper, rawTx, tb.Balance.Address, feesRate)
+
+		rawTxArray = append(rawTxArray, &openwallet.RawTransactionWithError{
+			RawTx: rawTx,
+			Error: openwallet.ConvertError(createErr),
+		})
+	}
+
+	return rawTxArray, nil
+}
+
+//txOutput 交易单输出
+type txOutput struct {
+	Address string
+	Amount  decimal.Decimal
+	script  []byte
+	data    []byte //OP_RETURN数据输出携带的数据
+}
+
+//orderTxInputsAndOutputs 按排序策略排列交易单输入输出，OP_RETURN数据输出与其他输出一起参与排序
+//扩展参数inputOrder可指定输入策略：bip69，random，或"txid:vout"数组（未列出的输入按bip69排在后面）
+//扩展参数outputOrder可指定输出策略：bip69，random，或地址数组（nulldata表示数据输出，未列出的输出按bip69排在后面）
+//未指定inputOrder时，输入使用outputOrder指定的策略，都未指定时使用配置的默认策略
+func (decoder *TransactionDecoder) orderTxInputsAndOutputs(
+	rawTx *openwallet.RawTransaction,
+	usedUTXO []*Unspent,
+	to map[string]decimal.Decimal,
+	memoData []byte,
+	addressPrefix vasTransaction.AddressPrefix,
+) ([]*Unspent, []*txOutput, error) {
+
+	inputs := make([]*Unspent, len(usedUTXO))
+	copy(inputs, usedUTXO)
+
+	outputs := make([]*txOutput, 0, len(to)+1)
+	for addr, amount := range to {
+		script, err := vasTransaction.AddressToScriptPubKey(addr, addressPrefix)
+		if err != nil {
+			return nil, nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid address: %s, %v", addr, err)
+		}
+		outputs = append(outputs, &txOutput{Address: addr, Amount: amount, script: script})
+	}
+	if len(memoData) > 0 {
+		script, err := vasTransaction.NullDataScriptPubKey(memoData)
+		if err != nil {
+			return nil, nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid OP_RETURN data: %v", err)
+		}
+		outputs = append(outputs, &txOutput{Address: TxOutputNullData, Amount: decimal.Zero, script: script, data: memoData})
+	}
+
+	//默认先按bip69排序，保证同一交易单构建结果一致
+	sortInputsBIP69(inputs)
+	sortOutputsBIP69(outputs, decoder.wm.Decimal())
+
+	var (
+		inputPolicy  = decoder.wm.Config.TxOrderPolicy
+		outputPolicy = decoder.wm.Config.TxOrderPolicy
+		inputOrder   = rawTx.GetExtParam().Get("inputOrder")
+		outputOrder  = rawTx.GetExtParam().Get("outputOrder")
+	)
+
+	if outputOrder.IsArray() {
+		ordered := make([]*txOutput, 0, len(outputs))
+		used := make(map[string]bool)
+		for _, a := range outputOrder.Array() {
+			for _, output := range outputs {
+				if output.Address == a.String() && !used[output.Address] {
+					ordered = append(ordered, output)
+					used[output.Address] = true
+				}
+			}
+		}
+		for _, output := range outputs {
+			if !used[output.Address] {
+				ordered = append(ordered, output)
+			}
+		}
+		outputs = ordered
+		outputPolicy = TxOrderBIP69
+	} else if len(outputOrder.String()) > 0 {
+		outputPolicy = outputOrder.String()
+		inputPolicy = outputPolicy
+	}
+
+	if inputOrder.IsArray() {
+		ordered := make([]*Unspent, 0, len(inputs))
+		used := make(map[string]bool)
+		for _, a := range inputOrder.Array() {
+			for _, input := range inputs {
+				key := fmt.Sprintf("%s:%d", input.TxID, input.Vout)
+				if key == a.String() && !used[key] {
+					ordered = append(ordered, input)
+					used[key] = true
+				}
+			}
+		}
+		for _, input := range inputs {
+			if !used[fmt.Sprintf("%s:%d", input.TxID, input.Vout)] {
+				ordered = append(ordered, input)
+			}
+		}
+		inputs = ordered
+		inputPolicy = TxOrderBIP69
+	} else if len(inputOrder.String()) > 0 {
+		inputPolicy = inputOrder.String()
+	}
+
+	err := applyOrderPolicy(inputPolicy, len(inputs), func(i, j int) { inputs[i], inputs[j] = inputs[j], inputs[i] })
+	if err != nil {
+		return nil, nil, err
+	}
+	err = applyOrderPolicy(outputPolicy, len(outputs), func(i, j int) { outputs[i], outputs[j] = outputs[j], outputs[i] })
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return inputs, outputs, nil
+}
+
+//applyOrderPolicy 对已按bip69排序的元素应用排序策略
+func applyOrderPolicy(policy string, n int, swap func(i, j int)) error {
+	switch policy {
+	case TxOrderBIP69, "":
+		return nil
+	case TxOrderRandom:
+		return shuffleSlice(n, swap)
+	default:
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "unknown order policy: %s", policy)
+	}
+}
+
+//sortInputsBIP69 按BIP69排序输入：交易ID字典序，再按输出索引
+func sortInputsBIP69(inputs []*Unspent) {
+	sort.SliceStable(inputs, func(i, j int) bool {
+		if inputs[i].TxID != inputs[j].TxID {
+			return inputs[i].TxID < inputs[j].TxID
+		}
+		return inputs[i].Vout < inputs[j].Vout
+	})
+}
+
+//sortOutputsBIP69 按BIP69排序输出：金额升序，再按锁定脚本字典序
+func sortOutputsBIP69(outputs []*txOutput, decimals int32) {
+	sort.SliceStable(outputs, func(i, j int) bool {
+		ai := outputs[i].Amount.Shift(decimals).IntPart()
+		aj := outputs[j].Amount.Shift(decimals).IntPart()
+		if ai != aj {
+			return ai < aj
+		}
+		return bytes.Compare(outputs[i].script, outputs[j].script) < 0
+	})
+}
+
+//shuffleSlice 使用安全随机数打乱顺序
+func shuffleSlice(n int, swap func(i, j int)) error {
+	for i := n - 1; i > 0; i-- {
+		r, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return err
+		}
+		swap(i, int(r.Int64()))
+	}
+	return nil
+}
+
+//getRawTransactionLockTime 从扩展参数读取nLockTime
+func getRawTransactionLockTime(rawTx *openwallet.RawTransaction) (uint32, error) {
+	lockTime := rawTx.GetExtParam().Get("lockTime").Uint()
+	if lockTime > math.MaxUint32 {
+		return 0, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid lockTime: %d", lockTime)
+	}
+	return uint32(lockTime), nil
+}
+
+//setRawTransactionRelativeLocks 按扩展参数relativeLocks设置输入的BIP68相对时间锁
+//格式：{"txid:vout": {"blocks": 10}} 或 {"txid:vout": {"seconds": 3600}}
+func setRawTransactionRelativeLocks(rawTx *openwallet.RawTransaction, txHex string, usedUTXO []*Unspent) (string, error) {
+
+	relativeLocks := rawTx.GetExtParam().Get("relativeLocks")
+	if !relativeLocks.IsObject() {
+		return txHex, nil
+	}
+
+	var err error
+	for i, utxo := range usedUTXO {
+		lock := relativeLocks.Get(fmt.Sprintf("%s:%d", utxo.TxID, utxo.Vout))
+		if !lock.Exists() {
+			continue
+		}
+
+		var sequence uint32
+		if seconds := lock.Get("seconds"); seconds.Exists() {
+			sequence, err = vasTransaction.NewRelativeLockSequence(uint32(seconds.Uint()), true)
+		} else if blocks := lock.Get("blocks"); blocks.Exists() {
+			sequence, err = vasTransaction.NewRelativeLockSequence(uint32(blocks.Uint()), false)
+		} else {
+			err = fmt.Errorf("blocks or seconds is required")
+		}
+		if err != nil {
+			return "", openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "input: %s:%d relative lock invalid: %v", utxo.TxID, utxo.Vout, err)
+		}
+
+		txHex, err = vasTransaction.SetInputSequence(txHex, i, sequence)
+		if err != nil {
+			return "", openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "input: %s:%d set sequence failed: %v", utxo.TxID, utxo.Vout, err)
+		}
+	}
+
+	return txHex, nil
+}
+
+//getRawTransactionMemoData 从扩展参数读取OP_RETURN备注数据，memoHex优先于memo
+func getRawTransactionMemoData(rawTx *openwallet.RawTransaction) ([]byte, error) {
+
+	var (
+		data []byte
+		err  error
+	)
+
+	ext := rawTx.GetExtParam()
+	if memoHex := ext.Get("memoHex").String(); len(memoHex) > 0 {
+		data, err = hex.DecodeString(memoHex)
+		if err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid memoHex: %v", err)
+		}
+	} else if memo := ext.Get("memo").String(); len(memo) > 0 {
+		data = []byte(memo)
+	}
+
+	if len(data) > vasTransaction.MaxDataCarrierSize {
+		return nil, openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "memo size: %d is over limit: %d bytes", len(data), vasTransaction.MaxDataCarrierSize)
+	}
+
+	return data, nil
+}
+
+//orderedKeySignatures 按输入顺序合并各账户的签名，多账户签名的顺序记录在扩展参数inputAccounts
+func orderedKeySignatures(rawTx *openwallet.RawTransaction) ([]*openwallet.KeySignature, error) {
+
+	inputAccounts := rawTx.GetExtParam().Get("inputAccounts").Array()
+	if len(inputAccounts) == 0 {
+		keySignatures := make([]*openwallet.KeySignature, 0)
+		for _, sigs := range rawTx.Signatures {
+			keySignatures = append(keySignatures, sigs...)
+		}
+		return keySignatures, nil
+	}
+
+	keySignatures := make([]*openwallet.KeySignature, 0, len(inputAccounts))
+	used := make(map[string]int)
+	for _, a := range inputAccounts {
+		accountID := a.String()
+		sigs := rawTx.Signatures[accountID]
+		if used[accountID] >= len(sigs) {
+			return nil, fmt.Errorf("signatures of account: %s is not enough", accountID)
+		}
+		keySignatures = append(keySignatures, sigs[used[accountID]])
+		used[accountID]++
+	}
+
+	return keySignatures, nil
+}
+
+//filterSpendableUnspents 过滤出可花费的utxo
+func filterSpendableUnspents(unspents []*Unspent) []*Unspent {
+	spendable := make([]*Unspent, 0, len(unspents))
+	for _, u := range unspents {
+		if u.Spendable {
+			spendable = append(spendable, u)
+		}
+	}
+	return spendable
 }
 
 // getAssetsAccountUnspentSatisfyAmount
@@ -1236,9 +2584,65 @@ func (decoder *TransactionDecoder) getAssetsAccountUnspents(wrapper openwallet.W
 		return nil, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, err.Error())
 	}
 
+	//排除已被其他交易单锁定的utxo
+	unspents = decoder.wm.filterReservedUnspents(unspents)
+
+	return unspents, nil
+}
+
+//getFeesSupportUnspents 获取手续费支持账户可用的utxo，按金额从大到小排序
+func (decoder *TransactionDecoder) getFeesSupportUnspents(wrapper openwallet.WalletDAI, accountID string) ([]*Unspent, error) {
+
+	account, err := wrapper.GetAssetsAccountInfo(accountID)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrAccountNotFound, "fees support account: %s not found", accountID)
+	}
+
+	unspents, owErr := decoder.getAssetsAccountUnspents(wrapper, account)
+	if owErr != nil {
+		return nil, owErr
+	}
+	unspents = filterSpendableUnspents(unspents)
+
+	sort.Sort(UnspentSort{unspents, func(a, b *Unspent) int {
+		a_amount, _ := decimal.NewFromString(a.Amount)
+		b_amount, _ := decimal.NewFromString(b.Amount)
+		if a_amount.LessThan(b_amount) {
+			return 1
+		} else {
+			return -1
+		}
+	}})
+
 	return unspents, nil
 }
 
+//selectFeesSupportUnspents 从手续费支持账户的utxo中选择足够支付手续费的输入，返回选中的utxo、剩余的utxo、手续费和找零
+func (decoder *TransactionDecoder) selectFeesSupportUnspents(pool []*Unspent, inputs, outputs int64, feesRate decimal.Decimal) ([]*Unspent, []*Unspent, decimal.Decimal, decimal.Decimal, error) {
+
+	var (
+		balance = decimal.Zero
+		fees    = decimal.Zero
+		err     error
+	)
+
+	for i, u := range pool {
+		ua, _ := decimal.NewFromString(u.Amount)
+		balance = balance.Add(ua)
+
+		fees, err = decoder.wm.EstimateFee(inputs+int64(i+1), outputs, feesRate)
+		if err != nil {
+			return nil, pool, decimal.Zero, decimal.Zero, err
+		}
+
+		if balance.GreaterThanOrEqual(fees) {
+			return pool[:i+1], pool[i+1:], fees, balance.Sub(fees), nil
+		}
+	}
+
+	return nil, pool, fees, decimal.Zero, openwallet.Errorf(openwallet.ErrInsufficientFees, "fees support account balance: %s is not enough to pay fees: %s", balance.String(), fees.String())
+}
+
 //keepOmniCostUTXONotToUse，保留1个omni的最低转账成本的utxo 用于汇总omni
 func (decoder *TransactionDecoder) keepOmniCostUTXONotToUse(unspents []*Unspent) []*Unspent {
 
@@ -0,0 +1,601 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"fmt"
+	"github.com/assetsadapterstore/vas-adapter/vasTransaction"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOrderTxInputsAndOutputs(t *testing.T) {
+
+	wm := NewWalletManager()
+	decoder := NewTransactionDecoder(wm)
+
+	utxos := []*Unspent{
+		{TxID: "bb", Vout: 0, Address: "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", Amount: "1"},
+		{TxID: "aa", Vout: 1, Address: "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", Amount: "1"},
+		{TxID: "aa", Vout: 0, Address: "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", Amount: "1"},
+	}
+	to := map[string]decimal.Decimal{
+		"VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7": decimal.RequireFromString("2"),
+		"VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV": decimal.RequireFromString("0.5"),
+	}
+
+	//bip69
+	rawTx := &openwallet.RawTransaction{}
+	inputs, outputs, err := decoder.orderTxInputsAndOutputs(rawTx, utxos, to, nil, MainNetAddressPrefix)
+	if err != nil {
+		t.Errorf("orderTxInputsAndOutputs failed unexpected error: %v", err)
+		return
+	}
+	if inputs[0].TxID != "aa" || inputs[0].Vout != 0 || inputs[1].Vout != 1 || inputs[2].TxID != "bb" {
+		t.Errorf("inputs is not in bip69 order")
+	}
+	if outputs[0].Address != "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV" {
+		t.Errorf("outputs is not in bip69 order")
+	}
+
+	//指定顺序
+	rawTx.SetExtParam("outputOrder", []string{"VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7"})
+	_, outputs, err = decoder.orderTxInputsAndOutputs(rawTx, utxos, to, nil, MainNetAddressPrefix)
+	if err != nil {
+		t.Errorf("orderTxInputsAndOutputs failed unexpected error: %v", err)
+		return
+	}
+	if outputs[0].Address != "VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7" || len(outputs) != 2 {
+		t.Errorf("outputs is not in specified order")
+	}
+
+	//随机
+	rawTx.ExtParam = ""
+	rawTx.SetExtParam("outputOrder", TxOrderRandom)
+	inputs, outputs, err = decoder.orderTxInputsAndOutputs(rawTx, utxos, to, nil, MainNetAddressPrefix)
+	if err != nil {
+		t.Errorf("orderTxInputsAndOutputs failed unexpected error: %v", err)
+		return
+	}
+	if len(inputs) != 3 || len(outputs) != 2 {
+		t.Errorf("random order lost inputs or outputs")
+	}
+
+	//指定输入顺序，数据输出按bip69排在第一位
+	rawTx.ExtParam = ""
+	rawTx.SetExtParam("inputOrder", []string{"bb:0"})
+	inputs, outputs, err = decoder.orderTxInputsAndOutputs(rawTx, utxos, to, []byte("memo"), MainNetAddressPrefix)
+	if err != nil {
+		t.Errorf("orderTxInputsAndOutputs failed unexpected error: %v", err)
+		return
+	}
+	if inputs[0].TxID != "bb" || inputs[1].TxID != "aa" || inputs[1].Vout != 0 {
+		t.Errorf("inputs is not in specified order")
+	}
+	if len(outputs) != 3 || string(outputs[0].data) != "memo" {
+		t.Errorf("data output should be first in bip69 order")
+		return
+	}
+	//排序使用实际序列化的脚本：OP_RETURN OP_PUSH(4) memo
+	if string(outputs[0].script) != "\x6a\x04memo" {
+		t.Errorf("data output sort key should be the serialized scriptPubKey: %x", outputs[0].script)
+	}
+
+	//指定数据输出排在最后
+	rawTx.ExtParam = ""
+	rawTx.SetExtParam("outputOrder", []string{"VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", "VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7", TxOutputNullData})
+	_, outputs, err = decoder.orderTxInputsAndOutputs(rawTx, utxos, to, []byte("memo"), MainNetAddressPrefix)
+	if err != nil {
+		t.Errorf("orderTxInputsAndOutputs failed unexpected error: %v", err)
+		return
+	}
+	if string(outputs[2].data) != "memo" {
+		t.Errorf("data output is not in specified order")
+	}
+}
+
+func TestSplitUnspents(t *testing.T) {
+
+	wm := NewWalletManager()
+	wm.Config.MaxTxWeight = 4000
+
+	maxInputs := wm.MaxTxInputsForOutputs(2)
+	if maxInputs != 5 {
+		t.Errorf("max inputs: %d", maxInputs)
+		return
+	}
+
+	utxos := make([]*Unspent, 0)
+	for i := 0; i < 12; i++ {
+		utxos = append(utxos, &Unspent{TxID: "aa", Vout: uint64(i), Amount: "1"})
+	}
+
+	chunks := splitUnspents(utxos, maxInputs)
+	if len(chunks) != 3 || len(chunks[0]) != 5 || len(chunks[2]) != 2 {
+		t.Errorf("split unspents failed")
+		return
+	}
+
+	if !sumUnspents(chunks[1]).Equal(decimal.New(5, 0)) {
+		t.Errorf("sum unspents failed")
+		return
+	}
+}
+
+func TestSelectFeesSupportUnspents(t *testing.T) {
+
+	wm := NewWalletManager()
+	decoder := NewTransactionDecoder(wm)
+
+	pool := []*Unspent{
+		{TxID: "aa", Vout: 0, Address: "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", Amount: "0.0005"},
+		{TxID: "bb", Vout: 0, Address: "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", Amount: "0.0005"},
+	}
+
+	//2个汇总输入+手续费输入，2个输出，费率0.001/KB
+	feesRate := decimal.RequireFromString("0.001")
+	used, remain, fees, change, err := decoder.selectFeesSupportUnspents(pool, 2, 2, feesRate)
+	if err != nil {
+		t.Errorf("select fees support unspents failed, err: %v", err)
+		return
+	}
+	if len(used) != 2 || len(remain) != 0 || !fees.Add(change).Equal(decimal.RequireFromString("0.001")) {
+		t.Errorf("unexpected selection, used: %d, fees: %s, change: %s", len(used), fees.String(), change.String())
+		return
+	}
+
+	_, _, _, _, err = decoder.selectFeesSupportUnspents(pool[:1], 10, 2, feesRate)
+	if err == nil {
+		t.Errorf("insufficient fees support should fail")
+		return
+	}
+}
+
+func TestOrderedKeySignatures(t *testing.T) {
+
+	rawTx := &openwallet.RawTransaction{
+		Signatures: map[string][]*openwallet.KeySignature{
+			"A": {{Message: "a0"}, {Message: "a1"}},
+			"B": {{Message: "b0"}},
+		},
+	}
+	rawTx.SetExtParam("inputAccounts", []string{"A", "B", "A"})
+
+	sigs, err := orderedKeySignatures(rawTx)
+	if err != nil {
+		t.Errorf("ordered key signatures failed, err: %v", err)
+		return
+	}
+
+	if len(sigs) != 3 || sigs[0].Message != "a0" || sigs[1].Message != "b0" || sigs[2].Message != "a1" {
+		t.Errorf("signatures are not in input order")
+		return
+	}
+}
+
+func TestBumpFee(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "bump_fee")
+	if err != nil {
+		t.Errorf("create temp dir failed, err: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	input := `{"txid":"aa","confirmations":10,"blockhash":"bb","vin":[],"vout":[{"value":1.0,"n":0,"scriptPubKey":{"addresses":["VOwn"]}}]}`
+	node := newMockNode(map[string]string{
+		"getrawtransaction:aa": input,
+		//已确认
+		"getrawtransaction:cf": `{"txid":"cf","confirmations":3,"blockhash":"bb","vin":[{"txid":"aa","vout":0,"sequence":4294967293}],"vout":[]}`,
+		//未启用替换
+		"getrawtransaction:nr": `{"txid":"nr","confirmations":0,"vin":[{"txid":"aa","vout":0,"sequence":4294967295}],"vout":[]}`,
+		//账户有两个输出，无法判断找零
+		"getrawtransaction:am": `{"txid":"am","confirmations":0,"vin":[{"txid":"aa","vout":0,"sequence":4294967293}],"vout":[
+			{"value":0.5,"n":0,"scriptPubKey":{"addresses":["VOwn"]}},
+			{"value":0.4999,"n":1,"scriptPubKey":{"addresses":["VChange"]}}]}`,
+		//转出0.5，找零0.4999，原手续费0.0001
+		"getrawtransaction:cc": `{"txid":"cc","confirmations":0,"vin":[{"txid":"aa","vout":0,"sequence":4294967293}],"vout":[
+			{"value":0.5,"n":0,"scriptPubKey":{"addresses":["VDest"]}},
+			{"value":0.4999,"n":1,"scriptPubKey":{"addresses":["VChange"]}}]}`,
+	})
+	defer node.Close()
+
+	wm := NewWalletManager()
+	wm.Config.DBPath = dir
+	wm.WalletClient = NewClient(node.URL, "", false)
+	decoder := NewTransactionDecoder(wm)
+	wrapper := &policyWalletDAI{addresses: map[string]bool{"VOwn": true, "VChange": true}}
+	account := &openwallet.AssetsAccount{AccountID: "acc"}
+
+	cases := []struct {
+		txid    string
+		feeRate string
+		reason  string
+	}{
+		{"cf", "0.001", "already confirmed"},
+		{"nr", "0.001", "not replaceable"},
+		{"am", "0.001", "ambiguous change"},
+		//新手续费0.000101，高于原手续费但增加量低于增量中继费率要求的0.00000258
+		{"cc", "0.00039147", "incremental relay fee"},
+		//新手续费2.58，找零不足
+		{"cc", "10", "not enough to pay fees"},
+	}
+	for _, c := range cases {
+		_, err := decoder.BumpFee(wrapper, account, c.txid, c.feeRate)
+		if err == nil || !strings.Contains(err.Error(), c.reason) {
+			t.Errorf("bump fee of %s should be refused by %s, err: %v", c.txid, c.reason, err)
+			return
+		}
+	}
+
+	//构建时记录了找零地址，两个账户输出也能区分找零
+	wm.TrackOutboundTx(&openwallet.RawTransaction{TxID: "am", RawHex: "00", ExtParam: `{"changeAddress":"VChange"}`})
+	_, err = decoder.BumpFee(wrapper, account, "am", "10")
+	if err == nil || !strings.Contains(err.Error(), "not enough to pay fees") {
+		t.Errorf("bump fee should use recorded change address, err: %v", err)
+		return
+	}
+}
+
+func TestBumpFeeReplacement(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "bump_fee_replacement")
+	if err != nil {
+		t.Errorf("create temp dir failed, err: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	input := `{"txid":"d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401","confirmations":10,"blockhash":"bb","vin":[],"vout":[
+		{"value":1.0,"n":0,"scriptPubKey":{"hex":"76a914d46043209073ad39879356295562d952cd9dae3a88ac","addresses":["VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf"]}}]}`
+	node := newMockNode(map[string]string{
+		"getrawtransaction:d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401": input,
+		//转出0.5，找零0.4999，原手续费0.0001
+		"getrawtransaction:cc": `{"txid":"cc","confirmations":0,"vin":[{"txid":"d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401","vout":0,"sequence":4294967293}],"vout":[
+			{"value":0.5,"n":0,"scriptPubKey":{"addresses":["VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7"]}},
+			{"value":0.4999,"n":1,"scriptPubKey":{"addresses":["VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf"]}}]}`,
+		//转出0.99974，找零0.00016，提高手续费后剩余找零低于粉尘限额
+		"getrawtransaction:dc": `{"txid":"dc","confirmations":0,"vin":[{"txid":"d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401","vout":0,"sequence":4294967293}],"vout":[
+			{"value":0.99974,"n":0,"scriptPubKey":{"addresses":["VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7"]}},
+			{"value":0.00016,"n":1,"scriptPubKey":{"addresses":["VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf"]}}]}`,
+	})
+	defer node.Close()
+
+	wm := NewWalletManager()
+	wm.Config.DBPath = dir
+	wm.WalletClient = NewClient(node.URL, "", false)
+	decoder := NewTransactionDecoder(wm)
+	wrapper := &policyWalletDAI{addresses: map[string]bool{"VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf": true}}
+	account := &openwallet.AssetsAccount{AccountID: "acc"}
+
+	rawTx, err := decoder.BumpFee(wrapper, account, "cc", "0.001")
+	if err != nil {
+		t.Errorf("bump fee failed unexpected error: %v", err)
+		return
+	}
+
+	//使用相同的输入
+	prevouts := rawTx.GetExtParam().Get("prevouts").Array()
+	if len(prevouts) != 1 || prevouts[0].Get("txid").String() != "d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401" || prevouts[0].Get("vout").Int() != 0 {
+		t.Errorf("replacement should spend the same inputs: %v", prevouts)
+		return
+	}
+
+	//BIP125规则3、4：手续费高于原手续费，增加量不低于增量中继费率 * 交易单大小
+	fees, _ := decimal.NewFromString(rawTx.Fees)
+	oldFees := decimal.New(1, -4)
+	minIncrease := decimal.New(258, 0).Div(decimal.New(1000, 0)).Mul(wm.Config.IncrementalRelayFee)
+	if !fees.GreaterThan(oldFees) || fees.Sub(oldFees).LessThan(minIncrease) {
+		t.Errorf("replacement fees: %s do not satisfy BIP125", rawTx.Fees)
+		return
+	}
+
+	//增加的手续费从找零中扣除，转出金额不变
+	change := decimal.New(4999, -4).Sub(fees.Sub(oldFees))
+	txTo := strings.Join(rawTx.TxTo, ",")
+	if len(rawTx.TxTo) != 2 || !strings.Contains(txTo, "VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7:0.5") || !strings.Contains(txTo, "VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf:"+change.String()) {
+		t.Errorf("unexpected replacement outputs: %v", rawTx.TxTo)
+		return
+	}
+	if !rawTx.GetExtParam().Get("replaceable").Bool() || rawTx.GetExtParam().Get("replacesTxID").String() != "cc" {
+		t.Errorf("replacement should stay replaceable and record the replaced txid")
+		return
+	}
+
+	//重新生成未签名的签名位置
+	sigs := rawTx.Signatures["acc"]
+	if len(sigs) != 1 || len(sigs[0].Message) == 0 || len(sigs[0].Signature) > 0 || sigs[0].Address.Address != "VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf" {
+		t.Errorf("replacement should have fresh signature slots")
+		return
+	}
+
+	//剩余找零低于粉尘限额时去掉找零输出，并入手续费
+	wm.Config.DBPath = filepath.Join(dir, "dust")
+	os.MkdirAll(wm.Config.DBPath, 0755)
+	rawTx, err = decoder.BumpFee(wrapper, account, "dc", "0.001")
+	if err != nil {
+		t.Errorf("bump fee failed unexpected error: %v", err)
+		return
+	}
+	if len(rawTx.TxTo) != 1 || rawTx.Fees != "0.00026000" || rawTx.GetExtParam().Get("changeAddress").Exists() {
+		t.Errorf("dust change should be added to fees, fees: %s, outputs: %v", rawTx.Fees, rawTx.TxTo)
+	}
+}
+
+func TestCreateCPFPRawTransaction(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "cpfp")
+	if err != nil {
+		t.Errorf("create temp dir failed, err: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	input := `{"txid":"d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401","confirmations":10,"blockhash":"bb","vin":[],"vout":[{"value":1.0,"n":0,"scriptPubKey":{"addresses":["VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7"]}}]}`
+	spendInput := `"vin":[{"txid":"d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401","vout":0}]`
+	utxo := `{"txid":"%s","vout":1,"address":"VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf","scriptPubKey":"76a914d46043209073ad39879356295562d952cd9dae3a88ac","amount":%s,"confirmations":0}`
+	node := newMockNode(map[string]string{
+		"getrawtransaction:d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401": input,
+		//父交易转给账户0.4999，手续费0.0001，隔离见证交易虚拟大小141小于原始大小300
+		"getrawtransaction:e134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401": `{"txid":"e134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401","confirmations":0,"size":300,"vsize":141,` + spendInput + `,"vout":[
+			{"value":0.5,"n":0,"scriptPubKey":{"addresses":["VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7"]}},
+			{"value":0.4999,"n":1,"scriptPubKey":{"addresses":["VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf"]}}]}`,
+		//账户的输出不足以支付子交易手续费
+		"getrawtransaction:f134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401": `{"txid":"f134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401","confirmations":0,"size":226,` + spendInput + `,"vout":[
+			{"value":0.9997,"n":0,"scriptPubKey":{"addresses":["VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7"]}},
+			{"value":0.0002,"n":1,"scriptPubKey":{"addresses":["VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf"]}}]}`,
+		//已确认
+		"getrawtransaction:cf": `{"txid":"cf","confirmations":3,"blockhash":"bb",` + spendInput + `,"vout":[]}`,
+		//父交易花费未确认的祖先交易
+		"getrawtransaction:an": `{"txid":"an","confirmations":0,"vin":[{"txid":"pp","vout":0}],"vout":[]}`,
+		"getrawtransaction:pp": `{"txid":"pp","confirmations":0,"vin":[],"vout":[{"value":1.0,"n":0,"scriptPubKey":{"addresses":["VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7"]}}]}`,
+		//没有账户的输出
+		"getrawtransaction:no": `{"txid":"no","confirmations":0,` + spendInput + `,"vout":[{"value":0.9999,"n":0,"scriptPubKey":{"addresses":["VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7"]}}]}`,
+		"listunspent": "[" + fmt.Sprintf(utxo, "e134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", "0.4999") + "," +
+			fmt.Sprintf(utxo, "f134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", "0.0002") + "]",
+	})
+	defer node.Close()
+
+	wm := NewWalletManager()
+	wm.Config.DBPath = dir
+	wm.WalletClient = NewClient(node.URL, "", false)
+	decoder := NewTransactionDecoder(wm)
+	wrapper := &policyWalletDAI{addresses: map[string]bool{"VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf": true}}
+	account := &openwallet.AssetsAccount{AccountID: "acc"}
+
+	cases := []struct {
+		txid   string
+		reason string
+	}{
+		{"cf", "already confirmed"},
+		{"an", "unconfirmed ancestor"},
+		{"no", "has no output of account"},
+		//子交易手续费0.000265，账户输出只有0.0002
+		{"f134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", "not enough to pay child fees"},
+	}
+	for _, c := range cases {
+		_, err := decoder.CreateCPFPRawTransaction(wrapper, account, c.txid, "0.001")
+		if err == nil || !strings.Contains(err.Error(), c.reason) {
+			t.Errorf("cpfp of %s should be refused by %s, err: %v", c.txid, c.reason, err)
+			return
+		}
+	}
+
+	rawTx, err := decoder.CreateCPFPRawTransaction(wrapper, account, "e134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", "0.001")
+	if err != nil {
+		t.Errorf("create cpfp transaction failed unexpected error: %v", err)
+		return
+	}
+
+	//打包费率 = (父交易手续费 + 子交易手续费) / (父交易虚拟大小 + 子交易大小)，达到目标费率
+	childFees, _ := decimal.NewFromString(rawTx.Fees)
+	packageFees := childFees.Add(decimal.New(1, -4))
+	packageBytes := decimal.New(141+180+34+10, 0)
+	if packageFees.Div(packageBytes).Mul(decimal.New(1000, 0)).LessThan(decimal.New(1, -3)) || rawTx.Fees != "0.00026500" {
+		t.Errorf("child fees: %s do not raise the package fee rate to the target", rawTx.Fees)
+		return
+	}
+
+	//子交易花费父交易的输出，转回账户自己的地址
+	prevouts := rawTx.GetExtParam().Get("prevouts").Array()
+	if len(prevouts) != 1 || prevouts[0].Get("txid").String() != "e134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401" {
+		t.Errorf("child should spend the parent output: %v", prevouts)
+		return
+	}
+	if len(rawTx.TxTo) != 1 || rawTx.TxTo[0] != "VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf:0.499635" {
+		t.Errorf("unexpected child outputs: %v", rawTx.TxTo)
+	}
+}
+
+func TestCreateRawTransactionPieces(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tx_pieces")
+	if err != nil {
+		t.Errorf("create temp dir failed, err: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	//10个0.1和1个5的utxo
+	utxo := `{"txid":"%s","vout":%d,"address":"VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf","scriptPubKey":"76a914d46043209073ad39879356295562d952cd9dae3a88ac","amount":%s,"confirmations":200}`
+	utxos := make([]string, 0)
+	for i := 0; i < 10; i++ {
+		utxos = append(utxos, fmt.Sprintf(utxo, "d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", i, "0.1"))
+	}
+	utxos = append(utxos, fmt.Sprintf(utxo, "e134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", 0, "5"))
+	node := newMockNode(map[string]string{
+		"listunspent": "[" + strings.Join(utxos, ",") + "]",
+	})
+	defer node.Close()
+
+	wm := NewWalletManager()
+	wm.Config.DBPath = dir
+	wm.Config.MaxTxWeight = 4000
+	wm.WalletClient = NewClient(node.URL, "", false)
+	decoder := NewTransactionDecoder(wm)
+	wrapper := &policyWalletDAI{addresses: map[string]bool{"VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf": true}}
+	account := &openwallet.AssetsAccount{AccountID: "acc"}
+
+	newRawTx := func(amount string) *openwallet.RawTransaction {
+		return &openwallet.RawTransaction{
+			Coin:    openwallet.Coin{Symbol: "VAS"},
+			Account: account,
+			To:      map[string]string{"VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7": amount},
+			FeeRate: "0.0001",
+		}
+	}
+
+	//大额utxo优先仍需多笔交易单，提示分拆
+	err = decoder.CreateRawTransaction(wrapper, newRawTx("5.5"))
+	if err == nil || !strings.Contains(err.Error(), "CreateRawTransactionPieces") {
+		t.Errorf("over-sized transaction should be refused, err: %v", err)
+		return
+	}
+
+	//小额优先超过输入限制，大额优先只需1个输入
+	rawTx := newRawTx("0.6")
+	err = decoder.CreateRawTransaction(wrapper, rawTx)
+	if err != nil {
+		t.Errorf("create raw transaction failed, err: %v", err)
+		return
+	}
+	if len(rawTx.RawHex) == 0 || rawTx.GetExtParam().Get("changeAddress").String() != "VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf" {
+		t.Errorf("raw transaction should be built from selected unspents")
+		return
+	}
+
+	//剩余10个0.1，分拆成5+3个输入的2笔交易单
+	pieces, err := decoder.CreateRawTransactionPieces(wrapper, newRawTx("0.75"))
+	if err != nil {
+		t.Errorf("create raw transaction pieces failed, err: %v", err)
+		return
+	}
+	if len(pieces) != 2 || pieces[1].GetExtParam().Get("pieceCount").Int() != 2 || pieces[1].GetExtParam().Get("pieceIndex").Int() != 1 {
+		t.Errorf("unexpected pieces: %d", len(pieces))
+		return
+	}
+
+	//合并模式返回合并交易单，提示合并后重新创建付款
+	wm.Config.DBPath = filepath.Join(dir, "consolidate")
+	os.MkdirAll(wm.Config.DBPath, 0755)
+	rawTx = newRawTx("5.5")
+	rawTx.SetExtParam("splitMode", "consolidate")
+	pieces, err = decoder.CreateRawTransactionPieces(wrapper, rawTx)
+	if err != ErrConsolidationRequired || len(pieces) != 2 || !pieces[0].GetExtParam().Get("consolidation").Bool() {
+		t.Errorf("consolidate mode should return consolidation pieces, err: %v", err)
+		return
+	}
+}
+
+func TestCreateRawTransactionPiecesRelease(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tx_pieces_release")
+	if err != nil {
+		t.Errorf("create temp dir failed, err: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	utxo := `{"txid":"d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401","vout":%d,"address":"VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf","scriptPubKey":"%s","amount":%s,"confirmations":200}`
+	newNode := func(last string) *httptest.Server {
+		utxos := make([]string, 0)
+		for i := 0; i < 5; i++ {
+			utxos = append(utxos, fmt.Sprintf(utxo, i, "76a914d46043209073ad39879356295562d952cd9dae3a88ac", "0.1"))
+		}
+		utxos = append(utxos, last)
+		return newMockNode(map[string]string{
+			"listunspent": "[" + strings.Join(utxos, ",") + "]",
+		})
+	}
+
+	wm := NewWalletManager()
+	wm.Config.DBPath = dir
+	wm.Config.MaxTxWeight = 4000
+	decoder := NewTransactionDecoder(wm)
+	wrapper := &policyWalletDAI{addresses: map[string]bool{"VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf": true}}
+	account := &openwallet.AssetsAccount{AccountID: "acc"}
+
+	//第二笔交易单的输入不属于钱包，构建失败时释放第一笔交易单锁定的utxo
+	node := newNode(fmt.Sprintf(utxo, 5, "76a914000000000000000000000000000000000000000088ac", "0.05"))
+	defer node.Close()
+	wm.WalletClient = NewClient(node.URL, "", false)
+	rawTx := &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: "VAS"},
+		Account: account,
+		To:      map[string]string{"VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7": "0.52"},
+		FeeRate: "0.0001",
+	}
+	_, err = decoder.CreateRawTransactionPieces(wrapper, rawTx)
+	if err == nil {
+		t.Errorf("create raw transaction pieces should fail")
+		return
+	}
+	unspents, _ := wm.ListUnspent(0, "VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf")
+	if len(wm.filterReservedUnspents(unspents)) != 6 {
+		t.Errorf("unspents of built pieces should be released")
+		return
+	}
+
+	//合并模式跳过扣除手续费后低于粉尘限额的utxo
+	dustNode := newNode(fmt.Sprintf(utxo, 5, "76a914d46043209073ad39879356295562d952cd9dae3a88ac", "0.0000075"))
+	defer dustNode.Close()
+	wm.WalletClient = NewClient(dustNode.URL, "", false)
+	rawTx = &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: "VAS"},
+		Account: account,
+		To:      map[string]string{"VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7": "0.499993"},
+		FeeRate: "0.00001",
+	}
+	rawTx.SetExtParam("splitMode", "consolidate")
+	pieces, err := decoder.CreateRawTransactionPieces(wrapper, rawTx)
+	if err != ErrConsolidationRequired || len(pieces) != 1 || pieces[0].GetExtParam().Get("pieceCount").Int() != 1 {
+		t.Errorf("dust chunk should not be consolidated, pieces: %d, err: %v", len(pieces), err)
+	}
+}
+
+func TestSetRawTransactionRelativeLocks(t *testing.T) {
+
+	in := vasTransaction.Vin{TxID: "d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", Vout: 0}
+	out := vasTransaction.Vout{Address: "VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7", Amount: 900000}
+	emptyTrans, err := vasTransaction.CreateEmptyRawTransaction([]vasTransaction.Vin{in}, []vasTransaction.Vout{out}, 0, false, MainNetAddressPrefix)
+	if err != nil {
+		t.Errorf("create empty transaction failed, err: %v", err)
+		return
+	}
+	usedUTXO := []*Unspent{{TxID: in.TxID, Vout: 0}}
+
+	rawTx := &openwallet.RawTransaction{}
+	rawTx.SetExtParam("relativeLocks", map[string]interface{}{in.TxID + ":0": map[string]interface{}{"blocks": 10}})
+	txHex, err := setRawTransactionRelativeLocks(rawTx, emptyTrans, usedUTXO)
+	if err != nil || txHex == emptyTrans {
+		t.Errorf("relative lock is not set, err: %v", err)
+		return
+	}
+
+	//没有blocks和seconds的相对时间锁无效
+	rawTx = &openwallet.RawTransaction{}
+	rawTx.SetExtParam("relativeLocks", map[string]interface{}{in.TxID + ":0": map[string]interface{}{}})
+	_, err = setRawTransactionRelativeLocks(rawTx, emptyTrans, usedUTXO)
+	if err == nil || !strings.Contains(err.Error(), "blocks or seconds is required") {
+		t.Errorf("relative lock without blocks or seconds should be refused, err: %v", err)
+	}
+}
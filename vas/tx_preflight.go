@@ -0,0 +1,226 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+//PreflightResult 广播前检查结果
+type PreflightResult struct {
+	TxID         string
+	Size         uint64
+	Vsize        uint64 //虚拟大小，用于计算费率
+	InputAmount  decimal.Decimal
+	OutputAmount decimal.Decimal
+	Fees         decimal.Decimal
+	FeeRate      decimal.Decimal //每KB手续费
+	MempoolCheck bool            //是否经过节点testmempoolaccept检查
+	Allowed      bool
+	RejectReason string
+}
+
+//PreflightRawTransaction 广播前检查已签名交易单，可单独调用作为试运行，不会广播交易
+//检查内容：解码交易单，输入减输出与记录的手续费一致，费率不超过上限，节点testmempoolaccept通过
+//扩展参数replacesTxID不为空时，被替换交易花费的输入视为有效，金额从资金来源交易中查询
+func (decoder *TransactionDecoder) PreflightRawTransaction(rawTx *openwallet.RawTransaction) (*PreflightResult, error) {
+
+	if len(rawTx.RawHex) == 0 {
+		return nil, fmt.Errorf("transaction hex is empty")
+	}
+
+	trx, err := decoder.wm.DecodeRawTransaction(rawTx.RawHex)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, "decode raw transaction failed, err: %v", err)
+	}
+
+	result := &PreflightResult{
+		TxID:         trx.TxID,
+		Size:         trx.Size,
+		Vsize:        trx.Vsize,
+		InputAmount:  decimal.Zero,
+		OutputAmount: decimal.Zero,
+	}
+
+	//被替换交易花费的输入
+	replacedInputs := make(map[string]bool)
+	if replacesTxID := rawTx.GetExtParam().Get("replacesTxID").String(); len(replacesTxID) > 0 {
+		replaced, err := decoder.wm.GetTransaction(replacesTxID)
+		if err != nil {
+			return nil, openwallet.Errorf(openwallet.ErrSubmitRawTransactionFailed, "get replaced transaction: %s failed, err: %v", replacesTxID, err)
+		}
+		for _, vin := range replaced.Vins {
+			replacedInputs[utxoReservationKey(vin.TxID, vin.Vout)] = true
+		}
+	}
+
+	//查询输入的金额，输入不存在或已花费则拒绝
+	for _, vin := range trx.Vins {
+		value, err := decoder.wm.getUnspentTxOutValue(vin.TxID, vin.Vout)
+		if err != nil {
+			return nil, err
+		}
+		//交易池中已被被替换交易花费，从资金来源交易查询金额
+		if value == nil && replacedInputs[utxoReservationKey(vin.TxID, vin.Vout)] {
+			value, err = decoder.wm.getTxOutValue(vin.TxID, vin.Vout)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if value == nil {
+			result.RejectReason = fmt.Sprintf("input %s:%d is missing or spent", vin.TxID, vin.Vout)
+			return result, nil
+		}
+		result.InputAmount = result.InputAmount.Add(*value)
+	}
+
+	for _, vout := range trx.Vouts {
+		value, _ := decimal.NewFromString(vout.Value)
+		result.OutputAmount = result.OutputAmount.Add(value)
+	}
+
+	result.Fees = result.InputAmount.Sub(result.OutputAmount)
+	if result.Vsize > 0 {
+		result.FeeRate = result.Fees.Mul(decimal.New(1000, 0)).Div(decimal.New(int64(result.Vsize), 0)).Round(decoder.wm.Decimal())
+	}
+
+	if result.Fees.LessThan(decimal.Zero) {
+		result.RejectReason = fmt.Sprintf("outputs: %s is greater than inputs: %s", result.OutputAmount.String(), result.InputAmount.String())
+		return result, nil
+	}
+
+	//代币交易单记录的手续费不是主链币，跳过比对
+	if !rawTx.Coin.IsContract && len(rawTx.Fees) > 0 {
+		recordFees, _ := decimal.NewFromString(rawTx.Fees)
+		if !recordFees.Equal(result.Fees) {
+			result.RejectReason = fmt.Sprintf("fees: %s is not equal to recorded fees: %s", result.Fees.String(), recordFees.String())
+			return result, nil
+		}
+	}
+
+	if decoder.wm.Config.MaxFeeRate.GreaterThan(decimal.Zero) && result.FeeRate.GreaterThan(decoder.wm.Config.MaxFeeRate) {
+		result.RejectReason = fmt.Sprintf("fee rate: %s is over absurd fee rate: %s", result.FeeRate.String(), decoder.wm.Config.MaxFeeRate.String())
+		return result, nil
+	}
+
+	allowed, reason, supported, err := decoder.wm.TestMempoolAccept(rawTx.RawHex)
+	if err != nil {
+		return nil, err
+	}
+	result.MempoolCheck = supported
+	if supported && !allowed {
+		result.RejectReason = reason
+		return result, nil
+	}
+
+	result.Allowed = true
+	return result, nil
+}
+
+//DecodeRawTransaction 通过节点解码交易单
+func (wm *WalletManager) DecodeRawTransaction(txHex string) (*Transaction, error) {
+
+	request := []interface{}{
+		txHex,
+	}
+
+	result, err := wm.WalletClient.Call("decoderawtransaction", request)
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.newTxByCore(result), nil
+}
+
+//TestMempoolAccept 检查交易单是否能被节点交易池接受，supported为false表示节点不支持testmempoolaccept
+func (wm *WalletManager) TestMempoolAccept(txHex string) (allowed bool, reason string, supported bool, err error) {
+
+	request := []interface{}{
+		[]string{txHex},
+	}
+
+	result, err := wm.WalletClient.Call("testmempoolaccept", request)
+	if err != nil {
+		//-32601: Method not found
+		if strings.HasPrefix(err.Error(), "[-32601]") {
+			return false, "", false, nil
+		}
+		return false, "", false, err
+	}
+
+	/*
+		[
+			{
+				"txid": "...",
+				"allowed": false,
+				"reject-reason": "16: mandatory-script-verify-flag-failed"
+			}
+		]
+	*/
+	accept := result.Get("0")
+	return accept.Get("allowed").Bool(), accept.Get("reject-reason").String(), true, nil
+}
+
+//getUnspentTxOutValue 查询未花费输出的金额，包括交易池中的输出，已花费或不存在时返回nil
+func (wm *WalletManager) getUnspentTxOutValue(txid string, vout uint64) (*decimal.Decimal, error) {
+
+	request := []interface{}{
+		txid,
+		vout,
+		true,
+	}
+
+	result, err := wm.WalletClient.Call("gettxout", request)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Type == gjson.Null {
+		return nil, nil
+	}
+
+	value, err := decimal.NewFromString(result.Get("value").String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &value, nil
+}
+
+//getTxOutValue 通过getrawtransaction查询交易输出的金额，不检查是否已花费，输出不存在时返回nil
+func (wm *WalletManager) getTxOutValue(txid string, vout uint64) (*decimal.Decimal, error) {
+
+	trx, err := wm.GetTransaction(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(trx.Vouts) <= int(vout) {
+		return nil, nil
+	}
+
+	value, err := decimal.NewFromString(trx.Vouts[vout].Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &value, nil
+}
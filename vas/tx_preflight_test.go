@@ -0,0 +1,159 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+	"github.com/tidwall/gjson"
+)
+
+//mockRPCError 模拟节点返回的RPC错误，作为newMockNode的返回值
+func mockRPCError(code int, message string) string {
+	return fmt.Sprintf(`error:{"code":%d,"message":"%s"}`, code, message)
+}
+
+//newMockNode 模拟节点RPC，按"方法名:第一个参数"或方法名返回result，mockRPCError的返回值作为error
+func newMockNode(results map[string]string) *httptest.Server {
+	return newRecordingMockNode(results, nil)
+}
+
+//newRecordingMockNode 模拟节点，calls不为空时按顺序记录收到的调用方法
+func newRecordingMockNode(results map[string]string, calls *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		method := gjson.GetBytes(body, "method").String()
+		if calls != nil {
+			*calls = append(*calls, method)
+		}
+		result, ok := results[method+":"+gjson.GetBytes(body, "params.0").String()]
+		if !ok {
+			result, ok = results[method]
+		}
+		if !ok {
+			result = mockRPCError(-32601, "Method not found")
+		}
+		if strings.HasPrefix(result, "error:") {
+			w.Write([]byte(`{"result":null,"error":` + strings.TrimPrefix(result, "error:") + `,"id":"1"}`))
+			return
+		}
+		w.Write([]byte(`{"result":` + result + `,"error":null,"id":"1"}`))
+	}))
+}
+
+func TestPreflightRawTransaction(t *testing.T) {
+
+	node := newMockNode(map[string]string{
+		"decoderawtransaction": `{"txid":"cc","size":200,"vin":[{"txid":"aa","vout":0}],"vout":[{"value":0.999,"n":0,"scriptPubKey":{"addresses":["VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV"]}}]}`,
+		"gettxout":             `{"value":1.0,"coinbase":false}`,
+	})
+	defer node.Close()
+
+	wm := NewWalletManager()
+	wm.WalletClient = NewClient(node.URL, "", false)
+	decoder := NewTransactionDecoder(wm)
+
+	rawTx := &openwallet.RawTransaction{RawHex: "00", Fees: "0.001"}
+
+	//节点不支持testmempoolaccept时跳过
+	result, err := decoder.PreflightRawTransaction(rawTx)
+	if err != nil {
+		t.Errorf("preflight failed, err: %v", err)
+		return
+	}
+	if !result.Allowed || result.MempoolCheck || result.FeeRate.String() != "0.005" {
+		t.Errorf("unexpected preflight result: %+v", result)
+		return
+	}
+
+	//手续费与记录不一致
+	rawTx.Fees = "0.002"
+	result, _ = decoder.PreflightRawTransaction(rawTx)
+	if result.Allowed {
+		t.Errorf("fees mismatch should be rejected")
+		return
+	}
+
+	//超过费率上限
+	rawTx.Fees = "0.001"
+	wm.Config.MaxFeeRate = decimal.RequireFromString("0.001")
+	result, _ = decoder.PreflightRawTransaction(rawTx)
+	if result.Allowed {
+		t.Errorf("absurd fee rate should be rejected")
+		return
+	}
+
+	//隔离见证交易单按虚拟大小计算费率
+	segwitNode := newMockNode(map[string]string{
+		"decoderawtransaction": `{"txid":"cc","size":200,"vsize":125,"vin":[{"txid":"aa","vout":0}],"vout":[{"value":0.999,"n":0,"scriptPubKey":{"addresses":["VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV"]}}]}`,
+		"gettxout":             `{"value":1.0,"coinbase":false}`,
+	})
+	defer segwitNode.Close()
+	wm.WalletClient = NewClient(segwitNode.URL, "", false)
+	wm.Config.MaxFeeRate = decimal.RequireFromString("0.006")
+	result, _ = decoder.PreflightRawTransaction(rawTx)
+	if result.Allowed || result.FeeRate.String() != "0.008" {
+		t.Errorf("fee rate should be computed from vsize: %+v", result)
+		return
+	}
+
+	//默认启用费率上限
+	if !NewConfig(Symbol, CurveType, Decimals).MaxFeeRate.GreaterThan(decimal.Zero) {
+		t.Errorf("absurd fee guard should be enabled by default")
+	}
+}
+
+func TestPreflightReplacementRawTransaction(t *testing.T) {
+
+	//输入已被交易池中的被替换交易rr花费
+	node := newMockNode(map[string]string{
+		"decoderawtransaction": `{"txid":"cc","size":200,"vin":[{"txid":"aa","vout":0}],"vout":[{"value":0.998,"n":0,"scriptPubKey":{"addresses":["VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV"]}}]}`,
+		"gettxout":             `null`,
+		"getrawtransaction:rr": `{"txid":"rr","vin":[{"txid":"aa","vout":0}],"vout":[{"value":0.999,"n":0,"scriptPubKey":{"addresses":["VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV"]}}]}`,
+		"getrawtransaction:aa": `{"txid":"aa","vin":[],"vout":[{"value":1.0,"n":0,"scriptPubKey":{"addresses":["VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV"]}}]}`,
+	})
+	defer node.Close()
+
+	wm := NewWalletManager()
+	wm.WalletClient = NewClient(node.URL, "", false)
+	decoder := NewTransactionDecoder(wm)
+
+	rawTx := &openwallet.RawTransaction{RawHex: "00", Fees: "0.002"}
+
+	result, err := decoder.PreflightRawTransaction(rawTx)
+	if err != nil || result.Allowed {
+		t.Errorf("spent input should be rejected, err: %v", err)
+		return
+	}
+
+	rawTx.SetExtParam("replacesTxID", "rr")
+	result, err = decoder.PreflightRawTransaction(rawTx)
+	if err != nil {
+		t.Errorf("preflight failed, err: %v", err)
+		return
+	}
+	if !result.Allowed || !result.InputAmount.Equal(decimal.New(1, 0)) {
+		t.Errorf("replacement should pass preflight: %+v", result)
+		return
+	}
+}
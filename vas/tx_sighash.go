@@ -0,0 +1,287 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/assetsadapterstore/vas-adapter/vasTransaction"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//TxPrevout 交易单输入引用的前置输出，构建时记录到扩展参数prevouts，签名前用于重新计算签名哈希
+type TxPrevout struct {
+	TxID         string `json:"txid"`
+	Vout         uint64 `json:"vout"`
+	LockScript   string `json:"lockScript"`
+	RedeemScript string `json:"redeemScript"`
+	Amount       string `json:"amount"`
+}
+
+//SignPreview 签名前从交易单原文解析出的将要授权的内容
+type SignPreview struct {
+	Inputs       int
+	Outputs      []*SignPreviewOutput
+	InputAmount  decimal.Decimal
+	OutputAmount decimal.Decimal
+	Fees         decimal.Decimal
+	FeeRate      decimal.Decimal //按构建交易单的字节估算公式计算的每KB费率
+	Omni         *OmniPayload    //omni代币交易的载荷，非omni交易为nil
+	OmniSender   string          //omni代币的发送方
+	OmniReceiver string          //omni代币的接收方，为参考输出的地址
+}
+
+//SignPreviewOutput 交易单输出
+type SignPreviewOutput struct {
+	Address  string          `json:"address"` //无法还原地址的输出为锁定脚本
+	Amount   decimal.Decimal `json:"amount"`
+	NullData bool            `json:"nullData"` //OP_RETURN数据输出
+}
+
+//setRawTransactionPrevouts 记录交易单输入的前置输出，顺序与交易单输入一致
+func setRawTransactionPrevouts(rawTx *openwallet.RawTransaction, usedUTXO []*Unspent) error {
+	prevouts := make([]*TxPrevout, 0, len(usedUTXO))
+	for _, utxo := range usedUTXO {
+		prevouts = append(prevouts, &TxPrevout{
+			TxID:         utxo.TxID,
+			Vout:         utxo.Vout,
+			LockScript:   utxo.ScriptPubKey,
+			RedeemScript: utxo.RedeemScript,
+			Amount:       utxo.Amount,
+		})
+	}
+	return rawTx.SetExtParam("prevouts", prevouts)
+}
+
+//getRawTransactionPrevouts 获取交易单输入的前置输出，扩展参数没有记录时向节点查询
+func (decoder *TransactionDecoder) getRawTransactionPrevouts(rawTx *openwallet.RawTransaction, trx *vasTransaction.Transaction) ([]*TxPrevout, error) {
+
+	carried := rawTx.GetExtParam().Get("prevouts").Array()
+	if len(carried) > 0 && len(carried) != len(trx.Vins) {
+		return nil, fmt.Errorf("prevouts count: %d is not equal to inputs count: %d", len(carried), len(trx.Vins))
+	}
+
+	prevouts := make([]*TxPrevout, 0, len(trx.Vins))
+	for i, vin := range trx.Vins {
+		txid := vin.GetTxID()
+		vout := uint64(vin.GetVout())
+
+		if len(carried) > 0 {
+			p := carried[i]
+			if p.Get("txid").String() != txid || p.Get("vout").Uint() != vout {
+				return nil, fmt.Errorf("prevout of input %d is not match %s:%d", i, txid, vout)
+			}
+			prevouts = append(prevouts, &TxPrevout{
+				TxID:         txid,
+				Vout:         vout,
+				LockScript:   p.Get("lockScript").String(),
+				RedeemScript: p.Get("redeemScript").String(),
+				Amount:       p.Get("amount").String(),
+			})
+			continue
+		}
+
+		utxo, err := decoder.wm.GetTxOut(txid, vout)
+		if err != nil {
+			return nil, err
+		}
+		if len(utxo.ScriptPubKey) == 0 {
+			return nil, fmt.Errorf("prevout %s:%d is missing or spent", txid, vout)
+		}
+		//节点不返回赎回脚本，P2SH输入（包括时间锁输入）必须由扩展参数携带前置输出
+		if isP2SHLockScript(utxo.ScriptPubKey) {
+			return nil, fmt.Errorf("prevout %s:%d is a P2SH output, redeem script must be carried in prevouts", txid, vout)
+		}
+		prevouts = append(prevouts, &TxPrevout{
+			TxID:       txid,
+			Vout:       vout,
+			LockScript: utxo.ScriptPubKey,
+			Amount:     utxo.Value,
+		})
+	}
+
+	//节点可用时核对携带的前置输出
+	if len(carried) > 0 && decoder.wm.isNodeReachable() {
+		for i, p := range prevouts {
+			if err := decoder.wm.checkCarriedPrevout(p); err != nil {
+				return nil, fmt.Errorf("prevout of input %d is invalid, err: %v", i, err)
+			}
+		}
+	}
+
+	return prevouts, nil
+}
+
+//isP2SHLockScript 是否P2SH锁定脚本
+func isP2SHLockScript(lockScript string) bool {
+	script, err := hex.DecodeString(lockScript)
+	if err != nil {
+		return false
+	}
+	return len(script) == 23 && script[0] == vasTransaction.OpCodeHash160 && script[1] == 0x14 && script[22] == vasTransaction.OpCodeEqual
+}
+
+//isNodeReachable 节点是否可用，离线签名时无法连接节点
+func (wm *WalletManager) isNodeReachable() bool {
+	if wm.WalletClient == nil {
+		return false
+	}
+	_, err := wm.GetBlockHeight()
+	return err == nil
+}
+
+//checkCarriedPrevout 用节点的前置输出核对扩展参数携带的锁定脚本和金额，
+//非隔离见证输入的金额不受签名约束，构建方少报输入金额可以隐藏实际手续费
+func (wm *WalletManager) checkCarriedPrevout(p *TxPrevout) error {
+
+	prevout, err := wm.getSummaryPrevout(p.TxID, p.Vout)
+	if err != nil {
+		return err
+	}
+
+	carriedAmount, _ := decimal.NewFromString(p.Amount)
+	amount, _ := decimal.NewFromString(prevout.Value)
+	if prevout.ScriptPubKey != p.LockScript || !carriedAmount.Equal(amount) {
+		return fmt.Errorf("prevout %s:%d is not match the node, lockScript: %s, amount: %s", p.TxID, p.Vout, prevout.ScriptPubKey, prevout.Value)
+	}
+
+	return nil
+}
+
+//CheckRawTransactionSigHashes 签名前独立解析交易单原文，用前置输出重新计算每个输入的签名哈希，
+//与待签名的Message逐一比对，不一致时拒绝签名，返回将要授权的输出和手续费
+func (decoder *TransactionDecoder) CheckRawTransactionSigHashes(rawTx *openwallet.RawTransaction) (*SignPreview, error) {
+
+	var (
+		addressPrefix vasTransaction.AddressPrefix
+		txUnlocks     = make([]vasTransaction.TxUnlock, 0)
+		preview       = &SignPreview{
+			Outputs:      make([]*SignPreviewOutput, 0),
+			InputAmount:  decimal.Zero,
+			OutputAmount: decimal.Zero,
+		}
+	)
+
+	if decoder.wm.Config.IsTestNet {
+		addressPrefix = TestNetAddressPrefix
+	} else {
+		addressPrefix = MainNetAddressPrefix
+	}
+
+	txBytes, err := hex.DecodeString(rawTx.RawHex)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "invalid transaction hex data")
+	}
+
+	trx, err := vasTransaction.DecodeRawTransaction(txBytes, decoder.wm.Config.SupportSegWit)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "decode transaction failed, err: %v", err)
+	}
+
+	prevouts, err := decoder.getRawTransactionPrevouts(rawTx, trx)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "get prevouts failed, err: %v", err)
+	}
+
+	//用于按omni规则解析发送方和接收方
+	omniView := &Transaction{}
+
+	for _, p := range prevouts {
+		amount, _ := decimal.NewFromString(p.Amount)
+		preview.InputAmount = preview.InputAmount.Add(amount)
+		script, _ := hex.DecodeString(p.LockScript)
+		sender, _ := vasTransaction.ScriptPubKeyToAddress(script, addressPrefix)
+		omniView.Vins = append(omniView.Vins, &Vin{Addr: sender, Value: p.Amount})
+		txUnlocks = append(txUnlocks, vasTransaction.TxUnlock{
+			LockScript:   p.LockScript,
+			RedeemScript: p.RedeemScript,
+			Amount:       uint64(amount.Shift(decoder.wm.Decimal()).IntPart()),
+			SigType:      vasTransaction.SigHashAll,
+		})
+	}
+
+	for i, out := range trx.Vouts {
+		amount := decimal.New(int64(out.GetAmount()), -decoder.wm.Decimal())
+		preview.OutputAmount = preview.OutputAmount.Add(amount)
+		receiver := out.GetLockScript()
+		script, _ := hex.DecodeString(receiver)
+		omniOut := &Vout{N: uint64(i), Value: amount.String(), ScriptPubKey: receiver}
+		if address, err := vasTransaction.ScriptPubKeyToAddress(script, addressPrefix); err == nil {
+			receiver = address
+			omniOut.Addr = address
+		}
+		omniView.Vouts = append(omniView.Vouts, omniOut)
+		preview.Outputs = append(preview.Outputs, &SignPreviewOutput{
+			Address:  receiver,
+			Amount:   amount,
+			NullData: len(script) > 0 && script[0] == vasTransaction.OpCodeReturn,
+		})
+	}
+	preview.Inputs = len(trx.Vins)
+
+	if payload, ok := omniView.OmniPayload(); ok {
+		preview.Omni = payload
+		preview.OmniSender = omniView.OmniSender()
+		preview.OmniReceiver = omniView.OmniReference(preview.OmniSender)
+	}
+	preview.Fees = preview.InputAmount.Sub(preview.OutputAmount)
+
+	//按EstimateFee的字节估算公式计算
+	estimateBytes := estimateTxBytes(int64(preview.Inputs), int64(len(preview.Outputs)), 0)
+	preview.FeeRate = preview.Fees.Mul(decimal.New(1000, 0)).Div(decimal.New(estimateBytes, 0)).Round(decoder.wm.Decimal())
+
+	if preview.Fees.LessThan(decimal.Zero) {
+		return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "outputs: %s is greater than inputs: %s", preview.OutputAmount.String(), preview.InputAmount.String())
+	}
+
+	if len(rawTx.Fees) > 0 {
+		recordFees, _ := decimal.NewFromString(rawTx.Fees)
+		if !recordFees.Equal(preview.Fees) {
+			return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "fees: %s is not equal to recorded fees: %s", preview.Fees.String(), recordFees.String())
+		}
+	}
+
+	transHash, err := vasTransaction.CreateRawTransactionHashForSig(rawTx.RawHex, txUnlocks, decoder.wm.Config.SupportSegWit, addressPrefix)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "recompute transaction hash failed, err: %v", err)
+	}
+
+	keySignatures, err := orderedKeySignatures(rawTx)
+	if err != nil {
+		return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "%v", err)
+	}
+
+	if len(keySignatures) != len(transHash) {
+		return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "signatures count: %d is not equal to inputs count: %d", len(keySignatures), len(transHash))
+	}
+
+	for i, txHash := range transHash {
+		keySignature := keySignatures[i]
+		if txHash.IsMultisig() {
+			return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "input %d is multisig, not supported", i)
+		}
+		if keySignature.Message != txHash.GetTxHashHex() {
+			return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "input %d message: %s is not match the recomputed hash: %s", i, keySignature.Message, txHash.GetTxHashHex())
+		}
+		if keySignature.Address == nil || keySignature.Address.Address != txHash.GetNormalTxAddress() {
+			return nil, openwallet.Errorf(openwallet.ErrSignRawTransactionFailed, "input %d signer address is not match the prevout address: %s", i, txHash.GetNormalTxAddress())
+		}
+	}
+
+	return preview, nil
+}
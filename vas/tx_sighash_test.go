@@ -0,0 +1,108 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/assetsadapterstore/vas-adapter/vasTransaction"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+func TestCheckRawTransactionSigHashes(t *testing.T) {
+
+	wm := NewWalletManager()
+	decoder := NewTransactionDecoder(wm)
+
+	in := vasTransaction.Vin{TxID: "d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", Vout: 0}
+	out := vasTransaction.Vout{Address: "VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7", Amount: 900000}
+	unlock := vasTransaction.TxUnlock{LockScript: "76a914d46043209073ad39879356295562d952cd9dae3a88ac", Amount: 1000000, SigType: vasTransaction.SigHashAll}
+
+	emptyTrans, err := vasTransaction.CreateEmptyRawTransaction([]vasTransaction.Vin{in}, []vasTransaction.Vout{out}, 0, false, MainNetAddressPrefix)
+	if err != nil {
+		t.Errorf("create empty transaction failed, err: %v", err)
+		return
+	}
+
+	transHash, err := vasTransaction.CreateRawTransactionHashForSig(emptyTrans, []vasTransaction.TxUnlock{unlock}, wm.Config.SupportSegWit, MainNetAddressPrefix)
+	if err != nil {
+		t.Errorf("create transaction hash failed, err: %v", err)
+		return
+	}
+
+	keySignature := &openwallet.KeySignature{
+		Address: &openwallet.Address{Address: transHash[0].GetNormalTxAddress()},
+		Message: transHash[0].GetTxHashHex(),
+	}
+
+	rawTx := &openwallet.RawTransaction{
+		RawHex:     emptyTrans,
+		Fees:       "0.001",
+		Signatures: map[string][]*openwallet.KeySignature{"A": {keySignature}},
+	}
+	setRawTransactionPrevouts(rawTx, []*Unspent{
+		{TxID: in.TxID, Vout: 0, ScriptPubKey: unlock.LockScript, Amount: "0.01"},
+	})
+
+	preview, err := decoder.CheckRawTransactionSigHashes(rawTx)
+	if err != nil {
+		t.Errorf("check sighashes failed, err: %v", err)
+		return
+	}
+	if len(preview.Outputs) != 1 || preview.Outputs[0].Address != "VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7" || preview.Outputs[0].Amount.String() != "0.009" || preview.Fees.String() != "0.001" {
+		t.Errorf("unexpected sign preview: %+v", preview)
+	}
+
+	//记录的手续费与交易单不一致
+	rawTx.Fees = "0.0001"
+	if _, err = decoder.CheckRawTransactionSigHashes(rawTx); err == nil {
+		t.Errorf("fees mismatch should be rejected")
+	}
+	rawTx.Fees = "0.001"
+
+	//节点可用时核对携带的前置输出，少报输入金额被拒绝
+	node := newMockNode(map[string]string{
+		"getblockcount": "100",
+		"gettxout":      `{"value":0.02,"scriptPubKey":{"hex":"76a914d46043209073ad39879356295562d952cd9dae3a88ac"}}`,
+	})
+	defer node.Close()
+	wm.WalletClient = NewClient(node.URL, "", false)
+	if _, err = decoder.CheckRawTransactionSigHashes(rawTx); err == nil {
+		t.Errorf("understated prevout amount should be rejected")
+	}
+	if _, err = decoder.DecodeRawTransactionSummary(nil, rawTx); err == nil {
+		t.Errorf("understated prevout amount should be rejected by summary")
+	}
+	wm.WalletClient = nil
+
+	//待签名消息被替换
+	keySignature.Message = "00" + keySignature.Message[2:]
+	if _, err = decoder.CheckRawTransactionSigHashes(rawTx); err == nil {
+		t.Errorf("message mismatch should be rejected")
+	}
+
+	//未携带前置输出时向节点查询，节点无法提供P2SH输入的赎回脚本
+	p2shNode := newMockNode(map[string]string{
+		"gettxout": `{"value":0.01,"scriptPubKey":{"hex":"a914671859ee6ae41decb6c855c53a60a8fa157b740a87"}}`,
+	})
+	defer p2shNode.Close()
+	wm.WalletClient = NewClient(p2shNode.URL, "", false)
+	rawTx.SetExtParam("prevouts", nil)
+	if _, err = decoder.CheckRawTransactionSigHashes(rawTx); err == nil || !strings.Contains(err.Error(), "redeem script") {
+		t.Errorf("P2SH prevout without redeem script should be rejected, err: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"strings"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+const (
+	//交易单状态查询结果
+	TxStatusUnknown    = "unknown"    //交易池和区块中都找不到
+	TxStatusPending    = "pending"    //在交易池中等待确认
+	TxStatusConfirmed  = "confirmed"  //已打包到区块
+	TxStatusConflicted = "conflicted" //输入被其他交易花费
+)
+
+//TransactionStatus 交易单状态
+type TransactionStatus struct {
+	TxID          string `json:"txid"`
+	Status        string `json:"status"`
+	Confirmations uint64 `json:"confirmations"`
+	BlockHash     string `json:"blockHash"`
+	BlockHeight   uint64 `json:"blockHeight"`
+	BlockTime     int64  `json:"blockTime"`
+	Reason        string `json:"reason"`
+}
+
+//GetTransactionStatus 查询交易单状态，节点找不到交易单时（未开启txindex的节点查询不到已确认的交易单），
+//使用已广播交易单的跟踪记录，冲突只在跟踪器确认输入被其他交易花费后返回
+func (wm *WalletManager) GetTransactionStatus(txid string) (*TransactionStatus, error) {
+
+	status := &TransactionStatus{
+		TxID: txid,
+	}
+
+	trx, err := wm.GetTransaction(txid)
+	if err == nil {
+		if len(trx.BlockHash) == 0 || trx.Confirmations == 0 {
+			status.Status = TxStatusPending
+			return status, nil
+		}
+
+		status.Status = TxStatusConfirmed
+		status.Confirmations = trx.Confirmations
+		status.BlockHash = trx.BlockHash
+		status.BlockTime = trx.Blocktime
+		block, err := wm.GetBlock(trx.BlockHash)
+		if err != nil {
+			return nil, err
+		}
+		status.BlockHeight = block.Height
+		return status, nil
+	}
+
+	//-5: No information available about transaction
+	if !strings.HasPrefix(err.Error(), "[-5]") {
+		return nil, err
+	}
+
+	status.Status = TxStatusUnknown
+	status.Reason = "transaction is not found in mempool or chain"
+
+	//只有已广播的交易单有跟踪记录
+	outbound, err := wm.GetOutboundTx(txid)
+	if err != nil {
+		return status, nil
+	}
+
+	switch outbound.Status {
+	case OutboundTxConfirmed:
+		return newOutboundConfirmedStatus(outbound), nil
+	case OutboundTxConflicted:
+		status.Status = TxStatusConflicted
+		status.Reason = "inputs are spent by other transaction"
+		return status, nil
+	}
+
+	//跟踪器尚未更新状态，从交易单未花费的输出检查是否已确认
+	confirmed, err := wm.checkTxOutConfirmed(outbound)
+	if err != nil {
+		return nil, err
+	}
+	if confirmed {
+		return newOutboundConfirmedStatus(outbound), nil
+	}
+
+	//输入已花费但无法确定花费的交易，等待跟踪器确认冲突
+	trx, err = wm.DecodeRawTransaction(outbound.RawHex)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, vin := range trx.Vins {
+		value, err := wm.getUnspentTxOutValue(vin.TxID, vin.Vout)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			status.Reason = "input " + utxoReservationKey(vin.TxID, vin.Vout) + " is spent, conflict is not confirmed"
+			return status, nil
+		}
+	}
+
+	return status, nil
+}
+
+//newOutboundConfirmedStatus 跟踪记录中已确认交易单的状态
+func newOutboundConfirmedStatus(outbound *OutboundTx) *TransactionStatus {
+	return &TransactionStatus{
+		TxID:          outbound.TxID,
+		Status:        TxStatusConfirmed,
+		Confirmations: outbound.Confirmations,
+		BlockHash:     outbound.BlockHash,
+		BlockHeight:   outbound.BlockHeight,
+	}
+}
+
+//GetTransactionStatus 查询交易单状态，返回openwallet交易单：
+//已确认为TxStatusSuccess，冲突为TxStatusFail，等待确认和未知时Status为空，详细状态记录在扩展参数txStatus
+func (decoder *TransactionDecoder) GetTransactionStatus(txid string) (*openwallet.Transaction, error) {
+
+	status, err := decoder.wm.GetTransactionStatus(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &openwallet.Transaction{
+		TxID:        status.TxID,
+		Coin:        openwallet.Coin{Symbol: decoder.wm.Symbol()},
+		Decimal:     decoder.wm.Decimal(),
+		Confirm:     int64(status.Confirmations),
+		BlockHash:   status.BlockHash,
+		BlockHeight: status.BlockHeight,
+		ConfirmTime: status.BlockTime,
+		Reason:      status.Reason,
+	}
+	tx.WxID = openwallet.GenTransactionWxID(tx)
+
+	switch status.Status {
+	case TxStatusConfirmed:
+		tx.Status = openwallet.TxStatusSuccess
+	case TxStatusConflicted:
+		tx.Status = openwallet.TxStatusFail
+	}
+
+	err = tx.SetExtParam("txStatus", status.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+func TestGetTransactionStatus(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tx_status")
+	if err != nil {
+		t.Errorf("create temp dir failed, err: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	wm := NewWalletManager()
+	wm.Config.DBPath = dir
+	decoder := NewTransactionDecoder(wm)
+
+	//已确认
+	confirmed := newMockNode(map[string]string{
+		"getrawtransaction": `{"txid":"cc","blockhash":"bb","confirmations":2,"blocktime":1573722270}`,
+		"getblock":          `{"hash":"bb","height":100}`,
+	})
+	defer confirmed.Close()
+	wm.WalletClient = NewClient(confirmed.URL, "", false)
+
+	tx, err := decoder.GetTransactionStatus("cc")
+	if err != nil {
+		t.Errorf("get transaction status failed, err: %v", err)
+		return
+	}
+	if tx.Status != openwallet.TxStatusSuccess || tx.Confirm != 2 || tx.BlockHeight != 100 || tx.GetExtParam().Get("txStatus").String() != TxStatusConfirmed {
+		t.Errorf("unexpected confirmed status: %+v", tx)
+		return
+	}
+
+	//交易池中等待确认
+	pending := newMockNode(map[string]string{
+		"getrawtransaction": `{"txid":"cc","confirmations":0}`,
+	})
+	defer pending.Close()
+	wm.WalletClient = NewClient(pending.URL, "", false)
+
+	status, err := wm.GetTransactionStatus("cc")
+	if err != nil || status.Status != TxStatusPending {
+		t.Errorf("unexpected pending status: %+v, err: %v", status, err)
+		return
+	}
+
+	//没有跟踪记录
+	notFound := newMockNode(map[string]string{
+		"getrawtransaction":    mockRPCError(-5, "No information available about transaction"),
+		"decoderawtransaction": `{"txid":"cc","size":200,"vin":[{"txid":"aa","vout":0}],"vout":[{"value":0.5,"n":0}]}`,
+		"gettxout":             `null`,
+	})
+	defer notFound.Close()
+	wm.WalletClient = NewClient(notFound.URL, "", false)
+
+	status, err = wm.GetTransactionStatus("cc")
+	if err != nil || status.Status != TxStatusUnknown {
+		t.Errorf("unexpected unknown status: %+v, err: %v", status, err)
+		return
+	}
+
+	//输入已花费，跟踪器未确认冲突前不返回冲突
+	wm.TrackOutboundTx(&openwallet.RawTransaction{TxID: "cc", RawHex: "00"})
+	tx, err = decoder.GetTransactionStatus("cc")
+	if err != nil || tx.Status != "" || tx.GetExtParam().Get("txStatus").String() != TxStatusUnknown {
+		t.Errorf("unexpected unconfirmed conflict status: %+v, err: %v", tx, err)
+		return
+	}
+
+	//跟踪器确认输入被其他交易花费
+	outbound, _ := wm.GetOutboundTx("cc")
+	outbound.Status = OutboundTxConflicted
+	wm.SaveOutboundTx(outbound)
+	tx, err = decoder.GetTransactionStatus("cc")
+	if err != nil || tx.Status != openwallet.TxStatusFail || tx.GetExtParam().Get("txStatus").String() != TxStatusConflicted {
+		t.Errorf("unexpected conflicted status: %+v, err: %v", tx, err)
+		return
+	}
+
+	//跟踪器已确认，节点未开启txindex时返回记录的区块
+	outbound.Status = OutboundTxConfirmed
+	outbound.BlockHash = "bb"
+	outbound.BlockHeight = 100
+	outbound.Confirmations = 6
+	wm.SaveOutboundTx(outbound)
+	tx, err = decoder.GetTransactionStatus("cc")
+	if err != nil || tx.Status != openwallet.TxStatusSuccess || tx.BlockHash != "bb" || tx.BlockHeight != 100 {
+		t.Errorf("unexpected tracked confirmed status: %+v, err: %v", tx, err)
+		return
+	}
+
+	//跟踪器尚未更新，从交易单未花费的输出得到确认数和区块
+	noTxIndex := newMockNode(map[string]string{
+		"getrawtransaction":    mockRPCError(-5, "No information available about transaction"),
+		"decoderawtransaction": `{"txid":"dd","size":200,"vin":[{"txid":"aa","vout":0}],"vout":[{"value":0.5,"n":0}]}`,
+		"gettxout:dd":          `{"value":0.5,"confirmations":3}`,
+		"gettxout":             `null`,
+		"getblockcount":        `102`,
+		"getblockhash":         `"b100"`,
+	})
+	defer noTxIndex.Close()
+	wm.WalletClient = NewClient(noTxIndex.URL, "", false)
+	wm.TrackOutboundTx(&openwallet.RawTransaction{TxID: "dd", RawHex: "00"})
+	status, err = wm.GetTransactionStatus("dd")
+	if err != nil || status.Status != TxStatusConfirmed || status.Confirmations != 3 || status.BlockHeight != 100 || status.BlockHash != "b100" {
+		t.Errorf("unexpected confirmed status without txindex: %+v, err: %v", status, err)
+	}
+}
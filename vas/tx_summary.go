@@ -0,0 +1,222 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/assetsadapterstore/vas-adapter/vasTransaction"
+	"github.com/blocktree/openwallet/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//RawTransactionSummary 交易单原文解析结果，用于审核界面展示
+type RawTransactionSummary struct {
+	Signed         bool               `json:"signed"` //所有输入是否已签名
+	Inputs         []*TxSummaryInput  `json:"inputs"`
+	Outputs        []*TxSummaryOutput `json:"outputs"`
+	InputAmount    decimal.Decimal    `json:"inputAmount"`
+	OutputAmount   decimal.Decimal    `json:"outputAmount"`
+	Fees           decimal.Decimal    `json:"fees"`
+	FeeRate        decimal.Decimal    `json:"feeRate"` //每KB手续费
+	VSize          uint64             `json:"vsize"`
+	VSizeEstimated bool               `json:"vsizeEstimated"` //未签名交易单的大小按签名后估算
+	LockTime       uint32             `json:"lockTime"`
+	Replaceable    bool               `json:"replaceable"` //BIP125可追加手续费替换
+	NullData       string             `json:"nullData"`    //OP_RETURN数据的hex
+}
+
+//TxSummaryInput 交易单输入
+type TxSummaryInput struct {
+	TxID     string          `json:"txid"`
+	Vout     uint64          `json:"vout"`
+	Address  string          `json:"address"` //无法还原地址的输入为锁定脚本
+	Amount   decimal.Decimal `json:"amount"`
+	Sequence uint32          `json:"sequence"`
+}
+
+//TxSummaryOutput 交易单输出
+type TxSummaryOutput struct {
+	Address  string          `json:"address"` //无法还原地址的输出为锁定脚本
+	Amount   decimal.Decimal `json:"amount"`
+	IsChange bool            `json:"isChange"` //输出地址属于交易单的资产账户
+	NullData bool            `json:"nullData"`
+}
+
+//DecodeRawTransactionSummary 解析未签名或已签名的交易单原文，输入地址和金额从前置输出还原，
+//输出地址属于资产账户时标记为找零
+func (decoder *TransactionDecoder) DecodeRawTransactionSummary(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) (*RawTransactionSummary, error) {
+
+	var addressPrefix vasTransaction.AddressPrefix
+
+	if decoder.wm.Config.IsTestNet {
+		addressPrefix = TestNetAddressPrefix
+	} else {
+		addressPrefix = MainNetAddressPrefix
+	}
+
+	txBytes, err := hex.DecodeString(rawTx.RawHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction hex data")
+	}
+
+	trx, err := vasTransaction.DecodeRawTransaction(txBytes, decoder.wm.Config.SupportSegWit)
+	if err != nil {
+		return nil, fmt.Errorf("decode transaction failed, err: %v", err)
+	}
+
+	summary := &RawTransactionSummary{
+		Signed:       trx.IsSigned(),
+		Inputs:       make([]*TxSummaryInput, 0, len(trx.Vins)),
+		Outputs:      make([]*TxSummaryOutput, 0, len(trx.Vouts)),
+		InputAmount:  decimal.Zero,
+		OutputAmount: decimal.Zero,
+		LockTime:     trx.GetLockTime(),
+		Replaceable:  trx.IsReplaceable(),
+	}
+
+	carried := rawTx.GetExtParam().Get("prevouts").Array()
+	checkCarried := len(carried) > 0 && decoder.wm.isNodeReachable()
+	for i, vin := range trx.Vins {
+		input := &TxSummaryInput{
+			TxID:     vin.GetTxID(),
+			Vout:     uint64(vin.GetVout()),
+			Sequence: vin.GetSequence(),
+		}
+
+		var prevout *Vout
+		if len(carried) == len(trx.Vins) && carried[i].Get("txid").String() == input.TxID && carried[i].Get("vout").Uint() == input.Vout {
+			prevout = &Vout{
+				ScriptPubKey: carried[i].Get("lockScript").String(),
+				Value:        carried[i].Get("amount").String(),
+			}
+			//节点可用时核对携带的前置输出
+			if checkCarried {
+				err = decoder.wm.checkCarriedPrevout(&TxPrevout{TxID: input.TxID, Vout: input.Vout, LockScript: prevout.ScriptPubKey, Amount: prevout.Value})
+				if err != nil {
+					return nil, fmt.Errorf("prevout of input %d is invalid, err: %v", i, err)
+				}
+			}
+		} else {
+			prevout, err = decoder.wm.getSummaryPrevout(input.TxID, input.Vout)
+			if err != nil {
+				return nil, fmt.Errorf("get prevout of input %d failed, err: %v", i, err)
+			}
+		}
+
+		input.Address = prevout.Addr
+		script, _ := hex.DecodeString(prevout.ScriptPubKey)
+		if address, err := vasTransaction.ScriptPubKeyToAddress(script, addressPrefix); err == nil {
+			input.Address = address
+		} else if len(input.Address) == 0 {
+			input.Address = prevout.ScriptPubKey
+		}
+		input.Amount, _ = decimal.NewFromString(prevout.Value)
+
+		summary.InputAmount = summary.InputAmount.Add(input.Amount)
+		summary.Inputs = append(summary.Inputs, input)
+	}
+
+	nullDataSize := 0
+	for _, out := range trx.Vouts {
+		output := &TxSummaryOutput{
+			Address: out.GetLockScript(),
+			Amount:  decimal.New(int64(out.GetAmount()), -decoder.wm.Decimal()),
+		}
+
+		if data, ok := out.GetNullData(); ok {
+			output.NullData = true
+			summary.NullData = hex.EncodeToString(data)
+			nullDataSize += vasTransaction.NullDataOutputSize(len(data))
+		} else {
+			script, _ := hex.DecodeString(output.Address)
+			if address, err := vasTransaction.ScriptPubKeyToAddress(script, addressPrefix); err == nil {
+				output.Address = address
+				output.IsChange = decoder.isAccountAddress(wrapper, rawTx.Account, address)
+			}
+		}
+
+		summary.OutputAmount = summary.OutputAmount.Add(output.Amount)
+		summary.Outputs = append(summary.Outputs, output)
+	}
+
+	summary.Fees = summary.InputAmount.Sub(summary.OutputAmount)
+
+	if summary.Signed {
+		summary.VSize, err = trx.GetVSize(decoder.wm.Config.SupportSegWit)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		//按EstimateFee的字节估算公式计算，OP_RETURN输出按实际大小计算
+		outputs := len(summary.Outputs)
+		if nullDataSize > 0 {
+			outputs--
+		}
+		summary.VSize = uint64(estimateTxBytes(int64(len(summary.Inputs)), int64(outputs), int64(nullDataSize)))
+		summary.VSizeEstimated = true
+	}
+
+	if summary.VSize > 0 {
+		summary.FeeRate = summary.Fees.Mul(decimal.New(1000, 0)).Div(decimal.New(int64(summary.VSize), 0)).Round(decoder.wm.Decimal())
+	}
+
+	return summary, nil
+}
+
+//getSummaryPrevout 查询输入的前置输出，已花费的输出从前置交易中获取
+func (wm *WalletManager) getSummaryPrevout(txid string, vout uint64) (*Vout, error) {
+
+	prevout, err := wm.GetTxOut(txid, vout)
+	if err == nil && len(prevout.ScriptPubKey) > 0 {
+		return prevout, nil
+	}
+
+	//被交易池中的交易花费的输出，例如追加手续费替换的输入，不包含交易池时仍可查到
+	result, err := wm.WalletClient.Call("gettxout", []interface{}{txid, vout, false})
+	if err == nil && result.Get("scriptPubKey.hex").String() != "" {
+		return newTxVoutByCore(result), nil
+	}
+
+	trx, err := wm.GetTransaction(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, out := range trx.Vouts {
+		if out.N == vout {
+			return out, nil
+		}
+	}
+
+	return nil, fmt.Errorf("prevout %s:%d is not found", txid, vout)
+}
+
+//isAccountAddress 地址是否属于资产账户，账户为空时钱包内的地址都视为属于账户
+func (decoder *TransactionDecoder) isAccountAddress(wrapper openwallet.WalletDAI, account *openwallet.AssetsAccount, address string) bool {
+
+	if wrapper == nil {
+		return false
+	}
+
+	addr, err := wrapper.GetAddress(address)
+	if err != nil || addr == nil {
+		return false
+	}
+
+	return account == nil || addr.AccountID == account.AccountID
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/assetsadapterstore/vas-adapter/vasTransaction"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+func TestDecodeRawTransactionSummary(t *testing.T) {
+
+	wm := NewWalletManager()
+	decoder := NewTransactionDecoder(wm)
+	wrapper := &policyWalletDAI{addresses: map[string]bool{"VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV": true}}
+
+	in := vasTransaction.Vin{TxID: "d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", Vout: 0}
+	outs := []vasTransaction.Vout{
+		{Address: "VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7", Amount: 900000},
+		{Address: "VSaJg2ARstrpqh6GdwfMZF1xBY25xnPEBV", Amount: 50000},
+	}
+
+	emptyTrans, err := vasTransaction.CreateEmptyRawTransactionWithData([]vasTransaction.Vin{in}, outs, []byte("memo"), 0, true, MainNetAddressPrefix)
+	if err != nil {
+		t.Errorf("create empty transaction failed, err: %v", err)
+		return
+	}
+
+	rawTx := &openwallet.RawTransaction{RawHex: emptyTrans}
+	setRawTransactionPrevouts(rawTx, []*Unspent{
+		{TxID: in.TxID, Vout: 0, ScriptPubKey: "76a914d46043209073ad39879356295562d952cd9dae3a88ac", Amount: "0.01"},
+	})
+
+	summary, err := decoder.DecodeRawTransactionSummary(wrapper, rawTx)
+	if err != nil {
+		t.Errorf("decode summary failed, err: %v", err)
+		return
+	}
+	if summary.Signed || !summary.VSizeEstimated || !summary.Replaceable || summary.NullData != hex.EncodeToString([]byte("memo")) {
+		t.Errorf("unexpected summary: %+v", summary)
+		return
+	}
+	if len(summary.Inputs) != 1 || summary.Inputs[0].Address != "VW2AVgjuP7vDNVWzUeW7DPq4isq3NNkLjf" || summary.Inputs[0].Amount.String() != "0.01" {
+		t.Errorf("unexpected summary inputs: %+v", summary.Inputs[0])
+		return
+	}
+	if len(summary.Outputs) != 3 || !summary.Outputs[0].NullData || summary.Outputs[1].IsChange || !summary.Outputs[2].IsChange {
+		t.Errorf("unexpected summary outputs")
+		return
+	}
+	if summary.Fees.String() != "0.0005" || summary.VSize != 180+34*2+10+uint64(vasTransaction.NullDataOutputSize(4)) {
+		t.Errorf("unexpected summary fees: %s, vsize: %d", summary.Fees.String(), summary.VSize)
+		return
+	}
+
+	//已签名交易单按实际大小计算
+	prikey, _ := hex.DecodeString("80bc398d7c4a674daa977566c2e6cd5040520027e57fe806dfaa868df4cc43ab")
+	pubkey, _ := owcrypt.GenPubkey(prikey, owcrypt.ECC_CURVE_SECP256K1)
+	pubkey = owcrypt.PointCompress(pubkey, owcrypt.ECC_CURVE_SECP256K1)
+	_, signedTrans, _ := newCoreSignedTransaction(t, prikey, pubkey)
+
+	rawTx = &openwallet.RawTransaction{RawHex: signedTrans}
+	setRawTransactionPrevouts(rawTx, []*Unspent{
+		{TxID: in.TxID, Vout: 0, ScriptPubKey: "76a914" + hex.EncodeToString(owcrypt.Hash(pubkey, 0, owcrypt.HASH_ALG_HASH160)) + "88ac", Amount: "0.01"},
+	})
+	summary, err = decoder.DecodeRawTransactionSummary(wrapper, rawTx)
+	if err != nil {
+		t.Errorf("decode signed summary failed, err: %v", err)
+		return
+	}
+	if !summary.Signed || summary.VSizeEstimated || summary.VSize != uint64(len(signedTrans)/2) || summary.Replaceable || summary.Fees.String() != "0.001" {
+		t.Errorf("unexpected signed summary: %+v", summary)
+	}
+}
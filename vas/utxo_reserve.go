@@ -0,0 +1,313 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/asdine/storm/q"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/openwallet"
+)
+
+const (
+	//utxo预留状态
+	UTXOReserved = 0 //已被交易单锁定
+	UTXOSpent    = 1 //交易单已广播
+)
+
+//预留记录的写入需要先检查再保存，用进程锁保证原子性
+var utxoReserveLock sync.Mutex
+
+//UTXOReservation utxo预留记录，构建交易单时锁定，避免并发构建的交易单使用相同的utxo
+type UTXOReservation struct {
+	Key           string `storm:"id"` //txid:vout
+	ReservationID string `storm:"index"`
+	AccountID     string `storm:"index"`
+	TxID          string
+	Vout          uint64
+	Status        int
+	SpentTxID     string
+	ExpireTime    int64
+	CreateTime    int64
+}
+
+//IsActive 预留是否有效，超过有效时间后失效，已广播的记录有效时间为交易单的跟踪期限
+func (r *UTXOReservation) IsActive(now int64) bool {
+	return r.ExpireTime > now
+}
+
+func utxoReservationKey(txid string, vout uint64) string {
+	return fmt.Sprintf("%s:%d", txid, vout)
+}
+
+//newUTXOReservationID 以未签名交易单的哈希作为预留编号
+func newUTXOReservationID(rawHex string) string {
+	return hex.EncodeToString(owcrypt.Hash([]byte(rawHex), 0, owcrypt.HASH_ALG_SHA256))
+}
+
+//ReserveUnspents 锁定交易单使用的utxo，已被其他有效预留锁定时返回错误
+//replacesTxID不为空时，允许占用被该交易使用的utxo，用于追加手续费重建交易单
+func (wm *WalletManager) ReserveUnspents(reservationID, accountID, replacesTxID string, unspents []*Unspent) error {
+
+	utxoReserveLock.Lock()
+	defer utxoReserveLock.Unlock()
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	now := time.Now().Unix()
+
+	for _, u := range unspents {
+		var exist UTXOReservation
+		err = db.One("Key", utxoReservationKey(u.TxID, u.Vout), &exist)
+		if err != nil {
+			continue
+		}
+		if exist.ReservationID == reservationID || !exist.IsActive(now) {
+			continue
+		}
+		if len(replacesTxID) > 0 && exist.SpentTxID == replacesTxID {
+			continue
+		}
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "utxo: %s is reserved by other transaction", exist.Key)
+	}
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, u := range unspents {
+		r := &UTXOReservation{
+			Key:           utxoReservationKey(u.TxID, u.Vout),
+			ReservationID: reservationID,
+			AccountID:     accountID,
+			TxID:          u.TxID,
+			Vout:          u.Vout,
+			Status:        UTXOReserved,
+			ExpireTime:    now + int64(wm.Config.UTXOReserveTTL.Seconds()),
+			CreateTime:    now,
+		}
+		err = tx.Save(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//ReleaseUTXOReservation 释放未广播交易单锁定的utxo
+func (wm *WalletManager) ReleaseUTXOReservation(reservationID string) error {
+
+	utxoReserveLock.Lock()
+	defer utxoReserveLock.Unlock()
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.Select(q.Eq("ReservationID", reservationID), q.Eq("Status", UTXOReserved)).Delete(&UTXOReservation{})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	return nil
+}
+
+//MarkUTXOReservationSpent 交易单广播成功后，标记锁定的utxo已花费
+func (wm *WalletManager) MarkUTXOReservationSpent(reservationID, txid string) error {
+
+	utxoReserveLock.Lock()
+	defer utxoReserveLock.Unlock()
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var records []*UTXOReservation
+	err = db.Find("ReservationID", reservationID, &records)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	expireTime := time.Now().Unix() + int64(wm.Config.TxTrackExpire.Seconds())
+	for _, r := range records {
+		r.Status = UTXOSpent
+		r.SpentTxID = txid
+		r.ExpireTime = expireTime
+		err = tx.Save(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//ReleaseSpentUTXOReservation 已广播交易单进入最终状态后，删除其花费的utxo预留记录
+func (wm *WalletManager) ReleaseSpentUTXOReservation(txid string) error {
+
+	utxoReserveLock.Lock()
+	defer utxoReserveLock.Unlock()
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.Select(q.Eq("SpentTxID", txid), q.Eq("Status", UTXOSpent)).Delete(&UTXOReservation{})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	return nil
+}
+
+//PruneUTXOReservations 删除账户已广播但utxo已不在listunspent结果中的预留记录，unspents须为账户全部地址的utxo
+func (wm *WalletManager) PruneUTXOReservations(accountID string, unspents []*Unspent) error {
+
+	utxoReserveLock.Lock()
+	defer utxoReserveLock.Unlock()
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var records []*UTXOReservation
+	err = db.Select(q.Eq("AccountID", accountID), q.Eq("Status", UTXOSpent)).Find(&records)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	listed := make(map[string]bool)
+	for _, u := range unspents {
+		listed[utxoReservationKey(u.TxID, u.Vout)] = true
+	}
+
+	for _, r := range records {
+		if listed[r.Key] {
+			continue
+		}
+		err = db.DeleteStruct(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//filterReservedUnspents 排除被有效预留锁定的utxo，已过期的预留记录同时被清理
+func (wm *WalletManager) filterReservedUnspents(unspents []*Unspent) []*Unspent {
+
+	if len(unspents) == 0 {
+		return unspents
+	}
+
+	utxoReserveLock.Lock()
+	defer utxoReserveLock.Unlock()
+
+	db, err := storm.Open(filepath.Join(wm.Config.DBPath, wm.Config.BlockchainFile))
+	if err != nil {
+		wm.Log.Errorf("open utxo reservation db failed, err: %v", err)
+		return unspents
+	}
+	defer db.Close()
+
+	now := time.Now().Unix()
+	available := make([]*Unspent, 0, len(unspents))
+	for _, u := range unspents {
+		var exist UTXOReservation
+		err = db.One("Key", utxoReservationKey(u.TxID, u.Vout), &exist)
+		if err == nil {
+			if exist.IsActive(now) {
+				continue
+			}
+			db.DeleteStruct(&exist)
+		}
+		available = append(available, u)
+	}
+
+	return available
+}
+
+//reserveRawTransactionUnspents 锁定交易单使用的utxo，预留编号记录到扩展参数reservationID
+func (decoder *TransactionDecoder) reserveRawTransactionUnspents(rawTx *openwallet.RawTransaction, usedUTXO []*Unspent) error {
+
+	reservationID := newUTXOReservationID(rawTx.RawHex)
+	replacesTxID := rawTx.GetExtParam().Get("replacesTxID").String()
+
+	err := decoder.wm.ReserveUnspents(reservationID, rawTx.Account.AccountID, replacesTxID, usedUTXO)
+	if err != nil {
+		return err
+	}
+
+	return rawTx.SetExtParam("reservationID", reservationID)
+}
+
+//CancelRawTransaction 取消未广播的交易单，释放锁定的utxo
+func (decoder *TransactionDecoder) CancelRawTransaction(rawTx *openwallet.RawTransaction) error {
+
+	reservationID := rawTx.GetExtParam().Get("reservationID").String()
+	if len(reservationID) == 0 {
+		return nil
+	}
+
+	if rawTx.IsSubmit {
+		return fmt.Errorf("transaction has been submitted")
+	}
+
+	return decoder.wm.ReleaseUTXOReservation(reservationID)
+}
+
+//cancelRawTransactions 释放已构建但不再使用的交易单锁定的utxo
+func (decoder *TransactionDecoder) cancelRawTransactions(rawTxs []*openwallet.RawTransaction) {
+	for _, rawTx := range rawTxs {
+		if err := decoder.CancelRawTransaction(rawTx); err != nil {
+			decoder.wm.Log.Errorf("cancel raw transaction failed, err: %v", err)
+		}
+	}
+}
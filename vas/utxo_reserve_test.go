@@ -0,0 +1,113 @@
+/*
+ * Copyright 2018 The openwallet Authors
+ * This file is part of the openwallet library.
+ *
+ * The openwallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The openwallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package vas
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestUTXOReservation(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "utxo_reserve")
+	if err != nil {
+		t.Errorf("create temp dir failed, err: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	wm := NewWalletManager()
+	wm.Config.DBPath = dir
+
+	utxos := []*Unspent{
+		{TxID: "aa", Vout: 0, Amount: "1"},
+		{TxID: "aa", Vout: 1, Amount: "1"},
+		{TxID: "bb", Vout: 0, Amount: "1"},
+	}
+
+	err = wm.ReserveUnspents("tx1", "account", "", utxos[:2])
+	if err != nil {
+		t.Errorf("reserve unspents failed, err: %v", err)
+		return
+	}
+
+	//并发构建的交易单不能使用已锁定的utxo
+	err = wm.ReserveUnspents("tx2", "account", "", utxos[1:])
+	if err == nil {
+		t.Errorf("reserved utxo should not be reserved again")
+		return
+	}
+
+	available := wm.filterReservedUnspents(utxos)
+	if len(available) != 1 || available[0].TxID != "bb" {
+		t.Errorf("filter reserved unspents failed")
+		return
+	}
+
+	//取消后释放
+	err = wm.ReleaseUTXOReservation("tx1")
+	if err != nil {
+		t.Errorf("release reservation failed, err: %v", err)
+		return
+	}
+
+	if available = wm.filterReservedUnspents(utxos); len(available) != 3 {
+		t.Errorf("released utxo should be available")
+		return
+	}
+
+	//广播后在交易单跟踪期限内锁定
+	wm.Config.UTXOReserveTTL = 0
+	wm.ReserveUnspents("tx3", "account", "", utxos[:1])
+	wm.MarkUTXOReservationSpent("tx3", "txid3")
+	if available = wm.filterReservedUnspents(utxos); len(available) != 2 {
+		t.Errorf("spent utxo should be filtered")
+		return
+	}
+
+	//追加手续费可以使用被替换交易的utxo
+	err = wm.ReserveUnspents("tx4", "account", "txid3", utxos[:1])
+	if err != nil {
+		t.Errorf("replacement should reuse utxo, err: %v", err)
+		return
+	}
+
+	//交易单确认后删除预留记录
+	wm.Config.UTXOReserveTTL = time.Hour
+	wm.ReserveUnspents("tx5", "account", "", utxos[1:2])
+	wm.MarkUTXOReservationSpent("tx5", "txid5")
+	wm.ReleaseSpentUTXOReservation("txid5")
+	if available = wm.filterReservedUnspents(utxos[1:2]); len(available) != 1 {
+		t.Errorf("confirmed tx should release its utxo")
+		return
+	}
+
+	//utxo已不在listunspent中，删除已广播的预留记录，未广播的保留
+	wm.ReserveUnspents("tx6", "account", "", utxos[1:2])
+	wm.MarkUTXOReservationSpent("tx6", "txid6")
+	wm.ReserveUnspents("tx7", "account", "", utxos[2:])
+	err = wm.PruneUTXOReservations("account", nil)
+	if err != nil {
+		t.Errorf("prune reservations failed, err: %v", err)
+		return
+	}
+	if available = wm.filterReservedUnspents(utxos[1:]); len(available) != 1 || available[0].TxID != "aa" {
+		t.Errorf("pruned spent reservation should be removed")
+		return
+	}
+}
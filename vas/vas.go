@@ -16,10 +16,12 @@
 package vas
 
 import (
+	"fmt"
 	"github.com/astaxie/beego/config"
 	"github.com/blocktree/openwallet/log"
 	"github.com/blocktree/openwallet/openwallet"
 	"github.com/shopspring/decimal"
+	"time"
 )
 
 //FullName 币种全名
@@ -52,6 +54,11 @@ func (wm *WalletManager) GetTransactionDecoder() openwallet.TransactionDecoder {
 	return wm.TxDecoder
 }
 
+//GetSmartContractDecoder 获取智能合约解析器
+func (wm *WalletManager) GetSmartContractDecoder() openwallet.SmartContractDecoder {
+	return wm.ContractDecoder
+}
+
 //GetBlockScanner 获取区块链
 func (wm *WalletManager) GetBlockScanner() openwallet.BlockScanner {
 
@@ -73,24 +80,133 @@ func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
 	wm.Config.RpcPassword = c.String("rpcPassword")
 	wm.Config.IsTestNet, _ = c.Bool("isTestNet")
 	wm.Config.SupportSegWit, _ = c.Bool("supportSegWit")
-	wm.Config.OmniTransferCost = c.String("omniTransferCost")
+	wm.Config.OmniTransferCost = c.DefaultString("omniTransferCost", wm.Config.OmniTransferCost)
 	wm.Config.OmniCoreAPI = c.String("omniCoreAPI")
 	wm.Config.OmniRPCUser = c.String("omniRPCUser")
 	wm.Config.OmniRPCPassword = c.String("omniRPCPassword")
 	wm.Config.OmniSupport, _ = c.Bool("omniSupport")
+	//格式：propertyID:decimals，多个用;分隔，例如31:8;3:0
+	for _, item := range c.Strings("omniPropertyDecimals") {
+		var propertyID, decimals uint64
+		if _, err := fmt.Sscanf(item, "%d:%d", &propertyID, &decimals); err == nil {
+			wm.Config.OmniPropertyDecimals[propertyID] = int32(decimals)
+		}
+	}
 	wm.Config.MinFees, _ = decimal.NewFromString(c.String("minFees"))
 	wm.Config.MinFees = wm.Config.MinFees.Round(wm.Decimal())
 	wm.Config.DataDir = c.String("dataDir")
+	wm.Config.CoinbaseMaturity = uint64(c.DefaultInt64("coinbaseMaturity", int64(wm.Config.CoinbaseMaturity)))
+	wm.Config.TxOrderPolicy = c.DefaultString("txOrderPolicy", wm.Config.TxOrderPolicy)
+	wm.Config.MaxTxInputs = c.DefaultInt("maxTxInputs", wm.Config.MaxTxInputs)
+	wm.Config.MaxTxWeight = c.DefaultInt64("maxTxWeight", wm.Config.MaxTxWeight)
+	wm.Config.SumAddress = c.String("sumAddress")
+	if threshold, err := decimal.NewFromString(c.String("threshold")); err == nil {
+		wm.Config.Threshold = threshold
+	}
+	wm.Config.SumUTXOThreshold = c.DefaultInt("sumUTXOThreshold", wm.Config.SumUTXOThreshold)
+	if cycleSeconds := c.DefaultInt64("cycleSeconds", 0); cycleSeconds > 0 {
+		wm.Config.CycleSeconds = time.Duration(cycleSeconds) * time.Second
+	}
+	wm.Config.MaxSumFeeRate, _ = decimal.NewFromString(c.DefaultString("maxSumFeeRate", "0"))
+	wm.Config.SumConfirms = uint64(c.DefaultInt64("sumConfirms", int64(wm.Config.SumConfirms)))
+	if maxFeeRate, err := decimal.NewFromString(c.String("maxFeeRate")); err == nil {
+		wm.Config.MaxFeeRate = maxFeeRate
+	}
+	if incrementalRelayFee, err := decimal.NewFromString(c.String("incrementalRelayFee")); err == nil {
+		wm.Config.IncrementalRelayFee = incrementalRelayFee
+	}
+	if dustLimit, err := decimal.NewFromString(c.String("dustLimit")); err == nil {
+		wm.Config.DustLimit = dustLimit
+	}
+	//omni参考输出的金额，低于粉尘限额的输出无法广播
+	if transferCost, err := decimal.NewFromString(wm.Config.OmniTransferCost); err != nil || !transferCost.GreaterThan(decimal.Zero) || transferCost.LessThan(wm.Config.DustLimit) {
+		return fmt.Errorf("omni transfer cost: %s must be greater than 0 and not less than dust limit", wm.Config.OmniTransferCost)
+	}
+	if broadcastAPIs := c.Strings("broadcastAPIs"); len(broadcastAPIs) > 0 && len(broadcastAPIs[0]) > 0 {
+		wm.Config.BroadcastAPIs = broadcastAPIs
+	}
+	if trackCycle := c.DefaultInt64("txTrackCycleSeconds", 0); trackCycle > 0 {
+		wm.Config.TxTrackCycle = time.Duration(trackCycle) * time.Second
+	}
+	if trackExpire := c.DefaultInt64("txTrackExpireSeconds", 0); trackExpire > 0 {
+		wm.Config.TxTrackExpire = time.Duration(trackExpire) * time.Second
+	}
+	if rebroadcastInterval := c.DefaultInt64("txRebroadcastSeconds", 0); rebroadcastInterval > 0 {
+		wm.Config.TxRebroadcastInterval = time.Duration(rebroadcastInterval) * time.Second
+	}
+	wm.Config.TxTrackConfirms = uint64(c.DefaultInt64("txTrackConfirms", int64(wm.Config.TxTrackConfirms)))
+	if depositExpire := c.DefaultInt64("pendingDepositExpireSeconds", 0); depositExpire > 0 {
+		wm.Config.PendingDepositExpire = time.Duration(depositExpire) * time.Second
+	}
+
+	//签名策略，配置了策略文件时以文件为准
+	if policyFile := c.String("signPolicyFile"); len(policyFile) > 0 {
+		policy, err := LoadSignPolicyFile(policyFile)
+		if err != nil {
+			return err
+		}
+		wm.Config.SignPolicy = policy
+	} else {
+		policy := NewSignPolicy()
+		policy.MaxTxAmount, _ = decimal.NewFromString(c.DefaultString("policyMaxTxAmount", "0"))
+		policy.MaxDailyAmount, _ = decimal.NewFromString(c.DefaultString("policyMaxDailyAmount", "0"))
+		policy.MaxFeeRate, _ = decimal.NewFromString(c.DefaultString("policyMaxFeeRate", "0"))
+		policy.MaxOutputs = c.DefaultInt("policyMaxOutputs", 0)
+		if whitelist := c.Strings("policyWhitelist"); len(whitelist) > 0 && len(whitelist[0]) > 0 {
+			policy.Whitelist = whitelist
+		}
+		if blacklist := c.Strings("policyBlacklist"); len(blacklist) > 0 && len(blacklist[0]) > 0 {
+			policy.Blacklist = blacklist
+		}
+		wm.Config.SignPolicy = policy
+	}
+	if reserveTTL := c.DefaultInt64("utxoReserveTTL", 0); reserveTTL > 0 {
+		wm.Config.UTXOReserveTTL = time.Duration(reserveTTL) * time.Second
+	}
+
+	//签名器，远程签名时私钥不经过适配器
+	wm.Config.SignerType = c.DefaultString("signerType", wm.Config.SignerType)
+	wm.Config.RemoteSignerAPI = c.String("remoteSignerAPI")
+	wm.Config.RemoteSignerToken = c.String("remoteSignerToken")
+	if signerTimeout := c.DefaultInt64("remoteSignerTimeoutSeconds", 0); signerTimeout > 0 {
+		wm.Config.RemoteSignerTimeout = time.Duration(signerTimeout) * time.Second
+	}
+	wm.Config.CoreWalletWatchOnly = c.DefaultBool("coreWalletWatchOnly", wm.Config.CoreWalletWatchOnly)
+	wm.Config.WalletPassword = c.String("walletPassword")
+	if coreAccounts := c.Strings("coreWalletAccounts"); len(coreAccounts) > 0 && len(coreAccounts[0]) > 0 {
+		wm.Config.CoreWalletAccounts = coreAccounts
+	}
+	if unlockTime := c.DefaultInt64("walletUnlockSeconds", 0); unlockTime > 0 {
+		wm.Config.WalletUnlockTime = time.Duration(unlockTime) * time.Second
+	}
+	switch wm.Config.SignerType {
+	case SignerTypeHD:
+		wm.Signer = NewHDSigner()
+	case SignerTypeCore:
+		wm.Signer = NewCoreWalletSigner(wm)
+	case SignerTypeRemote:
+		if len(wm.Config.RemoteSignerAPI) == 0 {
+			return fmt.Errorf("remote signer API is not setup")
+		}
+		wm.Signer = NewRemoteSigner(wm.Config.RemoteSignerAPI, wm.Config.RemoteSignerToken, wm.Config.RemoteSignerTimeout)
+	default:
+		return fmt.Errorf("unknown signer type: %s", wm.Config.SignerType)
+	}
 
 	//数据文件夹
 	wm.Config.makeDataDir()
 
 	token := BasicAuth(wm.Config.RpcUser, wm.Config.RpcPassword)
-	//omniToken := BasicAuth(wm.Config.OmniRPCUser, wm.Config.OmniRPCPassword)
 
 	wm.WalletClient = NewClient(wm.Config.ServerAPI, token, false)
 
-	//wm.OnmiClient = NewClient(wm.Config.OmniCoreAPI, omniToken, false)
+	//未配置OmniCore API时，使用节点客户端查询omni数据
+	if len(wm.Config.OmniCoreAPI) > 0 {
+		omniToken := BasicAuth(wm.Config.OmniRPCUser, wm.Config.OmniRPCPassword)
+		wm.OmniClient = NewClient(wm.Config.OmniCoreAPI, omniToken, false)
+	} else {
+		wm.OmniClient = wm.WalletClient
+	}
 
 	return nil
 }
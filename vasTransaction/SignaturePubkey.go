@@ -31,6 +31,56 @@ func serilizeS(sig []byte) []byte {
 	return sig
 }
 
+// IsLowS 签名(r||s)的s值是否不大于曲线阶的一半，高S签名不能通过节点的标准检查
+func IsLowS(sig []byte) bool {
+	if len(sig) != 64 {
+		return false
+	}
+	numS := new(big.Int).SetBytes(sig[32:])
+	numHalfOrder := new(big.Int).SetBytes(HalfCurveOrder)
+	return numS.Sign() > 0 && numS.Cmp(numHalfOrder) <= 0
+}
+
+// checkStrictDER 按BIP66检查DER编码的签名，sig末尾包含签名类型字节
+func checkStrictDER(sig []byte) error {
+	if len(sig) < 9 || len(sig) > 73 {
+		return errors.New("Invalid DER signature length!")
+	}
+	if sig[0] != 0x30 || int(sig[1]) != len(sig)-3 {
+		return errors.New("Invalid DER signature sequence!")
+	}
+
+	rLen := int(sig[3])
+	if 5+rLen >= len(sig) {
+		return errors.New("Invalid DER signature r length!")
+	}
+	sLen := int(sig[5+rLen])
+	if rLen+sLen+7 != len(sig) {
+		return errors.New("Invalid DER signature s length!")
+	}
+
+	if sig[2] != 0x02 || rLen == 0 {
+		return errors.New("Invalid DER signature r data!")
+	}
+	if sig[4]&0x80 != 0 {
+		return errors.New("Negative r in DER signature!")
+	}
+	if rLen > 1 && sig[4] == 0x00 && sig[5]&0x80 == 0 {
+		return errors.New("Non-canonical r padding in DER signature!")
+	}
+
+	if sig[rLen+4] != 0x02 || sLen == 0 {
+		return errors.New("Invalid DER signature s data!")
+	}
+	if sig[rLen+6]&0x80 != 0 {
+		return errors.New("Negative s in DER signature!")
+	}
+	if sLen > 1 && sig[rLen+6] == 0x00 && sig[rLen+7]&0x80 == 0 {
+		return errors.New("Non-canonical s padding in DER signature!")
+	}
+	return nil
+}
+
 func calcSignaturePubkey(txHash, prikey []byte) (*SignaturePubkey, error) {
 	if txHash == nil || len(txHash) != 32 || prikey == nil || len(prikey) != 32 {
 		return nil, errors.New("Transaction hash or private key data error!")
@@ -148,6 +198,10 @@ func decodeSignatureFromScript(script []byte) ([]byte, byte, error) {
 		return nil, 0, errors.New("Invalid script data!")
 	}
 
+	if err := checkStrictDER(script[1:]); err != nil {
+		return nil, 0, err
+	}
+
 	index++
 	if index+1 > limit {
 		return nil, 0, errors.New("Invalid script data!")
@@ -273,6 +327,13 @@ func decodeFromScriptBytes(script []byte) (*SignaturePubkey, byte, error) {
 	sigLen := script[index]
 	index++
 
+	if index+int(sigLen) > limit {
+		return nil, 0, errors.New("Invalid script data!")
+	}
+	if err := checkStrictDER(script[index : index+int(sigLen)]); err != nil {
+		return nil, 0, err
+	}
+
 	if index+1 > limit {
 		return nil, 0, errors.New("Invalid script data!")
 	}
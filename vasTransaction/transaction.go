@@ -3,6 +3,7 @@ package vasTransaction
 import (
 	"encoding/hex"
 	"errors"
+	"fmt"
 
 	"github.com/blocktree/go-owcrypt"
 )
@@ -41,6 +42,52 @@ func CreateEmptyRawTransaction(vins []Vin, vouts []Vout, lockTime uint32, replac
 	return hex.EncodeToString(txBytes), nil
 }
 
+// CreateEmptyRawTransactionWithData 创建带OP_RETURN数据输出的空交易单，数据输出排在第一位
+func CreateEmptyRawTransactionWithData(vins []Vin, vouts []Vout, data []byte, lockTime uint32, replaceable bool, addressPrefix AddressPrefix) (string, error) {
+	return CreateEmptyRawTransactionWithDataAt(vins, vouts, data, 0, lockTime, replaceable, addressPrefix)
+}
+
+// CreateEmptyRawTransactionWithDataAt 创建带OP_RETURN数据输出的空交易单，数据输出插入到dataIndex位置
+func CreateEmptyRawTransactionWithDataAt(vins []Vin, vouts []Vout, data []byte, dataIndex int, lockTime uint32, replaceable bool, addressPrefix AddressPrefix) (string, error) {
+
+	emptyTrans, err := newEmptyTransaction(vins, vouts, lockTime, replaceable, addressPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) > 0 {
+		if dataIndex < 0 || dataIndex > len(emptyTrans.Vouts) {
+			return "", errors.New("Invalid index for OP_RETURN output!")
+		}
+		dataOut, err := newNullDataTxOut(data)
+		if err != nil {
+			return "", err
+		}
+		txOut := make([]TxOut, 0, len(emptyTrans.Vouts)+1)
+		txOut = append(txOut, emptyTrans.Vouts[:dataIndex]...)
+		txOut = append(txOut, *dataOut)
+		txOut = append(txOut, emptyTrans.Vouts[dataIndex:]...)
+		emptyTrans.Vouts = txOut
+	}
+
+	txBytes, err := emptyTrans.encodeToBytes(false)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(txBytes), nil
+}
+
+// NullDataOutputSize 计算OP_RETURN数据输出序列化后的字节数
+func NullDataOutputSize(dataLen int) int {
+	//金额8字节 + 脚本长度1字节 + OP_RETURN + 压栈操作码
+	size := 8 + 1 + 1 + 1 + dataLen
+	if dataLen >= int(OpPushData1) {
+		size++
+	}
+	return size
+}
+
 func CreateRawTransactionHashForSig(txHex string, unlockData []TxUnlock, SegwitON bool, addressPrefix AddressPrefix) ([]TxHash, error) {
 	txBytes, err := hex.DecodeString(txHex)
 	if err != nil {
@@ -139,6 +186,14 @@ func InsertSignatureIntoEmptyTransaction(txHex string, txHashes []TxHash, unlock
 				return "", err
 			}
 			emptyTrans.Vins[i].scriptSig = script
+		} else if inType == TypeTimeLock {
+			emptyTrans.Vins[i].inType = int(inType)
+			emptyTrans.Vins[i].scriptPub = nil
+			script, err := txHashes[i].encodeTimeLockToScript(redeem)
+			if err != nil {
+				return "", err
+			}
+			emptyTrans.Vins[i].scriptSig = script
 		} else if inType == TypeMultiSig {
 			emptyTrans.Vins[i].inType = int(inType)
 			if segwit {
@@ -166,32 +221,71 @@ func InsertSignatureIntoEmptyTransaction(txHex string, txHashes []TxHash, unlock
 }
 
 func VerifyRawTransaction(txHex string, unlockData []TxUnlock, SegwitON bool, addressPrefix AddressPrefix) bool {
+	return VerifyRawTransactionWithError(txHex, unlockData, SegwitON, addressPrefix) == nil
+}
+
+// VerifyError 交易单验证失败的输入序号和原因，Index为-1时表示交易单整体错误
+type VerifyError struct {
+	Index  int
+	Reason string
+}
+
+func (e *VerifyError) Error() string {
+	if e.Index < 0 {
+		return e.Reason
+	}
+	return fmt.Sprintf("Input %d: %s", e.Index, e.Reason)
+}
+
+func newVerifyError(index int, reason string) *VerifyError {
+	return &VerifyError{Index: index, Reason: reason}
+}
+
+// VerifyRawTransactionWithError 验证已签名交易单，签名须为BIP66严格DER编码且为低S值，失败时返回*VerifyError
+func VerifyRawTransactionWithError(txHex string, unlockData []TxUnlock, SegwitON bool, addressPrefix AddressPrefix) error {
 	txBytes, err := hex.DecodeString(txHex)
 	if err != nil {
-		return false
+		return newVerifyError(-1, "Invalid transaction hex string!")
 	}
 
 	signedTrans, err := DecodeRawTransaction(txBytes, SegwitON)
 	if err != nil {
-		return false
+		return newVerifyError(-1, err.Error())
 	}
 
 	if len(signedTrans.Vins) != len(unlockData) {
-		return false
+		return newVerifyError(-1, "The number of transaction inputs and the unlock data are not match!")
 	}
 
 	emptyTrans := signedTrans.cloneEmpty()
 
 	txHash, err := emptyTrans.getHashesForSig(unlockData, SegwitON, addressPrefix)
 	if err != nil {
-		return false
+		return newVerifyError(-1, err.Error())
 	}
 
 	for i := 0; i < len(signedTrans.Vins); i++ {
-		if signedTrans.Vins[i].inType == TypeP2PKH || signedTrans.Vins[i].inType == TypeP2WPKH || signedTrans.Vins[i].inType == TypeBech32 {
+		_, redeem, inType, err := checkScriptType(unlockData[i].LockScript, unlockData[i].RedeemScript)
+		if err != nil {
+			return newVerifyError(i, err.Error())
+		}
+		if inType == TypeTimeLock {
+			//非隔离见证交易单中，时间锁输入解析时无法与多重签名区分
+			script := signedTrans.Vins[i].scriptSig
+			if signedTrans.Vins[i].inType == TypeMultiSig {
+				script = signedTrans.Vins[i].scriptMulti
+			}
+			sig, sigType, err := decodeTimeLockScript(script)
+			if err != nil {
+				return newVerifyError(i, err.Error())
+			}
+			_, pubkey, _ := parseTimeLockRedeemScript(redeem)
+			txHash[i].Normal.SigPub = SignaturePubkey{sig, pubkey}
+			txHash[i].Normal.SigType = sigType
+		} else if signedTrans.Vins[i].inType == TypeP2PKH || signedTrans.Vins[i].inType == TypeP2WPKH || signedTrans.Vins[i].inType == TypeBech32 {
 			sigpub, sigType, err := decodeFromScriptBytes(signedTrans.Vins[i].scriptSig)
 			if err != nil {
-				return false
+				return newVerifyError(i, err.Error())
 			}
 
 			txHash[i].Normal.SigPub = *sigpub
@@ -199,7 +293,7 @@ func VerifyRawTransaction(txHex string, unlockData []TxUnlock, SegwitON bool, ad
 		} else if signedTrans.Vins[i].inType == TypeMultiSig {
 			sigpub, sigType, err := decodeMultiBytes(signedTrans.Vins[i].scriptMulti)
 			if err != nil {
-				return false
+				return newVerifyError(i, err.Error())
 			}
 			for j := 0; j < len(sigpub); j++ {
 				txHash[i].Multi[j].SigPub = sigpub[j]
@@ -208,28 +302,63 @@ func VerifyRawTransaction(txHex string, unlockData []TxUnlock, SegwitON bool, ad
 		}
 	}
 
-	for _, t := range txHash {
+	for i, t := range txHash {
 		th, _ := hex.DecodeString(t.Hash)
 		if t.NRequired == 0 {
+			if !IsLowS(t.Normal.SigPub.Signature) {
+				return newVerifyError(i, "Signature is not low S!")
+			}
 			pubkey := owcrypt.PointDecompress(t.Normal.SigPub.Pubkey, owcrypt.ECC_CURVE_SECP256K1)[1:]
 			if owcrypt.Verify(pubkey, nil, 0, th, 32, t.Normal.SigPub.Signature, owcrypt.ECC_CURVE_SECP256K1) != owcrypt.SUCCESS {
-				return false
+				return newVerifyError(i, "Signature verify failed!")
 			}
 		} else {
 			count := 0
-			for i := 0; i < int(t.NRequired); i++ {
+			for k := 0; k < int(t.NRequired); k++ {
+				if !IsLowS(t.Multi[k].SigPub.Signature) {
+					return newVerifyError(i, "Signature is not low S!")
+				}
 				for j := count; j < len(t.Multi); j++ {
 					pubkey := owcrypt.PointDecompress(t.Multi[j].SigPub.Pubkey, owcrypt.ECC_CURVE_SECP256K1)[1:]
-					if owcrypt.Verify(pubkey, nil, 0, th, 32, t.Multi[i].SigPub.Signature, owcrypt.ECC_CURVE_SECP256K1) == owcrypt.SUCCESS {
+					if owcrypt.Verify(pubkey, nil, 0, th, 32, t.Multi[k].SigPub.Signature, owcrypt.ECC_CURVE_SECP256K1) == owcrypt.SUCCESS {
 						count++
 						break
 					}
 				}
 			}
 			if count != int(t.NRequired) {
-				return false
+				return newVerifyError(i, "Multisig signatures verify failed!")
 			}
 		}
 	}
-	return true
+	return nil
+}
+
+// ExtractSignatures 解析已签名交易单中单签输入的签名(r||s)和公钥，多重签名及时间锁等输入对应位置为nil
+func ExtractSignatures(txHex string, SegwitON bool) ([]*SignaturePubkey, error) {
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, errors.New("Invalid transaction hex data!")
+	}
+
+	signedTrans, err := DecodeRawTransaction(txBytes, SegwitON)
+	if err != nil {
+		return nil, err
+	}
+
+	sigPubs := make([]*SignaturePubkey, len(signedTrans.Vins))
+	for i, in := range signedTrans.Vins {
+		if in.inType != TypeP2PKH && in.inType != TypeP2WPKH && in.inType != TypeBech32 {
+			continue
+		}
+		if len(in.scriptSig) == 0 {
+			continue
+		}
+		sigPub, _, err := decodeFromScriptBytes(in.scriptSig)
+		if err != nil {
+			return nil, newVerifyError(i, err.Error())
+		}
+		sigPubs[i] = sigPub
+	}
+	return sigPubs, nil
 }
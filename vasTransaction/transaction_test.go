@@ -3,7 +3,11 @@ package vasTransaction
 import (
 	"encoding/hex"
 	"fmt"
+	"math/big"
+	"strings"
 	"testing"
+
+	owcrypt "github.com/blocktree/go-owcrypt"
 )
 
 //案例一：
@@ -1670,3 +1674,261 @@ func Test_LTC_bech32_as_output(t *testing.T) {
 		t.Error("验证失败!")
 	}
 }
+
+//案例十二：
+//输入为单个 P2PKH，输出附带 OP_RETURN 数据
+func Test_case12(t *testing.T) {
+	// 前置交易ID和UTXO对应的索引
+	in := Vin{"d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", uint32(0)}
+	// 目标地址和发送金额
+	out := Vout{"VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7", uint64(900000)}
+	// 附带数据
+	data := []byte("payment-ref-001")
+
+	addressPrefix := AddressPrefix{[]byte{0x46}, []byte{0x05}, nil, "vas"}
+
+	///////构建空交易单
+	emptyTrans, err := CreateEmptyRawTransactionWithData([]Vin{in}, []Vout{out}, data, 0, false, addressPrefix)
+	if err != nil {
+		t.Errorf("构建空交易单失败: %v", err)
+		return
+	}
+
+	script := "6a" + fmt.Sprintf("%02x", len(data)) + hex.EncodeToString(data)
+	if !strings.Contains(emptyTrans, fmt.Sprintf("0000000000000000%02x%s", len(script)/2, script)) {
+		t.Error("OP_RETURN输出不正确")
+		return
+	}
+
+	// 超过长度限制的数据
+	_, err = CreateEmptyRawTransactionWithData([]Vin{in}, []Vout{out}, make([]byte, MaxDataCarrierSize+1), 0, false, addressPrefix)
+	if err == nil {
+		t.Error("超长数据应构建失败")
+		return
+	}
+
+	inLock := "76a914d46043209073ad39879356295562d952cd9dae3a88ac"
+	unlockData := TxUnlock{inLock, "", 0, SigHashAll}
+	segwit := false
+
+	/////////计算待签名交易单哈希
+	transHash, err := CreateRawTransactionHashForSig(emptyTrans, []TxUnlock{unlockData}, segwit, addressPrefix)
+	if err != nil {
+		t.Errorf("创建待签交易单哈希失败: %v", err)
+		return
+	}
+
+	inPrikey := []byte{0x80, 0xbc, 0x39, 0x8d, 0x7c, 0x4a, 0x67, 0x4d, 0xaa, 0x97, 0x75, 0x66, 0xc2, 0xe6, 0xcd, 0x50, 0x40, 0x52, 0x00, 0x27, 0xe5, 0x7f, 0xe8, 0x06, 0xdf, 0xaa, 0x86, 0x8d, 0xf4, 0xcc, 0x43, 0xab}
+
+	//签名
+	sigPub, err := SignRawTransactionHash(transHash[0].GetTxHashHex(), inPrikey)
+	if err != nil {
+		t.Errorf("hash签名失败: %v", err)
+		return
+	}
+	transHash[0].Normal.SigPub = *sigPub
+
+	//交易单合并
+	signedTrans, err := InsertSignatureIntoEmptyTransaction(emptyTrans, transHash, []TxUnlock{unlockData}, segwit)
+	if err != nil {
+		t.Errorf("插入交易单失败: %v", err)
+		return
+	}
+
+	// 验证交易单
+	pass := VerifyRawTransaction(signedTrans, []TxUnlock{unlockData}, segwit, addressPrefix)
+	if !pass {
+		t.Error("验证失败!")
+	}
+}
+
+//案例十三：
+//输入为 CLTV 绝对时间锁和 CSV 相对时间锁的 P2SH
+func Test_case13(t *testing.T) {
+	addressPrefix := AddressPrefix{[]byte{0x46}, []byte{0x05}, nil, "vas"}
+
+	inPrikey := []byte{0x80, 0xbc, 0x39, 0x8d, 0x7c, 0x4a, 0x67, 0x4d, 0xaa, 0x97, 0x75, 0x66, 0xc2, 0xe6, 0xcd, 0x50, 0x40, 0x52, 0x00, 0x27, 0xe5, 0x7f, 0xe8, 0x06, 0xdf, 0xaa, 0x86, 0x8d, 0xf4, 0xcc, 0x43, 0xab}
+	pubkey, _ := owcrypt.GenPubkey(inPrikey, owcrypt.ECC_CURVE_SECP256K1)
+	pubkey = owcrypt.PointCompress(pubkey, owcrypt.ECC_CURVE_SECP256K1)
+	signAddress := EncodeCheck([]byte{0x46}, owcrypt.Hash(pubkey, 0, owcrypt.HASH_ALG_HASH160))
+
+	csvSequence, err := NewRelativeLockSequence(1024, true)
+	if err != nil || csvSequence != SequenceLockTimeTypeFlag|2 {
+		t.Errorf("相对时间锁sequence错误: %x, %v", csvSequence, err)
+		return
+	}
+
+	//隔离见证交易单中同时花费P2SH-P2WPKH输入
+	witnessRedeem := "0014" + hex.EncodeToString(owcrypt.Hash(pubkey, 0, owcrypt.HASH_ALG_HASH160))
+	witnessRedeemBytes, _ := hex.DecodeString(witnessRedeem)
+	witnessLock := "a914" + hex.EncodeToString(owcrypt.Hash(witnessRedeemBytes, 0, owcrypt.HASH_ALG_HASH160)) + "87"
+
+	tests := []struct {
+		name     string
+		lockTime uint32
+		sequence uint32
+		segwit   bool
+		create   func() (string, string, error)
+	}{
+		{"CLTV", 1000, 0, false, func() (string, string, error) { return CreateCLTVAddress(1000, pubkey, addressPrefix) }},
+		{"CSV", 0, csvSequence, false, func() (string, string, error) { return CreateCSVAddress(csvSequence, pubkey, addressPrefix) }},
+		{"CLTV segwit", 1000, 0, true, func() (string, string, error) { return CreateCLTVAddress(1000, pubkey, addressPrefix) }},
+	}
+
+	for _, test := range tests {
+		address, redeem, err := test.create()
+		if err != nil {
+			t.Errorf("%s 创建时间锁地址失败: %v", test.name, err)
+			continue
+		}
+		fmt.Println(test.name, "时间锁地址:", address, "赎回脚本:", redeem)
+
+		_, hash, _ := DecodeCheck(address)
+		inLock := "a914" + hex.EncodeToString(hash) + "87"
+
+		vins := []Vin{{"d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", uint32(0)}}
+		unlockData := []TxUnlock{{inLock, redeem, 0, SigHashAll}}
+		if test.segwit {
+			vins = append(vins, Vin{"29b9a8f8a23f63f7604efc4c6aa1d6ae2e561ccc56131a81d167ccc3d5948884", uint32(0)})
+			unlockData = append(unlockData, TxUnlock{witnessLock, witnessRedeem, 100000, SigHashAll})
+		}
+		out := Vout{"VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7", uint64(900000)}
+
+		emptyTrans, err := CreateEmptyRawTransaction(vins, []Vout{out}, test.lockTime, false, addressPrefix)
+		if err != nil {
+			t.Errorf("%s 构建空交易单失败: %v", test.name, err)
+			continue
+		}
+
+		if test.sequence != 0 {
+			emptyTrans, err = SetInputSequence(emptyTrans, 0, test.sequence)
+			if err != nil {
+				t.Errorf("%s 设置sequence失败: %v", test.name, err)
+				continue
+			}
+			if emptyTrans[:8] != "02000000" {
+				t.Errorf("%s 交易版本未升级", test.name)
+				continue
+			}
+		}
+
+		transHash, err := CreateRawTransactionHashForSig(emptyTrans, unlockData, test.segwit, addressPrefix)
+		if err != nil {
+			t.Errorf("%s 创建待签交易单哈希失败: %v", test.name, err)
+			continue
+		}
+
+		if transHash[0].GetNormalTxAddress() != signAddress {
+			t.Errorf("%s 签名地址错误: %s", test.name, transHash[0].GetNormalTxAddress())
+			continue
+		}
+
+		signed := true
+		for i := range transHash {
+			sigPub, err := SignRawTransactionHash(transHash[i].GetTxHashHex(), inPrikey)
+			if err != nil {
+				t.Errorf("%s hash签名失败: %v", test.name, err)
+				signed = false
+				break
+			}
+			transHash[i].Normal.SigPub = *sigPub
+		}
+		if !signed {
+			continue
+		}
+
+		signedTrans, err := InsertSignatureIntoEmptyTransaction(emptyTrans, transHash, unlockData, test.segwit)
+		if err != nil {
+			t.Errorf("%s 插入交易单失败: %v", test.name, err)
+			continue
+		}
+		fmt.Println(test.name, "合并之后的交易单:", signedTrans)
+
+		if !VerifyRawTransaction(signedTrans, unlockData, test.segwit, addressPrefix) {
+			t.Errorf("%s 验证失败!", test.name)
+			continue
+		}
+
+		//隔离见证交易单按赎回脚本识别时间锁输入
+		if test.segwit {
+			signedBytes, _ := hex.DecodeString(signedTrans)
+			decoded, err := DecodeRawTransaction(signedBytes, true)
+			if err != nil || !decoded.Witness || decoded.Vins[0].inType != TypeTimeLock || decoded.Vins[1].inType != TypeP2WPKH {
+				t.Errorf("%s 时间锁输入识别错误: %v", test.name, err)
+			}
+		}
+	}
+}
+
+func Test_checkStrictDER(t *testing.T) {
+	sig := make([]byte, 64)
+	for i := range sig {
+		sig[i] = 0x11
+	}
+
+	//最小编码
+	script := SignaturePubkey{Signature: sig}.encodeSignatureToScript(SigHashAll)
+	if err := checkStrictDER(script[1:]); err != nil {
+		t.Errorf("canonical DER signature rejected: %v", err)
+	}
+
+	//r多余的0x00填充
+	padded, _ := hex.DecodeString("3007020200010201020001")
+	if err := checkStrictDER(padded); err == nil {
+		t.Error("non-canonical DER signature accepted")
+	}
+
+	//r为负数
+	negative, _ := hex.DecodeString("300602018002010201")
+	if err := checkStrictDER(negative); err == nil {
+		t.Error("negative r accepted")
+	}
+}
+
+func Test_VerifyHighS(t *testing.T) {
+	in := Vin{"d134ceb5255b6e4901ce768ffa658807e4ebe5d4874d78e3e95497a27f539401", uint32(0)}
+	out := Vout{"VJzZnE5jLoUCoG58UR9PHx9ssKTQBAaRN7", uint64(900000)}
+	addressPrefix := AddressPrefix{[]byte{0x46}, []byte{0x05}, nil, "vas"}
+	unlockData := TxUnlock{"76a914d46043209073ad39879356295562d952cd9dae3a88ac", "", 0, SigHashAll}
+
+	emptyTrans, err := CreateEmptyRawTransaction([]Vin{in}, []Vout{out}, 0, false, addressPrefix)
+	if err != nil {
+		t.Errorf("create empty transaction failed: %v", err)
+		return
+	}
+
+	transHash, err := CreateRawTransactionHashForSig(emptyTrans, []TxUnlock{unlockData}, false, addressPrefix)
+	if err != nil {
+		t.Errorf("create transaction hash failed: %v", err)
+		return
+	}
+
+	inPrikey := []byte{0x80, 0xbc, 0x39, 0x8d, 0x7c, 0x4a, 0x67, 0x4d, 0xaa, 0x97, 0x75, 0x66, 0xc2, 0xe6, 0xcd, 0x50, 0x40, 0x52, 0x00, 0x27, 0xe5, 0x7f, 0xe8, 0x06, 0xdf, 0xaa, 0x86, 0x8d, 0xf4, 0xcc, 0x43, 0xab}
+	sigPub, err := SignRawTransactionHash(transHash[0].GetTxHashHex(), inPrikey)
+	if err != nil {
+		t.Errorf("sign transaction hash failed: %v", err)
+		return
+	}
+	if !IsLowS(sigPub.Signature) {
+		t.Error("signature is not low S")
+		return
+	}
+
+	//s替换为n-s，签名数学上仍有效
+	numS := new(big.Int).SetBytes(sigPub.Signature[32:])
+	numS.Sub(new(big.Int).SetBytes(CurveOrder), numS)
+	highS := append(append([]byte{}, sigPub.Signature[:32]...), numS.Bytes()...)
+	transHash[0].Normal.SigPub = SignaturePubkey{highS, sigPub.Pubkey}
+
+	signedTrans, err := InsertSignatureIntoEmptyTransaction(emptyTrans, transHash, []TxUnlock{unlockData}, false)
+	if err != nil {
+		t.Errorf("insert signature failed: %v", err)
+		return
+	}
+
+	err = VerifyRawTransactionWithError(signedTrans, []TxUnlock{unlockData}, false, addressPrefix)
+	verifyErr, ok := err.(*VerifyError)
+	if !ok || verifyErr.Index != 0 {
+		t.Errorf("high S signature should be rejected at input 0, err: %v", err)
+	}
+}
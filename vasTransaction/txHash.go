@@ -75,6 +75,9 @@ func newTxHash(hash, lockscript, redeem []byte, inType, sigType byte, addressPre
 			multiTx = append(multiTx, MultiTx{p, sigType, SignaturePubkey{nil, nil}})
 		}
 		return &TxHash{hex.EncodeToString(hash), nRequired, nil, multiTx}, nil
+	} else if inType == TypeTimeLock {
+		_, pubkey, _ := parseTimeLockRedeemScript(redeem)
+		return &TxHash{hex.EncodeToString(hash), 0, &NormalTx{EncodeCheck(p2pkhPrefixByte, owcrypt.Hash(pubkey, 0, owcrypt.HASH_ALG_HASH160)), sigType, SignaturePubkey{nil, nil}}, nil}, nil
 	}
 	return nil, nil
 }
@@ -100,6 +103,9 @@ func checkScriptType(scriptPubkey, redeemScript string) ([]byte, []byte, byte, e
 		if len(redeem) >= 37 && redeem[len(redeem)-1] == OpCheckMultiSig {
 			return script, redeem, TypeMultiSig, nil
 		}
+		if _, _, ok := parseTimeLockRedeemScript(redeem); ok {
+			return script, redeem, TypeTimeLock, nil
+		}
 	} else if len(script) == 22 && script[0] == 0x00 && script[1] == 0x14 {
 		if redeemScript != "" {
 			return nil, nil, 0, errors.New("Found redeemScript when unlock a bech32 input!")
@@ -210,6 +216,18 @@ func (t Transaction) getBytesForSig(lockBytes, redeemBytes []byte, inType, sigTy
 	} else if inType == TypeBech32 {
 		if sigType == SigHashAll {
 			sigBytes, err = t.getSegwitBytesForSig(lockBytes, t.Vins[index].TxID, t.Vins[index].Vout, t.Vins[index].sequence, sigType, amount)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			// TODO
+			return nil, errors.New("The sigType inputed is not supported yet!")
+		}
+	} else if inType == TypeTimeLock {
+		if sigType == SigHashAll {
+			t.Vins[index].scriptPub = redeemBytes
+			sigBytes, err = t.encodeToBytes(SegwitON)
+
 			if err != nil {
 				return nil, err
 			}
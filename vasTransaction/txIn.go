@@ -10,6 +10,7 @@ const (
 	TypeP2WPKH   = 2
 	TypeBech32   = 3
 	TypeMultiSig = 4
+	TypeTimeLock = 5
 )
 
 type TxIn struct {
@@ -34,6 +35,16 @@ func (in TxIn) GetVout() uint32 {
 	return littleEndianBytesToUint32(in.Vout)
 }
 
+// GetSequence 输入的sequence
+func (in TxIn) GetSequence() uint32 {
+	return littleEndianBytesToUint32(in.sequence)
+}
+
+// IsSigned 输入是否已填充解锁脚本
+func (in TxIn) IsSigned() bool {
+	return len(in.scriptSig) > 0 || len(in.scriptMulti) > 0
+}
+
 func newTxInForEmptyTrans(vin []Vin) ([]TxIn, error) {
 	if vin == nil || len(vin) == 0 {
 		return nil, errors.New("No input found when create an empty transaction!")
@@ -82,7 +93,7 @@ func (in TxIn) toBytes(SegwitON bool) ([]byte, error) {
 			ret = append(ret, byte(len(in.scriptPub)))
 			ret = append(ret, in.scriptPub...)
 		}
-	} else if in.inType == TypeP2PKH {
+	} else if in.inType == TypeP2PKH || in.inType == TypeTimeLock {
 		ret = append(ret, byte(len(in.scriptSig)))
 		ret = append(ret, in.scriptSig...)
 	} else if in.inType == TypeP2WPKH {
@@ -109,7 +120,7 @@ func (in TxIn) toBytes(SegwitON bool) ([]byte, error) {
 
 func (in TxIn) toSegwitBytes() ([]byte, error) {
 	var ret []byte
-	if in.inType == TypeP2PKH {
+	if in.inType == TypeP2PKH || in.inType == TypeTimeLock {
 		ret = append(ret, 0x00)
 	} else if in.inType == TypeP2WPKH || in.inType == TypeBech32 {
 		ret = append(ret, 0x02)
@@ -1,6 +1,7 @@
 package vasTransaction
 
 import (
+	"encoding/hex"
 	"errors"
 	"strings"
 )
@@ -66,6 +67,98 @@ func newTxOutForEmptyTrans(vout []Vout, addressPrefix AddressPrefix) ([]TxOut, e
 	return ret, nil
 }
 
+// AddressToScriptPubKey 根据地址生成输出锁定脚本
+func AddressToScriptPubKey(address string, addressPrefix AddressPrefix) ([]byte, error) {
+	outs, err := newTxOutForEmptyTrans([]Vout{{Address: address}}, addressPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return outs[0].lockScript, nil
+}
+
+// GetAmount 输出金额
+func (out TxOut) GetAmount() uint64 {
+	return littleEndianBytesToUint64(out.amount)
+}
+
+// GetLockScript 输出锁定脚本
+func (out TxOut) GetLockScript() string {
+	return hex.EncodeToString(out.lockScript)
+}
+
+// ScriptPubKeyToAddress 根据P2PKH、P2SH锁定脚本还原地址，其他类型的脚本返回错误
+func ScriptPubKeyToAddress(script []byte, addressPrefix AddressPrefix) (string, error) {
+	if len(script) == 25 && script[0] == OpCodeDup && script[1] == OpCodeHash160 && script[2] == 0x14 && script[23] == OpCodeEqualVerify && script[24] == OpCodeCheckSig {
+		return EncodeCheck(addressPrefix.P2PKHPrefix, script[3:23]), nil
+	}
+	if len(script) == 23 && script[0] == OpCodeHash160 && script[1] == 0x14 && script[22] == OpCodeEqual {
+		prefix := addressPrefix.P2SHPrefix
+		if prefix == nil {
+			prefix = addressPrefix.P2WPKHPrefix
+		}
+		return EncodeCheck(prefix, script[2:22]), nil
+	}
+	return "", errors.New("Unsupported lock script type!")
+}
+
+// GetNullData 解析OP_RETURN输出携带的数据，非数据输出返回false
+func (out TxOut) GetNullData() ([]byte, bool) {
+	script := out.lockScript
+	if len(script) == 0 || script[0] != OpCodeReturn {
+		return nil, false
+	}
+	if len(script) == 1 {
+		return []byte{}, true
+	}
+
+	index := 1
+	dataLen := int(script[index])
+	if script[index] == OpPushData1 {
+		if len(script) < 3 {
+			return nil, true
+		}
+		index++
+		dataLen = int(script[index])
+	} else if script[index] > OpPushData1 {
+		return script[1:], true
+	}
+	index++
+
+	if index+dataLen > len(script) {
+		return script[1:], true
+	}
+	return script[index : index+dataLen], true
+}
+
+// NullDataScriptPubKey 根据携带的数据生成OP_RETURN输出锁定脚本
+func NullDataScriptPubKey(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("No data to embed in OP_RETURN output!")
+	}
+	if len(data) > MaxDataCarrierSize {
+		return nil, errors.New("Data to embed in OP_RETURN output is too large!")
+	}
+
+	script := []byte{OpCodeReturn}
+	if len(data) < int(OpPushData1) {
+		script = append(script, byte(len(data)))
+	} else {
+		script = append(script, OpPushData1, byte(len(data)))
+	}
+	script = append(script, data...)
+
+	return script, nil
+}
+
+func newNullDataTxOut(data []byte) (*TxOut, error) {
+	script, err := NullDataScriptPubKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxOut{uint64ToLittleEndianBytes(0), script}, nil
+}
+
 func (out TxOut) toBytes() ([]byte, error) {
 	if out.amount == nil || len(out.amount) != 8 {
 		return nil, errors.New("Invalid amount for a transaction output!")
@@ -22,6 +22,7 @@ const (
 	DefaultTxVersion     = uint32(1)
 	DefaultHashType      = uint32(1)
 	MaxScriptElementSize = 520
+	MaxDataCarrierSize   = 80
 )
 
 const (
@@ -29,6 +30,15 @@ const (
 	SequenceMaxBip125RBF = uint32(0xFFFFFFFD)
 )
 
+const (
+	TxVersionBIP68              = uint32(2)
+	LockTimeThreshold           = uint32(500000000)
+	SequenceLockTimeDisableFlag = uint32(1 << 31)
+	SequenceLockTimeTypeFlag    = uint32(1 << 22)
+	SequenceLockTimeMask        = uint32(0x0000FFFF)
+	SequenceLockTimeGranularity = uint32(9)
+)
+
 const (
 	SegWitSymbol  = byte(0)
 	SegWitVersion = byte(1)
@@ -41,6 +51,12 @@ const (
 	OpCodeEqualVerify = byte(0x88)
 	OpCodeCheckSig    = byte(0xAC)
 	OpCodeDup         = byte(0x76)
+	OpCodeReturn      = byte(0x6A)
+	OpCodeDrop        = byte(0x75)
+	OpCodeCLTV        = byte(0xB1)
+	OpCodeCSV         = byte(0xB2)
+	OpCode_0          = byte(0x00)
+	OpCode_16         = byte(0x60)
 	OpCode_1          = byte(0x51)
 	OpCheckMultiSig   = byte(0xAE)
 	OpPushData1       = byte(0x4C)
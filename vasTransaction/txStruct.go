@@ -89,6 +89,55 @@ func (t Transaction) encodeToBytes(SegwitON bool) ([]byte, error) {
 	return ret, nil
 }
 
+// GetLockTime 交易单的锁定时间
+func (t Transaction) GetLockTime() uint32 {
+	return littleEndianBytesToUint32(t.LockTime)
+}
+
+// IsReplaceable 任一输入的sequence小于0xFFFFFFFE时，交易单可被追加手续费替换(BIP125)
+func (t Transaction) IsReplaceable() bool {
+	for _, in := range t.Vins {
+		if in.GetSequence() <= SequenceMaxBip125RBF {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSigned 所有输入是否都已填充解锁脚本
+func (t Transaction) IsSigned() bool {
+	for _, in := range t.Vins {
+		if !in.IsSigned() {
+			return false
+		}
+	}
+	return len(t.Vins) > 0
+}
+
+// GetVSize 交易单的虚拟大小，隔离见证数据按1/4计算
+func (t Transaction) GetVSize(SegwitON bool) (uint64, error) {
+	txBytes, err := t.encodeToBytes(SegwitON)
+	if err != nil {
+		return 0, err
+	}
+	if !t.Witness {
+		return uint64(len(txBytes)), nil
+	}
+
+	//去除隔离见证标识和见证数据后的大小
+	stripped := len(txBytes) - 2
+	for _, in := range t.Vins {
+		swBytes, err := in.toSegwitBytes()
+		if err != nil {
+			return 0, err
+		}
+		stripped -= len(swBytes)
+	}
+
+	weight := stripped*3 + len(txBytes)
+	return uint64((weight + 3) / 4), nil
+}
+
 func DecodeRawTransaction(txBytes []byte, SegwitON bool) (*Transaction, error) {
 	limit := len(txBytes)
 
@@ -107,8 +156,8 @@ func DecodeRawTransaction(txBytes []byte, SegwitON bool) (*Transaction, error) {
 	rawTx.Version = txBytes[index : index+4]
 	index += 4
 
-	if littleEndianBytesToUint32(rawTx.Version) != DefaultTxVersion {
-		return nil, errors.New("Only transaction version 2 is supported right now!")
+	if version := littleEndianBytesToUint32(rawTx.Version); version != DefaultTxVersion && version != TxVersionBIP68 {
+		return nil, errors.New("Only transaction version 1 and 2 is supported right now!")
 	}
 
 	if index+2 > limit {
@@ -187,10 +236,21 @@ func DecodeRawTransaction(txBytes []byte, SegwitON bool) (*Transaction, error) {
 			}
 			tmpTxIn.scriptSig = txBytes[index : index+scriptLen]
 			index += int(scriptLen)
-		} else {
-			if rawTx.Witness {
+		} else if rawTx.Witness {
+			//隔离见证交易单中，较长的非见证解锁脚本只能是赎回脚本为时间锁脚本的输入
+			if scriptLen >= 0xFD {
+				return nil, errors.New("Invalid transaction data!")
+			}
+			if index+scriptLen > limit {
+				return nil, errors.New("Invalid transaction data length!")
+			}
+			if !isTimeLockScriptSig(txBytes[index : index+scriptLen]) {
 				return nil, errors.New("Invalid transaction data!")
 			}
+			tmpTxIn.inType = TypeTimeLock
+			tmpTxIn.scriptSig = txBytes[index : index+scriptLen]
+			index += scriptLen
+		} else {
 			tmpTxIn.inType = TypeMultiSig
 			if scriptLen == 0xFD {
 				if index+2 > limit {
@@ -261,7 +321,7 @@ func DecodeRawTransaction(txBytes []byte, SegwitON bool) (*Transaction, error) {
 			if index+1 > limit {
 				return nil, errors.New("Invalid transaction data length!")
 			}
-			if rawTx.Vins[i].inType == TypeP2PKH {
+			if rawTx.Vins[i].inType == TypeP2PKH || rawTx.Vins[i].inType == TypeTimeLock {
 				if txBytes[index] != 0x00 {
 					return nil, errors.New("Invalid transaction data!")
 				}
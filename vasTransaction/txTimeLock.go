@@ -0,0 +1,189 @@
+package vasTransaction
+
+import (
+	"encoding/hex"
+	"errors"
+
+	owcrypt "github.com/blocktree/go-owcrypt"
+)
+
+// CreateCLTVAddress 创建绝对时间锁(CLTV)的P2SH地址，lockTime小于500000000为区块高度，否则为时间戳
+// 返回地址和赎回脚本
+func CreateCLTVAddress(lockTime uint32, pubkey []byte, addressPrefix AddressPrefix) (string, string, error) {
+	redeem, err := newTimeLockRedeemScript(lockTime, OpCodeCLTV, pubkey)
+	if err != nil {
+		return "", "", err
+	}
+	return newP2SHAddress(redeem, addressPrefix), hex.EncodeToString(redeem), nil
+}
+
+// CreateCSVAddress 创建相对时间锁(CSV)的P2SH地址，sequence按BIP68编码，可由NewRelativeLockSequence生成
+// 返回地址和赎回脚本
+func CreateCSVAddress(sequence uint32, pubkey []byte, addressPrefix AddressPrefix) (string, string, error) {
+	if sequence&SequenceLockTimeDisableFlag != 0 {
+		return "", "", errors.New("Relative lock time is disabled in sequence!")
+	}
+	redeem, err := newTimeLockRedeemScript(sequence, OpCodeCSV, pubkey)
+	if err != nil {
+		return "", "", err
+	}
+	return newP2SHAddress(redeem, addressPrefix), hex.EncodeToString(redeem), nil
+}
+
+// NewRelativeLockSequence 按BIP68生成相对时间锁的sequence，byTime为true时value为秒数（按512秒向上取整），否则为区块数
+func NewRelativeLockSequence(value uint32, byTime bool) (uint32, error) {
+	if byTime {
+		value = (value + (1 << SequenceLockTimeGranularity) - 1) >> SequenceLockTimeGranularity
+		if value > SequenceLockTimeMask {
+			return 0, errors.New("Relative lock time is too large!")
+		}
+		return SequenceLockTimeTypeFlag | value, nil
+	}
+	if value > SequenceLockTimeMask {
+		return 0, errors.New("Relative lock blocks is too large!")
+	}
+	return value, nil
+}
+
+// SetInputSequence 设置空交易单指定输入的sequence，启用相对时间锁时交易版本升级为2
+func SetInputSequence(txHex string, index int, sequence uint32) (string, error) {
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return "", errors.New("Invalid transaction hex string!")
+	}
+
+	emptyTrans, err := DecodeRawTransaction(txBytes, false)
+	if err != nil {
+		return "", err
+	}
+
+	if index < 0 || index >= len(emptyTrans.Vins) {
+		return "", errors.New("Input index out of range!")
+	}
+
+	emptyTrans.Vins[index].sequence = uint32ToLittleEndianBytes(sequence)
+
+	if sequence&SequenceLockTimeDisableFlag == 0 {
+		emptyTrans.Version = uint32ToLittleEndianBytes(TxVersionBIP68)
+	}
+
+	ret, err := emptyTrans.encodeToBytes(false)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(ret), nil
+}
+
+func newP2SHAddress(redeem []byte, addressPrefix AddressPrefix) string {
+	prefix := addressPrefix.P2SHPrefix
+	if prefix == nil {
+		prefix = addressPrefix.P2WPKHPrefix
+	}
+	return EncodeCheck(prefix, owcrypt.Hash(redeem, 0, owcrypt.HASH_ALG_HASH160))
+}
+
+// <lock> OP_CHECKLOCKTIMEVERIFY/OP_CHECKSEQUENCEVERIFY OP_DROP <pubkey> OP_CHECKSIG
+func newTimeLockRedeemScript(lock uint32, opCode byte, pubkey []byte) ([]byte, error) {
+	if len(pubkey) != 33 {
+		return nil, errors.New("Only compressed pubkey is supported!")
+	}
+	if lock == 0 {
+		return nil, errors.New("Invalid lock value!")
+	}
+
+	redeem := encodeScriptNum(lock)
+	redeem = append(redeem, opCode, OpCodeDrop, byte(len(pubkey)))
+	redeem = append(redeem, pubkey...)
+	redeem = append(redeem, OpCodeCheckSig)
+	return redeem, nil
+}
+
+// 最小编码的脚本数字
+func encodeScriptNum(num uint32) []byte {
+	if num == 0 {
+		return []byte{OpCode_0}
+	}
+	if num <= 16 {
+		return []byte{OpCode_1 + byte(num-1)}
+	}
+
+	var data []byte
+	for n := num; n > 0; n >>= 8 {
+		data = append(data, byte(n&0xFF))
+	}
+	if data[len(data)-1]&0x80 != 0 {
+		data = append(data, 0x00)
+	}
+	return append([]byte{byte(len(data))}, data...)
+}
+
+// 解析时间锁赎回脚本，返回锁定类型操作码和公钥
+func parseTimeLockRedeemScript(redeem []byte) (byte, []byte, bool) {
+	if len(redeem) < 38 {
+		return 0, nil, false
+	}
+
+	index := 0
+	if redeem[0] >= OpCode_1 && redeem[0] <= OpCode_16 {
+		index = 1
+	} else if redeem[0] >= 1 && redeem[0] <= 5 {
+		index = 1 + int(redeem[0])
+	} else {
+		return 0, nil, false
+	}
+
+	if len(redeem) != index+37 {
+		return 0, nil, false
+	}
+
+	opCode := redeem[index]
+	if (opCode != OpCodeCLTV && opCode != OpCodeCSV) || redeem[index+1] != OpCodeDrop || redeem[index+2] != 33 || redeem[len(redeem)-1] != OpCodeCheckSig {
+		return 0, nil, false
+	}
+
+	return opCode, redeem[index+3 : index+36], true
+}
+
+// <sig> <redeem>
+func (t TxHash) encodeTimeLockToScript(redeem []byte) ([]byte, error) {
+	if t.Normal == nil || len(t.Normal.SigPub.Signature) != 64 {
+		return nil, errors.New("Invalid signature data!")
+	}
+	if redeem == nil || len(redeem) >= int(OpPushData1) {
+		return nil, errors.New("Invalid redeem script for time lock!")
+	}
+	ret := t.Normal.SigPub.encodeSignatureToScript(t.Normal.SigType)
+	ret = append(ret, byte(len(redeem)))
+	ret = append(ret, redeem...)
+	return ret, nil
+}
+
+// 判断 <sig> <redeem> 解锁脚本中的赎回脚本是否为时间锁脚本
+func isTimeLockScriptSig(script []byte) bool {
+	if len(script) == 0 {
+		return false
+	}
+	sigLen := int(script[0])
+	if sigLen == 0 || sigLen+2 > len(script) {
+		return false
+	}
+	redeemLen := int(script[sigLen+1])
+	if sigLen+2+redeemLen != len(script) {
+		return false
+	}
+	_, _, ok := parseTimeLockRedeemScript(script[sigLen+2:])
+	return ok
+}
+
+// 从 <sig> <redeem> 中解析签名
+func decodeTimeLockScript(script []byte) ([]byte, byte, error) {
+	if len(script) == 0 {
+		return nil, 0, errors.New("Invalid script data!")
+	}
+	sigLen := int(script[0])
+	if sigLen+1 > len(script) {
+		return nil, 0, errors.New("Invalid script data!")
+	}
+	return decodeSignatureFromScript(script[:sigLen+1])
+}